@@ -0,0 +1,178 @@
+package energontrol
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/dernate/gopcxmlda"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// BrowseElement an element of the OPC item tree
+type BrowseElement struct {
+	Name        string          `json:"name"`
+	ItemName    string          `json:"item_name"`
+	IsItem      bool            `json:"is_item"`
+	HasChildren bool            `json:"has_children"`
+	Children    []BrowseElement `json:"children,omitempty"`
+}
+
+// ItemValue a value read from the Server. Value has the Go type the server's data type was decoded to, Type names it.
+type ItemValue struct {
+	ItemName string `json:"item_name"`
+	Value    any    `json:"value"`
+	Type     string `json:"type"`
+	Err      error  `json:"-"`
+}
+
+// ItemReader is implemented by Controllers, which can browse and read arbitrary items, like ServerController
+type ItemReader interface {
+	BrowseItems(ctx context.Context, ItemName string, Depth int) ([]BrowseElement, error)
+	ReadItems(ctx context.Context, ItemName ...string) ([]ItemValue, error)
+}
+
+// PlantItem Get the item name of an item of a plant, e.g. PlantItem(2, "Ctrl/Ctrl") is "Loc/Wec/Plant2/Ctrl/Ctrl"
+func PlantItem(PlantNo uint8, Path string) string {
+	if Path == "" {
+		return fmt.Sprintf("Loc/Wec/Plant%d", PlantNo)
+	}
+	return fmt.Sprintf("Loc/Wec/Plant%d/%s", PlantNo, strings.TrimPrefix(Path, "/"))
+}
+
+// BrowseItems Browse the item tree below ItemName, e.g. "Loc" for the park or PlantItem(2, "") for a plant.
+// Depth limits the levels, 1 returns only the direct children and 0 the whole tree.
+func BrowseItems(ctx context.Context, Server gopcxmlda.Server, ItemName string, Depth int) ([]BrowseElement, error) {
+	ctx, span := startSpan(ctx, "BrowseItems", attribute.String("opc.item_name", ItemName), attribute.Int("energontrol.browse.depth", Depth))
+	defer span.End()
+	return browseItems(ctx, Server, ItemName, Depth, 1)
+}
+
+func browseItems(ctx context.Context, Server gopcxmlda.Server, ItemName string, Depth int, level int) ([]BrowseElement, error) {
+	var ClientRequestHandle string
+	b, err := opcBrowse(ctx, Server, ItemName, &ClientRequestHandle, "", gopcxmlda.TBrowseOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("browse %s: %w", ItemName, err)
+	}
+	elements := make([]BrowseElement, 0, len(b.Response.Elements))
+	for _, e := range b.Response.Elements {
+		element := BrowseElement{Name: e.Name, ItemName: e.ItemName, IsItem: e.IsItem, HasChildren: e.HasChildren}
+		if e.HasChildren && (Depth == 0 || level < Depth) {
+			if element.Children, err = browseItems(ctx, Server, e.ItemName, Depth, level+1); err != nil {
+				return nil, err
+			}
+		}
+		elements = append(elements, element)
+	}
+	return elements, nil
+}
+
+// ReadItems Read the items in one request. The values are in the order of ItemName, items returned without a name are
+// taken by position. Items missing in the response or failed on the server have Err set.
+func ReadItems(ctx context.Context, Server gopcxmlda.Server, ItemName ...string) ([]ItemValue, error) {
+	ctx, span := startSpan(ctx, "ReadItems", attribute.Int("energontrol.items", len(ItemName)))
+	defer span.End()
+	if len(ItemName) == 0 {
+		return nil, fmt.Errorf("no ItemName provided")
+	}
+	items := make([]gopcxmlda.TItem, len(ItemName))
+	for i, name := range ItemName {
+		items[i] = gopcxmlda.TItem{ItemName: name}
+	}
+	var handle1 string
+	var handle2 []string
+	options := map[string]interface{}{
+		"returnItemName": true,
+	}
+	value, err := opcRead(ctx, Server, items, &handle1, &handle2, "", options)
+	if err != nil {
+		return nil, err
+	}
+	// the server may leave out items it doesn't know, items without a name are mapped by position
+	read, errs := itemsByName(value, ItemName, func(v any) (any, error) { return v, nil })
+	values := make([]ItemValue, len(ItemName))
+	for i, name := range ItemName {
+		values[i] = ItemValue{ItemName: name, Err: errs[i]}
+		if errs[i] == nil {
+			values[i].Value = read[i]
+			values[i].Type = fmt.Sprintf("%T", read[i])
+		}
+	}
+	return values, nil
+}
+
+// MarshalJSON Encode the value with the text of Err as "error"
+func (v ItemValue) MarshalJSON() ([]byte, error) {
+	type plain ItemValue
+	var errText string
+	if v.Err != nil {
+		errText = v.Err.Error()
+	}
+	return json.Marshal(struct {
+		plain
+		Error string `json:"error,omitempty"`
+	}{plain(v), errText})
+}
+
+// Float64 Get a numeric value as float64
+func (v ItemValue) Float64() (float64, error) {
+	if v.Err != nil {
+		return 0, v.Err
+	}
+	f, err := toFloat64(v.Value)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", v.ItemName, err)
+	}
+	return f, nil
+}
+
+// Int64 Get an integer value as int64
+func (v ItemValue) Int64() (int64, error) {
+	if v.Err != nil {
+		return 0, v.Err
+	}
+	i, err := toInt64(v.Value)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", v.ItemName, err)
+	}
+	return i, nil
+}
+
+// Uint64 Get a non-negative integer value as uint64
+func (v ItemValue) Uint64() (uint64, error) {
+	if v.Err != nil {
+		return 0, v.Err
+	}
+	u, err := toUint64(v.Value)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", v.ItemName, err)
+	}
+	return u, nil
+}
+
+// Bool Get a boolean value, integers are true if not 0
+func (v ItemValue) Bool() (bool, error) {
+	if v.Err != nil {
+		return false, v.Err
+	}
+	if b, ok := v.Value.(bool); ok {
+		return b, nil
+	}
+	i, err := toInt64(v.Value)
+	if err != nil {
+		return false, fmt.Errorf("%s: value %v of type %T is no boolean", v.ItemName, v.Value, v.Value)
+	}
+	return i != 0, nil
+}
+
+// Text Get a string value, other values formatted with %v
+func (v ItemValue) Text() (string, error) {
+	if v.Err != nil {
+		return "", v.Err
+	}
+	if s, ok := v.Value.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprintf("%v", v.Value), nil
+}
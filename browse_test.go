@@ -0,0 +1,109 @@
+package energontrol_test
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/dernate/energontrol"
+	"github.com/dernate/energontrol/energontroltest"
+	"github.com/dernate/gopcxmlda"
+)
+
+func TestItemValue(t *testing.T) {
+	cases := []struct {
+		value    any
+		float    float64
+		integer  int64
+		unsigned bool // Uint64 succeeds
+	}{
+		{uint16(3), 3, 3, true},
+		{int32(-2), -2, -2, false},
+		{float32(1.5), 1.5, 0, false},
+		{float64(7), 7, 7, true},
+		{uint64(42), 42, 42, true},
+	}
+	for _, tc := range cases {
+		v := energontrol.ItemValue{ItemName: "x", Value: tc.value}
+		if f, err := v.Float64(); err != nil || f != tc.float {
+			t.Errorf("Error: Float64 of %T %v: %g, %v", tc.value, tc.value, f, err)
+		}
+		i, err := v.Int64()
+		if tc.float == float64(tc.integer) && (err != nil || i != tc.integer) {
+			t.Errorf("Error: Int64 of %T %v: %d, %v", tc.value, tc.value, i, err)
+		} else if tc.float != float64(tc.integer) && err == nil {
+			t.Errorf("Error: Int64 of %T %v accepted", tc.value, tc.value)
+		}
+		if _, err = v.Uint64(); (err == nil) != tc.unsigned {
+			t.Errorf("Error: Uint64 of %T %v: %v", tc.value, tc.value, err)
+		}
+	}
+	if _, err := (energontrol.ItemValue{Value: "on"}).Float64(); err == nil {
+		t.Errorf("Error: string converted to float")
+	}
+	if b, err := (energontrol.ItemValue{Value: uint8(1)}).Bool(); err != nil || !b {
+		t.Errorf("Error: Bool %t, %v", b, err)
+	}
+}
+
+func TestReadItems(t *testing.T) {
+	scada := energontroltest.NewScada(4711, 2)
+	scada.Items = map[string]any{
+		energontrol.PlantItem(2, "Data/Power"):     float32(1520.5),
+		energontrol.PlantItem(2, "Data/WindSpeed"): float32(9.1),
+		energontrol.PlantItem(2, "Status/Main"):    uint16(2),
+		"Loc/LocNo":                                uint64(4711),
+	}
+	var reader energontrol.ItemReader = scada
+	tree, err := reader.BrowseItems(context.Background(), energontrol.PlantItem(2, ""), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tree) != 2 || tree[0].Name != "Data" || !tree[0].HasChildren || len(tree[0].Children) != 2 || !tree[0].Children[0].IsItem {
+		t.Errorf("Error: %+v", tree)
+	}
+	if tree, _ = reader.BrowseItems(context.Background(), "Loc", 1); len(tree) != 2 || tree[1].Children != nil {
+		t.Errorf("Error: depth 1 %+v", tree)
+	}
+	values, err := reader.ReadItems(context.Background(), energontrol.PlantItem(2, "Data/Power"), energontrol.PlantItem(2, "Data/Missing"), "Loc/LocNo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, err := values[0].Float64(); err != nil || p != 1520.5 || values[0].Type != "float32" {
+		t.Errorf("Error: %+v, %v", values[0], err)
+	}
+	if _, err = values[1].Float64(); err == nil {
+		t.Errorf("Error: missing item has a value")
+	}
+	if n, err := values[2].Uint64(); err != nil || n != 4711 {
+		t.Errorf("Error: %d, %v", n, err)
+	}
+	if data, err := json.Marshal(values[1]); err != nil || !strings.Contains(string(data), `"error":"item Loc/Wec/Plant2/Data/Missing not returned`) {
+		t.Errorf("Error: %s, %v", data, err)
+	}
+}
+
+func TestReadItemsWithoutNames(t *testing.T) {
+	scada := energontroltest.NewScada(4711, 2)
+	scada.Items = map[string]any{
+		energontrol.PlantItem(2, "Data/Power"): float32(1520.5),
+	}
+	opc := energontroltest.NewOpcServer(scada)
+	opc.NoItemNames = true
+	ctx := energontrol.WithOpcClient(context.Background(), opc)
+	// the server returns the items without their names, they are taken by position
+	values, err := energontrol.ReadItems(ctx, gopcxmlda.Server{}, "Loc/LocNo", energontrol.PlantItem(2, "Data/Missing"), energontrol.PlantItem(2, "Data/Power"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := values[0].Uint64(); err != nil || n != 4711 || values[0].ItemName != "Loc/LocNo" {
+		t.Errorf("Error: %+v, %v", values[0], err)
+	}
+	if values[1].Err == nil || values[1].ItemName != energontrol.PlantItem(2, "Data/Missing") {
+		t.Errorf("Error: missing item %+v", values[1])
+	}
+	if p, err := values[2].Float64(); err != nil || p != 1520.5 || values[2].Type != "float32" {
+		t.Errorf("Error: %+v, %v", values[2], err)
+	}
+}
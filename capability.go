@@ -0,0 +1,213 @@
+package energontrol
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Capabilities of a plant in TurbineInfo
+const (
+	CapabilityCtrl   = "Ctrl"   // SetCtrl, for Start and Stop
+	CapabilityRbh    = "Rbh"    // SetRbh, for RbhOn, RbhAutoOff and RbhStandard
+	CapabilityReset  = "Reset"  // SetReset, for Reset
+	CapabilityPara   = "Para"   // Para branch
+	CapabilityIceDet = "IceDet" // SetIceDet
+)
+
+// CapabilityError the plant has no item for the command, e.g. no SetRbh for RbhOn
+type CapabilityError struct {
+	PlantNo    uint8
+	Capability string
+}
+
+func (e *CapabilityError) Error() string {
+	return fmt.Sprintf("plant %d: capability %s not available", e.PlantNo, e.Capability)
+}
+
+// CheckCapability Get a CapabilityError for each plant of T without the Capability, nil for the others
+func CheckCapability(T TurbineInfo, Capability string, PlantNo ...uint8) []error {
+	var has map[uint8]bool
+	switch Capability {
+	case CapabilityCtrl:
+		has = T.Ctrl
+	case CapabilityRbh:
+		has = T.Rbh
+	case CapabilityReset:
+		has = T.Reset
+	case CapabilityPara:
+		has = T.Para
+	case CapabilityIceDet:
+		has = T.IceDet
+	}
+	errList := make([]error, len(PlantNo))
+	for i, plant := range PlantNo {
+		if !has[plant] {
+			errList[i] = &CapabilityError{PlantNo: plant, Capability: Capability}
+		}
+	}
+	return errList
+}
+
+// CapabilityController Controller, which rejects commands for plants without the needed capability with a
+// CapabilityError before the wrapped Controller opens a session. The capabilities are taken from Discovery, or from the
+// Discovery of a wrapped ServerController, so both share one cache. Without one they are read with Turbines and cached for TTL.
+type CapabilityController struct {
+	Controller
+	Discovery *Discovery
+	TTL       time.Duration // without Discovery, 0 caches until Invalidate
+
+	mu     sync.Mutex
+	info   *TurbineInfo
+	readAt time.Time
+}
+
+// discovery Get the Discovery caching the capabilities, nil if there is none
+func (c *CapabilityController) discovery() *Discovery {
+	if c.Discovery != nil {
+		return c.Discovery
+	}
+	switch ctrl := c.Controller.(type) {
+	case ServerController:
+		return ctrl.Discovery
+	case *ServerController:
+		return ctrl.Discovery
+	}
+	return nil
+}
+
+// Turbines Read the TurbineInfo with the wrapped Controller and cache it, refresh the Discovery if there is one
+func (c *CapabilityController) Turbines(ctx context.Context) (TurbineInfo, error) {
+	if d := c.discovery(); d != nil {
+		T, _, err := d.Refresh(ctx)
+		return T, err
+	}
+	T, err := c.Controller.Turbines(ctx)
+	if err != nil {
+		return T, err
+	}
+	c.mu.Lock()
+	c.info, c.readAt = &T, time.Now()
+	c.mu.Unlock()
+	return T, nil
+}
+
+// Invalidate Drop the cached capabilities, the next command reads them again
+func (c *CapabilityController) Invalidate() {
+	if d := c.discovery(); d != nil {
+		d.Invalidate()
+		return
+	}
+	c.mu.Lock()
+	c.info = nil
+	c.mu.Unlock()
+}
+
+// capabilities Get the TurbineInfo of the Discovery, else the cached one, read it if there is none or it is older than TTL
+func (c *CapabilityController) capabilities(ctx context.Context) (TurbineInfo, error) {
+	if d := c.discovery(); d != nil {
+		return d.Turbines(ctx)
+	}
+	c.mu.Lock()
+	if c.info != nil && (c.TTL == 0 || time.Since(c.readAt) < c.TTL) {
+		T := *c.info
+		c.mu.Unlock()
+		return T, nil
+	}
+	c.mu.Unlock()
+	return c.Turbines(ctx)
+}
+
+// command Run cmd for the plants having all of the Capabilities, the others fail with a CapabilityError
+func (c *CapabilityController) command(ctx context.Context, PlantNo []uint8, Capabilities []string, cmd func(PlantNo ...uint8) ([]bool, []error)) ([]bool, []error) {
+	if len(PlantNo) == 0 {
+		return cmd()
+	}
+	ok := make([]bool, len(PlantNo))
+	errList := make([]error, len(PlantNo))
+	T, err := c.capabilities(ctx)
+	if err != nil {
+		for i := range PlantNo {
+			errList[i] = fmt.Errorf("read capabilities: %w", err)
+		}
+		return ok, errList
+	}
+	for _, Capability := range Capabilities {
+		for i, err := range CheckCapability(T, Capability, PlantNo...) {
+			if errList[i] == nil {
+				errList[i] = err
+			}
+		}
+	}
+	var capable []uint8
+	var index []int
+	for i, plant := range PlantNo {
+		if errList[i] == nil {
+			capable = append(capable, plant)
+			index = append(index, i)
+		}
+	}
+	if len(capable) == 0 {
+		return ok, errList
+	}
+	capableOk, capableErr := cmd(capable...)
+	for j, i := range index {
+		if j < len(capableOk) {
+			ok[i] = capableOk[j]
+		}
+		if j < len(capableErr) {
+			errList[i] = capableErr[j]
+		}
+	}
+	return ok, errList
+}
+
+func (c *CapabilityController) Start(ctx context.Context, UserId uint64, PlantNo ...uint8) ([]bool, []error) {
+	return c.command(ctx, PlantNo, []string{CapabilityCtrl}, func(PlantNo ...uint8) ([]bool, []error) {
+		return c.Controller.Start(ctx, UserId, PlantNo...)
+	})
+}
+
+func (c *CapabilityController) Stop(ctx context.Context, UserId uint64, FullStop bool, ForceExplicitCommand bool, PlantNo ...uint8) ([]bool, []error) {
+	return c.command(ctx, PlantNo, []string{CapabilityCtrl}, func(PlantNo ...uint8) ([]bool, []error) {
+		return c.Controller.Stop(ctx, UserId, FullStop, ForceExplicitCommand, PlantNo...)
+	})
+}
+
+func (c *CapabilityController) Reset(ctx context.Context, UserId uint64, PlantNo ...uint8) ([]bool, []error) {
+	return c.command(ctx, PlantNo, []string{CapabilityReset}, func(PlantNo ...uint8) ([]bool, []error) {
+		return c.Controller.Reset(ctx, UserId, PlantNo...)
+	})
+}
+
+func (c *CapabilityController) RbhOn(ctx context.Context, UserId uint64, PlantNo ...uint8) ([]bool, []error) {
+	return c.command(ctx, PlantNo, []string{CapabilityRbh}, func(PlantNo ...uint8) ([]bool, []error) {
+		return c.Controller.RbhOn(ctx, UserId, PlantNo...)
+	})
+}
+
+func (c *CapabilityController) RbhAutoOff(ctx context.Context, UserId uint64, PlantNo ...uint8) ([]bool, []error) {
+	return c.command(ctx, PlantNo, []string{CapabilityRbh}, func(PlantNo ...uint8) ([]bool, []error) {
+		return c.Controller.RbhAutoOff(ctx, UserId, PlantNo...)
+	})
+}
+
+func (c *CapabilityController) RbhStandard(ctx context.Context, UserId uint64, PlantNo ...uint8) ([]bool, []error) {
+	return c.command(ctx, PlantNo, []string{CapabilityRbh}, func(PlantNo ...uint8) ([]bool, []error) {
+		return c.Controller.RbhStandard(ctx, UserId, PlantNo...)
+	})
+}
+
+func (c *CapabilityController) ControlAndRbh(ctx context.Context, UserId uint64, Values ControlAndRbhValue, PlantNo ...uint8) ([]bool, []error) {
+	var Capabilities []string
+	if Values.SetCtrlValue {
+		Capabilities = append(Capabilities, CapabilityCtrl)
+	}
+	if Values.SetRbhValue {
+		Capabilities = append(Capabilities, CapabilityRbh)
+	}
+	return c.command(ctx, PlantNo, Capabilities, func(PlantNo ...uint8) ([]bool, []error) {
+		return c.Controller.ControlAndRbh(ctx, UserId, Values, PlantNo...)
+	})
+}
@@ -0,0 +1,42 @@
+package energontrol_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/dernate/energontrol"
+	"github.com/dernate/energontrol/energontroltest"
+)
+
+func TestCapabilityController(t *testing.T) {
+	scada := energontroltest.NewScada(4711, 1, 2, 3)
+	scada.SetPlant(1, energontroltest.Plant{Ctrl: energontrol.CtrlValues["Stop60"], Rbh: energontroltest.RbhStandardState})
+	scada.SetPlant(2, energontroltest.Plant{Ctrl: energontrol.CtrlValues["Stop60"], NoCtrl: true})
+	scada.SetPlant(3, energontroltest.Plant{Ctrl: energontrol.CtrlValues["Stop60"], NoReset: true})
+	c := &energontrol.CapabilityController{Controller: scada}
+
+	ok, errList := c.Start(context.Background(), 1234, 1, 2, 3)
+	var capErr *energontrol.CapabilityError
+	if !ok[0] || ok[1] || !ok[2] || !errors.As(errList[1], &capErr) || capErr.PlantNo != 2 || capErr.Capability != energontrol.CapabilityCtrl {
+		t.Errorf("Error: %v, %v", ok, errList)
+	}
+	if calls := scada.CallLog(); len(calls) != 1 || len(calls[0].PlantNo) != 2 {
+		t.Errorf("Error: plant without SetCtrl was sent the command: %+v", calls)
+	}
+
+	// no command at all, if no plant has the capability
+	ok, errList = c.Reset(context.Background(), 1234, 3)
+	if ok[0] || !errors.As(errList[0], &capErr) || capErr.Capability != energontrol.CapabilityReset {
+		t.Errorf("Error: %v, %v", ok, errList)
+	}
+	if calls := scada.CallLog(); len(calls) != 1 {
+		t.Errorf("Error: %+v", calls)
+	}
+
+	// ControlAndRbh needs the capabilities of the values it sets
+	Values := energontrol.ControlAndRbhValue{SetRbhValue: true, RbhValue: energontrol.RbhValues["ManualOn"]}
+	if _, errList = c.ControlAndRbh(context.Background(), 1234, Values, 2); !errors.As(errList[0], &capErr) || capErr.Capability != energontrol.CapabilityRbh {
+		t.Errorf("Error: %v", errList)
+	}
+}
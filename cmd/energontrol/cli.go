@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dernate/energontrol"
+	"github.com/dernate/gopcxmlda"
+)
+
+type options struct {
+	config  string
+	park    string
+	url     string
+	userId  string
+	creds   string
+	oper    string
+	locale  string
+	timeout time.Duration
+	output  string
+	yes     bool
+}
+
+func (o *options) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.config, "config", os.Getenv("ENERGONTROL_CONFIG"), "fleet config file (env ENERGONTROL_CONFIG)")
+	fs.StringVar(&o.park, "park", "", "park name in the fleet config, optional if it has only one park")
+	fs.StringVar(&o.url, "url", "", "OPC XML DA url, if no fleet config is used")
+	fs.StringVar(&o.userId, "user-id", os.Getenv("ENERGONTROL_USERID"), "Enercon user id, if no fleet config is used (env ENERGONTROL_USERID)")
+	fs.StringVar(&o.creds, "credentials", os.Getenv("ENERGONTROL_CREDENTIALS"), "credential file, which resolves the user id of -operator (env ENERGONTROL_CREDENTIALS)")
+	fs.StringVar(&o.oper, "operator", defaultOperator(), "operator name for -credentials and the audit log (env ENERGONTROL_OPERATOR, default $USER)")
+	fs.StringVar(&o.locale, "locale", "en-us", "OPC locale, if no fleet config is used")
+	fs.DurationVar(&o.timeout, "timeout", 10*time.Second, "OPC request timeout, if no fleet config is used")
+	fs.StringVar(&o.output, "output", "table", "output format: table or json")
+	fs.BoolVar(&o.yes, "yes", false, "do not ask for confirmation")
+}
+
+type cli struct {
+	opts   options
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+	park   energontrol.ParkConfig
+	server gopcxmlda.Server
+}
+
+func (c *cli) dispatch(ctx context.Context, command string, args []string) error {
+	if c.opts.output != "table" && c.opts.output != "json" {
+		return fail(exitUsage, "unknown output format %q", c.opts.output)
+	}
+	switch command {
+	case "status", "turbines", "start", "stop", "reset", "rbh", "parkno", "browse", "read", "snapshot":
+	case "export":
+		return c.export(ctx, args)
+	default:
+		return fail(exitUsage, "unknown command %q", command)
+	}
+	if err := c.connect(); err != nil {
+		return err
+	}
+	ctx = c.park.Context(ctx)
+	switch command {
+	case "status":
+		return c.status(ctx, args)
+	case "turbines":
+		return c.turbines(ctx, args)
+	case "parkno":
+		return c.parkNo(ctx, args)
+	case "browse":
+		return c.browse(ctx, args)
+	case "read":
+		return c.read(ctx, args)
+	case "snapshot":
+		return c.snapshot(ctx, args)
+	default:
+		return c.control(ctx, command, args)
+	}
+}
+
+// connect Resolve the park either from the fleet config or from the connection flags
+func (c *cli) connect() error {
+	if c.opts.config != "" {
+		fleet, err := energontrol.LoadFleetConfig(c.opts.config)
+		if err != nil {
+			return fail(exitConfig, "%w", err)
+		}
+		if c.opts.park == "" {
+			if len(fleet.Parks) != 1 {
+				return fail(exitUsage, "-park is required, the fleet config has %d parks", len(fleet.Parks))
+			}
+			c.park = fleet.Parks[0]
+		} else if c.park, err = fleet.Park(c.opts.park); err != nil {
+			return fail(exitConfig, "%w", err)
+		}
+	} else {
+		if c.opts.url == "" {
+			return fail(exitUsage, "either -config or -url is required")
+		}
+		c.park = energontrol.ParkConfig{
+			Name:     c.opts.url,
+			Url:      c.opts.url,
+			LocaleID: c.opts.locale,
+			Timeout:  c.opts.timeout,
+		}
+		if c.opts.userId != "" {
+			UserId, err := strconv.ParseUint(c.opts.userId, 10, 64)
+			if err != nil {
+				return fail(exitUsage, "invalid user id: %w", err)
+			}
+			c.park.UserId.Value = UserId
+		}
+		if _url, err := url.Parse(c.opts.url); err != nil || _url.Scheme == "" || _url.Host == "" {
+			return fail(exitUsage, "invalid url %q", c.opts.url)
+		}
+	}
+	var err error
+	c.server, err = c.park.Server()
+	if err != nil {
+		return fail(exitConfig, "%w", err)
+	}
+	return nil
+}
+
+func (c *cli) checkAvailable(ctx context.Context) error {
+	available, err := energontrol.ServerAvailable(ctx, c.server)
+	if err != nil {
+		return fail(exitUnavailable, "server %s not reachable: %w", c.park.Url, err)
+	}
+	if !available {
+		return fail(exitUnavailable, "server %s is not running", c.park.Url)
+	}
+	return nil
+}
+
+// defaultOperator the operator named by ENERGONTROL_OPERATOR, or the login name
+func defaultOperator() string {
+	if Operator := os.Getenv("ENERGONTROL_OPERATOR"); Operator != "" {
+		return Operator
+	}
+	return os.Getenv("USER")
+}
+
+// userId Resolve the user id of the operator for the park, first from -credentials, then from the park config or -user-id.
+// The returned context names the operator in the log.
+func (c *cli) userId(ctx context.Context) (context.Context, uint64, error) {
+	var providers energontrol.ChainCredentials
+	if c.opts.creds != "" {
+		C, err := energontrol.LoadCredentials(c.opts.creds)
+		if err != nil {
+			return ctx, 0, fail(exitConfig, "%w", err)
+		}
+		providers = append(providers, C)
+	}
+	if c.park.UserId.Value != 0 || c.park.UserId.Env != "" {
+		providers = append(providers, energontrol.FleetCredentials{Parks: []energontrol.ParkConfig{c.park}})
+	}
+	if len(providers) == 0 {
+		return ctx, 0, fail(exitUsage, "a user id is required for control commands")
+	}
+	ctx, UserId, err := energontrol.ResolveOperator(ctx, providers, c.park.Name, c.opts.oper)
+	if errors.Is(err, energontrol.ErrUnknownOperator) {
+		return ctx, 0, fail(exitUsage, "%w", err)
+	} else if err != nil {
+		return ctx, 0, fail(exitConfig, "%w", err)
+	}
+	return ctx, UserId, nil
+}
+
+// plants Resolve the plant selection, "all" selects every allowed turbine of the park
+func (c *cli) plants(ctx context.Context, args []string) ([]uint8, error) {
+	if len(args) == 0 {
+		return nil, fail(exitUsage, "no plants selected")
+	}
+	if len(args) == 1 && args[0] == "all" {
+		T, err := energontrol.Turbines(ctx, c.server)
+		if err != nil {
+			return nil, fail(exitUnavailable, "browse turbines: %w", err)
+		}
+		var PlantNo []uint8
+		for _, plant := range T.PlantNo {
+			if c.park.PlantAllowed(plant) {
+				PlantNo = append(PlantNo, plant)
+			}
+		}
+		if len(PlantNo) == 0 {
+			return nil, fail(exitUsage, "no allowed plants found")
+		}
+		return PlantNo, nil
+	}
+	PlantNo, err := parsePlants(c.park, args)
+	if err != nil {
+		return nil, fail(exitUsage, "%w", err)
+	}
+	return PlantNo, nil
+}
+
+// parseFlags Parse the flags of a command wherever they appear, e.g. "stop 3 --full", and return the other arguments.
+// Everything after "--" is taken as argument.
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	var rest []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		parsed := len(args) - fs.NArg()
+		if parsed > 0 && args[parsed-1] == "--" {
+			return append(rest, fs.Args()...), nil
+		}
+		if fs.NArg() == 0 {
+			return rest, nil
+		}
+		// flag stops at the first argument, continue after it
+		rest = append(rest, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// parsePlants Resolve plant numbers, ranges like "2-5" and aliases, also comma separated
+func parsePlants(P energontrol.ParkConfig, args []string) ([]uint8, error) {
+	var selection []string
+	for _, arg := range args {
+		for _, part := range strings.Split(arg, ",") {
+			if part == "" {
+				continue
+			}
+			from, to, isRange := strings.Cut(part, "-")
+			if _, ok := P.Aliases[part]; ok || !isRange {
+				selection = append(selection, part)
+				continue
+			}
+			first, err1 := strconv.ParseUint(from, 10, 8)
+			last, err2 := strconv.ParseUint(to, 10, 8)
+			if err1 != nil || err2 != nil || first > last {
+				return nil, fmt.Errorf("invalid plant range %q", part)
+			}
+			for n := first; n <= last; n++ {
+				selection = append(selection, strconv.FormatUint(n, 10))
+			}
+		}
+	}
+	PlantNo, err := P.ResolvePlants(selection...)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[uint8]bool)
+	var unique []uint8
+	for _, plant := range PlantNo {
+		if !seen[plant] {
+			seen[plant] = true
+			unique = append(unique, plant)
+		}
+	}
+	return unique, nil
+}
+
+// confirm Ask the operator before a command is sent to the plants
+func (c *cli) confirm(action string, PlantNo []uint8) error {
+	if c.opts.yes {
+		return nil
+	}
+	var names []string
+	for _, plant := range PlantNo {
+		names = append(names, c.park.PlantName(plant))
+	}
+	fmt.Fprintf(c.stderr, "%s %s in park %s? [y/N] ", action, strings.Join(names, ", "), c.park.Name)
+	answer, _ := bufio.NewReader(c.stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	if answer != "y" && answer != "yes" {
+		return fail(exitAborted, "aborted")
+	}
+	return nil
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+
+	"github.com/dernate/energontrol"
+)
+
+type plantStatus struct {
+	PlantNo   uint8    `json:"plant_no"`
+	Name      string   `json:"name"`
+	Ctrl      uint64   `json:"ctrl"`
+	CtrlText  string   `json:"ctrl_text"`
+	Rbh       uint64   `json:"rbh"`
+	RbhFlags  []string `json:"rbh_flags"`
+	ReadError string   `json:"error,omitempty"`
+}
+
+type plantResult struct {
+	PlantNo uint8  `json:"plant_no"`
+	Name    string `json:"name"`
+	Action  string `json:"action"`
+	Ok      bool   `json:"ok"`
+	Error   string `json:"error,omitempty"`
+}
+
+type turbine struct {
+	PlantNo uint8  `json:"plant_no"`
+	Name    string `json:"name"`
+	Ctrl    bool   `json:"ctrl"`
+	Rbh     bool   `json:"rbh"`
+	Reset   bool   `json:"reset"`
+	Para    bool   `json:"para"`
+	IceDet  bool   `json:"ice_det"`
+}
+
+func (c *cli) status(ctx context.Context, args []string) error {
+	if err := c.checkAvailable(ctx); err != nil {
+		return err
+	}
+	PlantNo, err := c.plants(ctx, args)
+	if err != nil {
+		return err
+	}
+	CtrlState, err := energontrol.GetPlantCtrlOrRbhState(ctx, c.server, "Ctrl", PlantNo)
+	if err != nil {
+		return fail(exitFailed, "read Ctrl state: %w", err)
+	}
+	RbhState, err := energontrol.GetPlantCtrlOrRbhState(ctx, c.server, "Rbh", PlantNo)
+	if err != nil {
+		return fail(exitFailed, "read Rbh state: %w", err)
+	}
+	var status []plantStatus
+	for i, plant := range PlantNo {
+		s := plantStatus{
+			PlantNo:  plant,
+			Name:     c.park.PlantName(plant),
+			Ctrl:     CtrlState[i].CtrlState,
+			CtrlText: energontrol.CtrlStateText(CtrlState[i].CtrlState),
+			Rbh:      RbhState[i].CtrlState,
+			RbhFlags: energontrol.RbhStateText(RbhState[i].CtrlState),
+		}
+		if err = errors.Join(CtrlState[i].Err, RbhState[i].Err); err != nil {
+			s.ReadError = err.Error()
+		}
+		status = append(status, s)
+	}
+	return c.print(status)
+}
+
+func (c *cli) turbines(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return fail(exitUsage, "turbines takes no arguments")
+	}
+	if err := c.checkAvailable(ctx); err != nil {
+		return err
+	}
+	T, err := energontrol.Turbines(ctx, c.server)
+	if err != nil {
+		return fail(exitFailed, "browse turbines: %w", err)
+	}
+	var turbines []turbine
+	for _, plant := range T.PlantNo {
+		if !c.park.PlantAllowed(plant) {
+			continue
+		}
+		turbines = append(turbines, turbine{
+			PlantNo: plant,
+			Name:    c.park.PlantName(plant),
+			Ctrl:    T.Ctrl[plant],
+			Rbh:     T.Rbh[plant],
+			Reset:   T.Reset[plant],
+			Para:    T.Para[plant],
+			IceDet:  T.IceDet[plant],
+		})
+	}
+	sort.Slice(turbines, func(i, j int) bool { return turbines[i].PlantNo < turbines[j].PlantNo })
+	return c.print(turbines)
+}
+
+func (c *cli) parkNo(ctx context.Context, args []string) error {
+	if len(args) != 1 || args[0] != "check" {
+		return fail(exitUsage, "usage: parkno check")
+	}
+	if c.park.ParkNo == 0 {
+		return fail(exitConfig, "no park_no configured for park %s", c.park.Name)
+	}
+	if err := c.checkAvailable(ctx); err != nil {
+		return err
+	}
+	match, err := energontrol.ParkNoMatch(ctx, c.server, c.park.ParkNo, false)
+	if err != nil {
+		return fail(exitFailed, "read ParkNo: %w", err)
+	}
+	if err = c.print(map[string]any{"park": c.park.Name, "park_no": c.park.ParkNo, "match": match}); err != nil {
+		return err
+	}
+	if !match {
+		return fail(exitParkNo, "server does not report ParkNo %d", c.park.ParkNo)
+	}
+	return nil
+}
+
+func (c *cli) control(ctx context.Context, command string, args []string) error {
+	var full, force bool
+	var stagger energontrol.StaggerOptions
+	fs := flag.NewFlagSet(command, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	if command == "start" {
+		fs.IntVar(&stagger.GroupSize, "group", 0, "start the plants in groups of this size, all at once if 0")
+		fs.DurationVar(&stagger.Delay, "delay", 0, "pause between two groups")
+		fs.BoolVar(&stagger.Verify, "verify", false, "wait until each group reports started, abort on failure")
+	}
+	if command == "stop" {
+		fs.BoolVar(&full, "full", false, "stop to Stop90 instead of Stop60")
+		fs.BoolVar(&force, "force", false, "force the explicit stop command, even if the plant is in a similar stop state")
+	}
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return fail(exitUsage, "%w", err)
+	}
+	action := command
+	if command == "rbh" {
+		if len(args) == 0 {
+			return fail(exitUsage, "usage: rbh on|auto-off|standard <plants>")
+		}
+		switch args[0] {
+		case "on", "auto-off", "standard":
+			action = "rbh " + args[0]
+		default:
+			return fail(exitUsage, "unknown rbh mode %q", args[0])
+		}
+		args = args[1:]
+	} else if command == "stop" {
+		if full {
+			action = "stop (Stop90)"
+		} else {
+			action = "stop (Stop60)"
+		}
+	}
+	ctx, UserId, err := c.userId(ctx)
+	if err != nil {
+		return err
+	}
+	if err = c.checkAvailable(ctx); err != nil {
+		return err
+	}
+	PlantNo, err := c.plants(ctx, args)
+	if err != nil {
+		return err
+	}
+	if err = c.confirm(action, PlantNo); err != nil {
+		return err
+	}
+	var ok []bool
+	var errList []error
+	switch action {
+	case "start":
+		if stagger.GroupSize > 0 {
+			stagger.AbortOnFailure = stagger.Verify
+			ok, errList = energontrol.StaggeredStart(ctx, c.server, UserId, stagger, PlantNo...)
+		} else {
+			ok, errList = energontrol.Start(ctx, c.server, UserId, PlantNo...)
+		}
+	case "reset":
+		ok, errList = energontrol.Reset(ctx, c.server, UserId, PlantNo...)
+	case "rbh on":
+		ok, errList = energontrol.RbhOn(ctx, c.server, UserId, PlantNo...)
+	case "rbh auto-off":
+		ok, errList = energontrol.RbhAutoOff(ctx, c.server, UserId, PlantNo...)
+	case "rbh standard":
+		ok, errList = energontrol.RbhStandard(ctx, c.server, UserId, PlantNo...)
+	default:
+		ok, errList = energontrol.Stop(ctx, c.server, UserId, full, force, PlantNo...)
+	}
+	var results []plantResult
+	failed := 0
+	for i, plant := range PlantNo {
+		r := plantResult{PlantNo: plant, Name: c.park.PlantName(plant), Action: action}
+		if i < len(ok) {
+			r.Ok = ok[i]
+		}
+		if i < len(errList) && errList[i] != nil {
+			r.Error = errList[i].Error()
+		}
+		if !r.Ok {
+			failed++
+		}
+		results = append(results, r)
+	}
+	if err = c.print(results); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fail(exitFailed, "%s failed for %d of %d plants", action, failed, len(PlantNo))
+	}
+	return nil
+}
+
+func (c *cli) export(ctx context.Context, args []string) error {
+	var format string
+	var fleet bool
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	fs.StringVar(&format, "format", "csv", "csv or json")
+	fs.BoolVar(&fleet, "fleet", false, "export all parks of the fleet config")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return fail(exitUsage, "%w", err)
+	}
+	if len(args) > 0 {
+		return fail(exitUsage, "export takes no plants")
+	}
+	if format != "csv" && format != "json" {
+		return fail(exitUsage, "unknown export format %q", format)
+	}
+	var parks []energontrol.ParkConfig
+	if fleet {
+		if c.opts.config == "" {
+			return fail(exitUsage, "--fleet needs -config")
+		}
+		F, err := energontrol.LoadFleetConfig(c.opts.config)
+		if err != nil {
+			return fail(exitConfig, "%w", err)
+		}
+		parks = F.Parks
+	} else {
+		if err := c.connect(); err != nil {
+			return err
+		}
+		parks = []energontrol.ParkConfig{c.park}
+	}
+	var rows []energontrol.InventoryRow
+	var failed []error
+	for _, park := range parks {
+		server, err := park.Server()
+		if err != nil {
+			return fail(exitConfig, "%w", err)
+		}
+		parkRows, err := energontrol.Inventory(ctx, energontrol.ServerController{Server: server}, park)
+		if err != nil {
+			// the other parks are still exported
+			failed = append(failed, fmt.Errorf("park %s: %w", park.Name, err))
+			continue
+		}
+		rows = append(rows, parkRows...)
+	}
+	if format == "json" {
+		err = energontrol.WriteInventoryJSON(c.stdout, rows)
+	} else {
+		err = energontrol.WriteInventoryCSV(c.stdout, rows)
+	}
+	if err != nil {
+		return fail(exitFailed, "%w", err)
+	}
+	if len(failed) > 0 {
+		return fail(exitFailed, "%w", errors.Join(failed...))
+	}
+	return nil
+}
+
+func (c *cli) browse(ctx context.Context, args []string) error {
+	var depth int
+	fs := flag.NewFlagSet("browse", flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	fs.IntVar(&depth, "depth", 1, "levels to browse, 0 for the whole tree")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return fail(exitUsage, "%w", err)
+	}
+	if len(args) > 1 {
+		return fail(exitUsage, "usage: browse [--depth n] [item]")
+	}
+	item := "Loc"
+	if len(args) == 1 {
+		item = args[0]
+	}
+	if err := c.checkAvailable(ctx); err != nil {
+		return err
+	}
+	elements, err := energontrol.BrowseItems(ctx, c.server, item, depth)
+	if err != nil {
+		return fail(exitFailed, "%w", err)
+	}
+	return c.print(elements)
+}
+
+func (c *cli) read(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fail(exitUsage, "usage: read <items>")
+	}
+	if err := c.checkAvailable(ctx); err != nil {
+		return err
+	}
+	values, err := energontrol.ReadItems(ctx, c.server, args...)
+	if err != nil {
+		return fail(exitFailed, "read items: %w", err)
+	}
+	if err = c.print(values); err != nil {
+		return err
+	}
+	for _, v := range values {
+		if v.Err != nil {
+			return fail(exitFailed, "not all items could be read")
+		}
+	}
+	return nil
+}
+
+func (c *cli) snapshot(ctx context.Context, args []string) error {
+	if err := c.checkAvailable(ctx); err != nil {
+		return err
+	}
+	PlantNo, err := c.plants(ctx, args)
+	if err != nil {
+		return err
+	}
+	snapshots, err := energontrol.PlantSnapshots(ctx, energontrol.ServerController{Server: c.server}, c.park.Snapshot, PlantNo...)
+	if err != nil {
+		return fail(exitFailed, "read snapshot: %w", err)
+	}
+	return c.print(snapshots)
+}
@@ -0,0 +1,101 @@
+// Command energontrol controls Enercon wind turbines from the command line.
+//
+// Usage:
+//
+//	energontrol [flags] <command> [arguments]
+//
+// Commands:
+//
+//	status <plants>                 show Ctrl and Rbh state
+//	turbines                        list turbines and their available controls
+//	start [--group n --delay d [--verify]] <plants>
+//	                                start plants, in groups of n with d between the groups
+//	stop [--full] [--force] <plants> stop plants (Stop60, or Stop90 with --full)
+//	reset <plants>                  reset plants
+//	rbh on|auto-off|standard <plants> set the rotor blade heating
+//	parkno check                    compare the ParkNo of the server with the configured one
+//	browse [--depth n] [item]       browse the item tree below item, Loc by default
+//	read <items>                    read arbitrary items, e.g. Loc/Wec/Plant2/Ctrl/Ctrl
+//	snapshot <plants>               show status, power, wind speed and availability
+//	export [--format csv|json] [--fleet]
+//	                                export turbines, capabilities and states, of all parks with --fleet
+//
+// Plants are given as numbers, ranges like 2-5, aliases from the fleet config or "all".
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+)
+
+// Exit codes, one per failure class
+const (
+	exitOK          = 0
+	exitFailed      = 1 // at least one plant failed
+	exitUsage       = 2
+	exitConfig      = 3
+	exitUnavailable = 4 // server not reachable or not running
+	exitParkNo      = 5 // ParkNo of the server does not match
+	exitAborted     = 6 // not confirmed by the operator
+)
+
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+func fail(code int, format string, a ...any) error {
+	return &exitError{code: code, err: fmt.Errorf(format, a...)}
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	code := run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+	stop()
+	os.Exit(code)
+}
+
+func run(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	var opts options
+	fs := flag.NewFlagSet("energontrol", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	opts.register(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: energontrol [flags] status|turbines|start|stop|reset|rbh|parkno|browse|read|snapshot|export [arguments]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	c := &cli{opts: opts, stdin: stdin, stdout: stdout, stderr: stderr}
+	err := c.dispatch(ctx, fs.Arg(0), fs.Args()[1:])
+	if err == nil {
+		return exitOK
+	}
+	fmt.Fprintln(stderr, "energontrol:", err)
+	var e *exitError
+	if errors.As(err, &e) {
+		return e.code
+	}
+	return exitFailed
+}
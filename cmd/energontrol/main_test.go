@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dernate/energontrol"
+)
+
+func TestParsePlants(t *testing.T) {
+	P := energontrol.ParkConfig{
+		Name:    "north",
+		Plants:  []uint8{1, 2, 3, 4, 5, 7},
+		Aliases: map[string]uint8{"WEA-7": 7},
+	}
+	PlantNo, err := parsePlants(P, []string{"2-4,1", "WEA-7", "3"})
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	expected := []uint8{2, 3, 4, 1, 7}
+	if len(PlantNo) != len(expected) {
+		t.Fatalf("Error: %v ; %v", PlantNo, expected)
+	}
+	for i := range expected {
+		if PlantNo[i] != expected[i] {
+			t.Errorf("Error: %v ; %v", PlantNo, expected)
+		}
+	}
+	for _, invalid := range []string{"5-6", "4-2", "x"} {
+		if _, err = parsePlants(P, []string{invalid}); err == nil {
+			t.Errorf("Error: %q accepted", invalid)
+		}
+	}
+}
+
+func TestRunExitCodes(t *testing.T) {
+	t.Setenv("ENERGONTROL_CONFIG", "")
+	t.Setenv("ENERGONTROL_USERID", "")
+	cases := []struct {
+		args []string
+		code int
+	}{
+		{[]string{}, exitUsage},
+		{[]string{"-url", "http://localhost:1/DA", "fly"}, exitUsage},
+		{[]string{"-url", "http://localhost:1/DA", "-output", "xml", "status", "1"}, exitUsage},
+		{[]string{"status", "1"}, exitUsage},
+		{[]string{"-config", "does-not-exist.yaml", "status", "1"}, exitConfig},
+		{[]string{"-url", "http://localhost:1/DA", "start", "1"}, exitUsage},
+		{[]string{"-url", "http://localhost:1/DA", "export", "--format", "xml"}, exitUsage},
+		{[]string{"export", "--fleet"}, exitUsage},
+	}
+	for _, tc := range cases {
+		var stdout, stderr bytes.Buffer
+		code := run(context.Background(), tc.args, strings.NewReader(""), &stdout, &stderr)
+		if code != tc.code {
+			t.Errorf("Error: %v returned %d, expected %d (%s)", tc.args, code, tc.code, stderr.String())
+		}
+	}
+}
+
+func TestParseFlags(t *testing.T) {
+	for _, args := range [][]string{{"3", "--full", "4"}, {"--full", "3", "4"}, {"3", "4", "-full"}} {
+		var full bool
+		fs := flag.NewFlagSet("stop", flag.ContinueOnError)
+		fs.BoolVar(&full, "full", false, "")
+		rest, err := parseFlags(fs, args)
+		if err != nil || !full || strings.Join(rest, " ") != "3 4" {
+			t.Errorf("Error: %v: %v, %t, %v", args, rest, full, err)
+		}
+	}
+	var full bool
+	fs := flag.NewFlagSet("stop", flag.ContinueOnError)
+	fs.BoolVar(&full, "full", false, "")
+	if rest, err := parseFlags(fs, []string{"3", "--", "--full"}); err != nil || full || strings.Join(rest, " ") != "3 --full" {
+		t.Errorf("Error: %v, %t, %v", rest, full, err)
+	}
+}
+
+func TestUserId(t *testing.T) {
+	creds := filepath.Join(t.TempDir(), "credentials.yaml")
+	if err := os.WriteFile(creds, []byte("operators:\n  alice: {value: 1234}\nparks:\n  north:\n    alice: {value: 4321}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	park := energontrol.ParkConfig{Name: "north", UserId: energontrol.UserIdSource{Value: 1}}
+	cases := []struct {
+		creds    string
+		operator string
+		park     energontrol.ParkConfig
+		UserId   uint64
+		code     int
+	}{
+		{creds, "alice", park, 4321, exitOK},
+		{creds, "alice", energontrol.ParkConfig{Name: "south"}, 1234, exitOK},
+		{creds, "bob", park, 1, exitOK}, // unknown operators use the user id of the park
+		{"", "alice", park, 1, exitOK},
+		{creds, "bob", energontrol.ParkConfig{Name: "south"}, 0, exitUsage},
+		{"", "alice", energontrol.ParkConfig{Name: "south"}, 0, exitUsage},
+		{"does-not-exist.yaml", "alice", park, 0, exitConfig},
+	}
+	for _, tc := range cases {
+		c := &cli{opts: options{creds: tc.creds, oper: tc.operator}, park: tc.park}
+		ctx, UserId, err := c.userId(context.Background())
+		code := exitOK
+		var e *exitError
+		if errors.As(err, &e) {
+			code = e.code
+		}
+		if UserId != tc.UserId || code != tc.code {
+			t.Errorf("Error: %+v got %d, %v", tc, UserId, err)
+		}
+		if err == nil && energontrol.OperatorFrom(ctx) != tc.operator {
+			t.Errorf("Error: operator %q not in the context", tc.operator)
+		}
+	}
+}
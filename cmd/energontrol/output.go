@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dernate/energontrol"
+)
+
+// print Write v either as JSON or as a table
+func (c *cli) print(v any) error {
+	if c.opts.output == "json" {
+		enc := json.NewEncoder(c.stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	w := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	switch rows := v.(type) {
+	case []plantStatus:
+		fmt.Fprintln(w, "PLANT\tNAME\tCTRL\tRBH\tRBH FLAGS")
+		for _, s := range rows {
+			fmt.Fprintf(w, "%d\t%s\t%d (%s)\t%d\t%s\n", s.PlantNo, s.Name, s.Ctrl, s.CtrlText, s.Rbh, strings.Join(s.RbhFlags, "; "))
+		}
+	case []turbine:
+		fmt.Fprintln(w, "PLANT\tNAME\tCTRL\tRBH\tRESET\tPARA\tICEDET")
+		for _, t := range rows {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", t.PlantNo, t.Name, yesNo(t.Ctrl), yesNo(t.Rbh), yesNo(t.Reset), yesNo(t.Para), yesNo(t.IceDet))
+		}
+	case []plantResult:
+		fmt.Fprintln(w, "PLANT\tNAME\tACTION\tRESULT\tERROR")
+		for _, r := range rows {
+			result := "ok"
+			if !r.Ok {
+				result = "failed"
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", r.PlantNo, r.Name, r.Action, result, r.Error)
+		}
+	case []energontrol.BrowseElement:
+		fmt.Fprintln(w, "ITEM\tTYPE")
+		printTree(w, rows)
+	case []energontrol.ItemValue:
+		fmt.Fprintln(w, "ITEM\tVALUE\tTYPE\tERROR")
+		for _, v := range rows {
+			errText := ""
+			if v.Err != nil {
+				errText = v.Err.Error()
+			}
+			fmt.Fprintf(w, "%s\t%v\t%s\t%s\n", v.ItemName, v.Value, v.Type, errText)
+		}
+	case []energontrol.PlantSnapshot:
+		fmt.Fprintln(w, "PLANT\tCTRL\tSTATUS\tPOWER kW\tWIND m/s\tAVAIL %\tMISSING")
+		for _, s := range rows {
+			var missing []string
+			for name, reason := range s.Missing {
+				missing = append(missing, name+": "+reason)
+			}
+			sort.Strings(missing)
+			fmt.Fprintf(w, "%d\t%s\t%s/%s\t%s\t%s\t%s\t%s\n", s.PlantNo, optional(s.Ctrl), optional(s.MainStatus), optional(s.SubStatus),
+				optional(s.ActivePower), optional(s.WindSpeed), optional(s.Availability), strings.Join(missing, "; "))
+		}
+	case map[string]any:
+		fmt.Fprintf(w, "PARK\tPARKNO\tMATCH\n%v\t%v\t%v\n", rows["park"], rows["park_no"], rows["match"])
+	default:
+		fmt.Fprintln(w, v)
+	}
+	return w.Flush()
+}
+
+// printTree Write the elements indented by their level
+func printTree(w io.Writer, elements []energontrol.BrowseElement) {
+	for _, e := range elements {
+		kind := "branch"
+		if e.IsItem {
+			kind = "item"
+		}
+		fmt.Fprintf(w, "%s\t%s\n", e.ItemName, kind)
+		printTree(w, e.Children)
+	}
+}
+
+// optional Format a value, which may be missing
+func optional[T uint64 | float64](v *T) string {
+	if v == nil {
+		return "-"
+	}
+	return fmt.Sprint(*v)
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "-"
+}
@@ -0,0 +1,109 @@
+// Command energontrold serves the energontrol HTTP/JSON API for all parks of a fleet config.
+//
+// Usage:
+//
+//	energontrold -config fleet.yaml -users users.yaml -listen :8080
+//
+// With -credentials, the UserIds of the API users are resolved per park from the credential file,
+// users missing there use their user_id.
+// With -schedule, the rules of the file are executed as well; pending jobs and results are kept in -schedule-state.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/dernate/energontrol"
+	"github.com/dernate/energontrol/httpapi"
+	"github.com/dernate/energontrol/scheduler"
+	"github.com/dernate/energontrol/tracing"
+)
+
+func main() {
+	config := flag.String("config", "fleet.yaml", "fleet config file")
+	users := flag.String("users", "users.yaml", "API users file")
+	credentials := flag.String("credentials", "", "credential file with the UserIds of the API users per park, optional")
+	listen := flag.String("listen", ":8080", "listen address")
+	schedule := flag.String("schedule", "", "scheduled rules file, no scheduler if empty")
+	scheduleState := flag.String("schedule-state", "schedule.json", "scheduler state file")
+	traceExporter := flag.String("trace", "", "export traces to stdout or otlp (OTEL_EXPORTER_OTLP_ENDPOINT, default localhost:4318)")
+	flag.Parse()
+
+	if *traceExporter != "" {
+		shutdown, err := tracing.Setup(context.Background(), *traceExporter, "energontrold")
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer func() { _ = shutdown(context.Background()) }()
+	}
+
+	fleet, err := energontrol.LoadFleetConfig(*config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	apiUsers, err := httpapi.LoadUsers(*users)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var parks []httpapi.Park
+	var scheduledParks []scheduler.Park
+	for _, P := range fleet.Parks {
+		Server, err := P.Server()
+		if err != nil {
+			log.Fatal(err)
+		}
+		parks = append(parks, httpapi.Park{Config: P, Controller: energontrol.ServerController{Server: Server}})
+		scheduledParks = append(scheduledParks, scheduler.Park{Config: P, Controller: energontrol.ServerController{Server: Server}})
+	}
+	h, err := httpapi.NewHandler(parks, apiUsers)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *credentials != "" {
+		C, err := energontrol.LoadCredentials(*credentials)
+		if err != nil {
+			log.Fatal(err)
+		}
+		h.Credentials = C
+	}
+	srv := &http.Server{
+		Addr:              *listen,
+		Handler:           h,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *schedule != "" {
+		rules, err := scheduler.LoadRules(*schedule)
+		if err != nil {
+			log.Fatal(err)
+		}
+		s := &scheduler.Scheduler{Parks: scheduledParks, StatePath: *scheduleState}
+		if err = s.Load(); err != nil {
+			log.Fatal(err)
+		}
+		if err = s.SetRules(rules); err != nil {
+			log.Fatal(err)
+		}
+		go func() { _ = s.Run(ctx) }()
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+	log.Printf("energontrold listening on %s", *listen)
+	if err = srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+	// let running jobs finish their session handshakes
+	h.Wait()
+}
@@ -0,0 +1,148 @@
+package energontrol
+
+import (
+	"context"
+	"fmt"
+	"github.com/dernate/gopcxmlda"
+)
+
+// Controller bundles the control functions of the package for one park.
+// ServerController implements it on top of a Server, other implementations can stand in for the SCADA in tests.
+type Controller interface {
+	ServerAvailable(ctx context.Context) (bool, error)
+	Turbines(ctx context.Context) (TurbineInfo, error)
+	State(ctx context.Context, CtrlOrRbh string, PlantNo []uint8) ([]PlantState, error)
+	Start(ctx context.Context, UserId uint64, PlantNo ...uint8) ([]bool, []error)
+	Stop(ctx context.Context, UserId uint64, FullStop bool, ForceExplicitCommand bool, PlantNo ...uint8) ([]bool, []error)
+	Reset(ctx context.Context, UserId uint64, PlantNo ...uint8) ([]bool, []error)
+	RbhOn(ctx context.Context, UserId uint64, PlantNo ...uint8) ([]bool, []error)
+	RbhAutoOff(ctx context.Context, UserId uint64, PlantNo ...uint8) ([]bool, []error)
+	RbhStandard(ctx context.Context, UserId uint64, PlantNo ...uint8) ([]bool, []error)
+	ControlAndRbh(ctx context.Context, UserId uint64, Values ControlAndRbhValue, PlantNo ...uint8) ([]bool, []error)
+}
+
+// SessionStater is implemented by Controllers, which can read the session states of plants, like ServerController
+type SessionStater interface {
+	SessionState(ctx context.Context, CtrlOrReset string, PlantNo []uint8) ([]uint16, error)
+}
+
+// StatusReader is implemented by Controllers, which can read the vendor info and state of the server, like ServerController
+type StatusReader interface {
+	ServerStatus(ctx context.Context) (ServerStatus, error)
+}
+
+// ServerController Controller, which calls the package functions with Server
+type ServerController struct {
+	Server    gopcxmlda.Server
+	Discovery *Discovery // if set, Turbines gets the cached TurbineInfo from it, also used by a wrapping CapabilityController
+}
+
+func (c ServerController) ServerAvailable(ctx context.Context) (bool, error) {
+	return ServerAvailable(ctx, c.Server)
+}
+
+func (c ServerController) ServerStatus(ctx context.Context) (ServerStatus, error) {
+	return GetServerStatus(ctx, c.Server)
+}
+
+func (c ServerController) Turbines(ctx context.Context) (TurbineInfo, error) {
+	if c.Discovery != nil {
+		return c.Discovery.Turbines(ctx)
+	}
+	return Turbines(ctx, c.Server)
+}
+
+func (c ServerController) State(ctx context.Context, CtrlOrRbh string, PlantNo []uint8) ([]PlantState, error) {
+	return GetPlantCtrlOrRbhState(ctx, c.Server, CtrlOrRbh, PlantNo)
+}
+
+func (c ServerController) SessionState(ctx context.Context, CtrlOrReset string, PlantNo []uint8) ([]uint16, error) {
+	return GetSessionState(ctx, c.Server, CtrlOrReset, PlantNo)
+}
+
+func (c ServerController) BrowseItems(ctx context.Context, ItemName string, Depth int) ([]BrowseElement, error) {
+	return BrowseItems(ctx, c.Server, ItemName, Depth)
+}
+
+func (c ServerController) ReadItems(ctx context.Context, ItemName ...string) ([]ItemValue, error) {
+	return ReadItems(ctx, c.Server, ItemName...)
+}
+
+func (c ServerController) Start(ctx context.Context, UserId uint64, PlantNo ...uint8) ([]bool, []error) {
+	return Start(ctx, c.Server, UserId, PlantNo...)
+}
+
+func (c ServerController) Stop(ctx context.Context, UserId uint64, FullStop bool, ForceExplicitCommand bool, PlantNo ...uint8) ([]bool, []error) {
+	return Stop(ctx, c.Server, UserId, FullStop, ForceExplicitCommand, PlantNo...)
+}
+
+func (c ServerController) Reset(ctx context.Context, UserId uint64, PlantNo ...uint8) ([]bool, []error) {
+	return Reset(ctx, c.Server, UserId, PlantNo...)
+}
+
+func (c ServerController) RbhOn(ctx context.Context, UserId uint64, PlantNo ...uint8) ([]bool, []error) {
+	return RbhOn(ctx, c.Server, UserId, PlantNo...)
+}
+
+func (c ServerController) RbhAutoOff(ctx context.Context, UserId uint64, PlantNo ...uint8) ([]bool, []error) {
+	return RbhAutoOff(ctx, c.Server, UserId, PlantNo...)
+}
+
+func (c ServerController) RbhStandard(ctx context.Context, UserId uint64, PlantNo ...uint8) ([]bool, []error) {
+	return RbhStandard(ctx, c.Server, UserId, PlantNo...)
+}
+
+func (c ServerController) ControlAndRbh(ctx context.Context, UserId uint64, Values ControlAndRbhValue, PlantNo ...uint8) ([]bool, []error) {
+	return ControlAndRbh(ctx, c.Server, UserId, Values, PlantNo...)
+}
+
+// Actions understood by Do
+const (
+	ActionStart       = "Start"
+	ActionStop60      = "Stop60"
+	ActionStop90      = "Stop90"
+	ActionReset       = "Reset"
+	ActionRbhOn       = "RbhOn"
+	ActionRbhAutoOff  = "RbhAutoOff"
+	ActionRbhStandard = "RbhStandard"
+)
+
+// ValidAction Check if Action is one of the Action* constants
+func ValidAction(Action string) bool {
+	switch Action {
+	case ActionStart, ActionStop60, ActionStop90, ActionReset, ActionRbhOn, ActionRbhAutoOff, ActionRbhStandard:
+		return true
+	}
+	return false
+}
+
+// Do Run the named Action for the plants with Controller c. Force is passed as ForceExplicitCommand to Stop.
+// Plants rejecting UserId fail with a CredentialError.
+func Do(ctx context.Context, c Controller, UserId uint64, Action string, Force bool, PlantNo ...uint8) ([]bool, []error) {
+	ok, errList := do(ctx, c, UserId, Action, Force, PlantNo...)
+	return ok, credentialErrors(ctx, UserId, errList)
+}
+
+func do(ctx context.Context, c Controller, UserId uint64, Action string, Force bool, PlantNo ...uint8) ([]bool, []error) {
+	switch Action {
+	case ActionStart:
+		return c.Start(ctx, UserId, PlantNo...)
+	case ActionStop60:
+		return c.Stop(ctx, UserId, false, Force, PlantNo...)
+	case ActionStop90:
+		return c.Stop(ctx, UserId, true, Force, PlantNo...)
+	case ActionReset:
+		return c.Reset(ctx, UserId, PlantNo...)
+	case ActionRbhOn:
+		return c.RbhOn(ctx, UserId, PlantNo...)
+	case ActionRbhAutoOff:
+		return c.RbhAutoOff(ctx, UserId, PlantNo...)
+	case ActionRbhStandard:
+		return c.RbhStandard(ctx, UserId, PlantNo...)
+	}
+	errList := make([]error, len(PlantNo))
+	for i := range PlantNo {
+		errList[i] = fmt.Errorf("unknown action %q", Action)
+	}
+	return make([]bool, len(PlantNo)), errList
+}
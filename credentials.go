@@ -0,0 +1,176 @@
+package energontrol
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrUnknownOperator a CredentialProvider has no UserId for the operator
+var ErrUnknownOperator = errors.New("unknown operator")
+
+// CredentialProvider resolves an authenticated operator, a person or a service, to the Enercon UserId used for its commands on a park
+type CredentialProvider interface {
+	// UserId Get the UserId of Operator for Park. It fails with ErrUnknownOperator, if the provider doesn't know the operator.
+	UserId(ctx context.Context, Park string, Operator string) (uint64, error)
+}
+
+// CredentialFunc CredentialProvider calling a function, e.g. to look the UserId up in an external directory
+type CredentialFunc func(ctx context.Context, Park string, Operator string) (uint64, error)
+
+func (f CredentialFunc) UserId(ctx context.Context, Park string, Operator string) (uint64, error) {
+	return f(ctx, Park, Operator)
+}
+
+// ChainCredentials CredentialProvider asking the providers in order, until one knows the operator
+type ChainCredentials []CredentialProvider
+
+func (c ChainCredentials) UserId(ctx context.Context, Park string, Operator string) (uint64, error) {
+	for _, p := range c {
+		UserId, err := p.UserId(ctx, Park, Operator)
+		if !errors.Is(err, ErrUnknownOperator) {
+			return UserId, err
+		}
+	}
+	return 0, fmt.Errorf("operator %q: %w", Operator, ErrUnknownOperator)
+}
+
+// FleetCredentials CredentialProvider giving every operator the UserId configured for the park in the fleet config,
+// e.g. as the last provider of ChainCredentials
+type FleetCredentials FleetConfig
+
+func (f FleetCredentials) UserId(ctx context.Context, Park string, Operator string) (uint64, error) {
+	P, err := FleetConfig(f).Park(Park)
+	if err != nil {
+		return 0, err
+	}
+	return P.ResolveUserId()
+}
+
+// CredentialFile operators and their UserIds, read by LoadCredentials. Parks overrides the UserId of an operator per park.
+type CredentialFile struct {
+	Operators map[string]UserIdSource            `yaml:"operators"`
+	Parks     map[string]map[string]UserIdSource `yaml:"parks"`
+}
+
+// LoadCredentials Read a YAML credential file from path, e.g.
+//
+//	operators:
+//	  alice: {value: 1234}
+//	  scheduler: {env: SCHEDULER_USER_ID}
+//	parks:
+//	  north:
+//	    alice: {value: 4321}
+func LoadCredentials(path string) (CredentialFile, error) {
+	var C CredentialFile
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return C, err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err = dec.Decode(&C); err != nil {
+		return C, fmt.Errorf("credentials %s: %w", path, err)
+	}
+	var errList []error
+	check := func(where string, Operator string, S UserIdSource) {
+		if (S.Value == 0) == (S.Env == "") {
+			errList = append(errList, fmt.Errorf("credentials %s: operator %q%s needs either value or env", path, Operator, where))
+		}
+	}
+	for Operator, S := range C.Operators {
+		check("", Operator, S)
+	}
+	for Park, operators := range C.Parks {
+		for Operator, S := range operators {
+			check(" of park "+Park, Operator, S)
+		}
+	}
+	return C, errors.Join(errList...)
+}
+
+func (C CredentialFile) UserId(ctx context.Context, Park string, Operator string) (uint64, error) {
+	S, ok := C.Parks[Park][Operator]
+	if !ok {
+		if S, ok = C.Operators[Operator]; !ok {
+			return 0, fmt.Errorf("operator %q: %w", Operator, ErrUnknownOperator)
+		}
+	}
+	UserId, err := S.Resolve()
+	if err != nil {
+		return 0, fmt.Errorf("operator %q: %w", Operator, err)
+	}
+	return UserId, nil
+}
+
+// ResolveOperator Get the UserId of Operator for Park from p and record in the audit log, which operator uses which UserId.
+// The returned context carries the operator, so the log messages of the following commands name it.
+func ResolveOperator(ctx context.Context, p CredentialProvider, Park string, Operator string) (context.Context, uint64, error) {
+	if OperatorFrom(ctx) != Operator {
+		ctx = WithOperator(ctx, Operator)
+	}
+	UserId, err := p.UserId(ctx, Park, Operator)
+	if err == nil && UserId == 0 {
+		err = errors.New("UserId 0")
+	}
+	if err != nil {
+		Logger(ctx).LogAttrs(ctx, slog.LevelWarn, fmt.Sprintf("no UserId for operator: %s", err),
+			slog.String(LogKeyPark, Park), slog.String(LogKeyAction, "Credentials"))
+		return ctx, 0, fmt.Errorf("park %q: operator %q: %w", Park, Operator, err)
+	}
+	Logger(ctx).LogAttrs(ctx, slog.LevelInfo, fmt.Sprintf("operator %s uses UserId %d", Operator, UserId),
+		slog.String(LogKeyPark, Park), slog.Uint64(LogKeyUserId, UserId), slog.String(LogKeyAction, "Credentials"))
+	return ctx, UserId, nil
+}
+
+// WithOperator returns a context, whose log messages and credential errors name Operator
+func WithOperator(ctx context.Context, Operator string) context.Context {
+	ctx = context.WithValue(ctx, operatorKey, Operator)
+	return WithLogAttrs(ctx, slog.String(LogKeyOperator, Operator))
+}
+
+// OperatorFrom Get the operator set by WithOperator, "" if none is set
+func OperatorFrom(ctx context.Context) string {
+	Operator, _ := ctx.Value(operatorKey).(string)
+	return Operator
+}
+
+// CredentialError the SCADA rejected the UserId of a command with session state 174 (Incorrect user ID) or 175 (Insufficient rights)
+type CredentialError struct {
+	Operator string // "" if the context named none
+	UserId   uint64
+	Session  *SessionError
+}
+
+func (e *CredentialError) Error() string {
+	who := fmt.Sprintf("UserId %d", e.UserId)
+	if e.Operator != "" {
+		who = fmt.Sprintf("UserId %d of operator %s", e.UserId, e.Operator)
+	}
+	if e.Session.State == 174 {
+		return fmt.Sprintf("plant %d: %s is unknown to the SCADA, check the configured user id", e.Session.PlantNo, who)
+	}
+	return fmt.Sprintf("plant %d: %s has insufficient rights, ask the SCADA administrator to grant control rights for the plant", e.Session.PlantNo, who)
+}
+
+func (e *CredentialError) Unwrap() error {
+	return e.Session
+}
+
+// credentialErrors Replace the SessionErrors with state 174 or 175 in errList by CredentialErrors
+func credentialErrors(ctx context.Context, UserId uint64, errList []error) []error {
+	for i, err := range errList {
+		var credErr *CredentialError
+		var se *SessionError
+		if errors.As(err, &credErr) || !errors.As(err, &se) || (se.State != 174 && se.State != 175) {
+			continue
+		}
+		errList[i] = &CredentialError{Operator: OperatorFrom(ctx), UserId: UserId, Session: se}
+	}
+	return errList
+}
@@ -0,0 +1,89 @@
+package energontrol_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dernate/energontrol"
+	"github.com/dernate/energontrol/energontroltest"
+)
+
+func TestCredentials(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.yaml")
+	data := `
+operators:
+  alice: {value: 1234}
+  scheduler: {env: TEST_SCHEDULER_USER_ID}
+parks:
+  north:
+    alice: {value: 4321}
+`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	C, err := energontrol.LoadCredentials(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_SCHEDULER_USER_ID", "777")
+	fleet := energontrol.FleetConfig{Parks: []energontrol.ParkConfig{{Name: "south", UserId: energontrol.UserIdSource{Value: 99}}}}
+	p := energontrol.ChainCredentials{C, energontrol.FleetCredentials(fleet)}
+	ctx := context.Background()
+	for _, tc := range []struct {
+		park, operator string
+		want           uint64
+	}{
+		{"south", "alice", 1234},
+		{"north", "alice", 4321},
+		{"south", "scheduler", 777},
+		{"south", "bob", 99}, // the park's UserId
+	} {
+		if UserId, err := p.UserId(ctx, tc.park, tc.operator); err != nil || UserId != tc.want {
+			t.Errorf("Error: %s on %s: %d, %v", tc.operator, tc.park, UserId, err)
+		}
+	}
+	if _, err = C.UserId(ctx, "north", "bob"); !errors.Is(err, energontrol.ErrUnknownOperator) {
+		t.Errorf("Error: %v", err)
+	}
+
+	// the audit log names the operator and the UserId
+	var buf bytes.Buffer
+	ctx = energontrol.WithLogger(ctx, slog.New(slog.NewTextHandler(&buf, nil)))
+	ctx, UserId, err := energontrol.ResolveOperator(ctx, p, "north", "alice")
+	if err != nil || UserId != 4321 || energontrol.OperatorFrom(ctx) != "alice" {
+		t.Errorf("Error: %d, %v", UserId, err)
+	}
+	if log := buf.String(); !strings.Contains(log, "Operator=alice") || !strings.Contains(log, "UserId=4321") {
+		t.Errorf("Error: %s", log)
+	}
+
+	// rejected UserIds give actionable errors
+	scada := energontroltest.NewScada(4711, 1, 2)
+	scada.SetPlant(1, energontroltest.Plant{SessionState: 175})
+	scada.SetPlant(2, energontroltest.Plant{SessionState: 174})
+	_, errList := energontrol.Do(ctx, scada, UserId, energontrol.ActionStop60, false, 1, 2)
+	var credErr *energontrol.CredentialError
+	if !errors.As(errList[0], &credErr) || credErr.Operator != "alice" || credErr.UserId != 4321 || !strings.Contains(errList[0].Error(), "insufficient rights") {
+		t.Errorf("Error: %v", errList[0])
+	}
+	var se *energontrol.SessionError
+	if !errors.As(errList[1], &se) || se.State != 174 || !strings.Contains(errList[1].Error(), "check the configured user id") {
+		t.Errorf("Error: %v", errList[1])
+	}
+}
+
+func TestLoadCredentialsInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.yaml")
+	if err := os.WriteFile(path, []byte("operators:\n  alice: {value: 1, env: X}\n  bob: {}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := energontrol.LoadCredentials(path); err == nil || !strings.Contains(err.Error(), `"alice"`) || !strings.Contains(err.Error(), `"bob"`) {
+		t.Errorf("Error: %v", err)
+	}
+}
@@ -0,0 +1,270 @@
+// Package curtailment stops plants for permit conditions like shadow flicker and bat protection and starts them again afterwards.
+//
+// Rules combine a range of the sun position, a daily time window (clock times or relative to sunrise and sunset),
+// a season and thresholds on inputs like temperature or wind speed, which the caller supplies. A plant is curtailed
+// while any of its rules matches: the engine stops it with Stop60 and starts it again, when no rule matches anymore.
+// Plants stopped by someone else are never started by the engine.
+//
+// Every change of the curtailment state and every action is a Decision with its reasons, written as JSON lines to
+// DecisionLog and logged.
+package curtailment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dernate/energontrol"
+)
+
+// maxDecisions decisions kept in memory by an Engine
+const maxDecisions = 1000
+
+// Decision the evaluation of a plant, recorded if its curtailment state changed or an action was taken
+type Decision struct {
+	Time         time.Time          `json:"time"`
+	PlantNo      uint8              `json:"plant_no"`
+	Curtail      bool               `json:"curtail"`
+	Rules        []string           `json:"rules,omitempty"`   // matching rules
+	Reasons      []string           `json:"reasons,omitempty"` // why the rules match
+	SunAzimuth   float64            `json:"sun_azimuth"`
+	SunElevation float64            `json:"sun_elevation"`
+	Inputs       map[string]float64 `json:"inputs,omitempty"`
+	Ctrl         uint64             `json:"ctrl"`
+	Action       string             `json:"action,omitempty"`
+	Ok           bool               `json:"ok,omitempty"`
+	Error        string             `json:"error,omitempty"`
+}
+
+// Engine evaluates Rules for the plants of one park
+type Engine struct {
+	Park        energontrol.ParkConfig
+	Controller  energontrol.Controller
+	UserId      uint64
+	Rules       []Rule
+	Latitude    float64
+	Longitude   float64
+	Location    *time.Location                                                       // time zone of clock times and seasons, time.Local if nil
+	PlantNo     []uint8                                                              // plants to watch, all allowed turbines with Ctrl if empty
+	Inputs      func(ctx context.Context, PlantNo uint8) (map[string]float64, error) // e.g. temperature and wind_speed
+	DecisionLog io.Writer                                                            // every Decision as a JSON line
+	StatePath   string                                                               // plants stopped by the engine are saved here, to start them after a restart
+	Interval    time.Duration                                                        // evaluation interval of Run, 1m if 0
+
+	mu        sync.Mutex
+	stopped   map[uint8]bool // plants stopped by the engine
+	curtailed map[uint8]bool // curtailment state of the last evaluation
+	decisions []Decision
+}
+
+type state struct {
+	Stopped []int `json:"stopped"`
+}
+
+// Run Evaluate the rules every Interval until ctx is done
+func (e *Engine) Run(ctx context.Context) error {
+	interval := e.Interval
+	if interval == 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := e.Evaluate(ctx, time.Now()); err != nil {
+			e.log(ctx, slog.LevelError, 0, err.Error())
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Evaluate Check the rules for every plant at now, stop newly curtailed plants and start released ones
+func (e *Engine) Evaluate(ctx context.Context, now time.Time) ([]Decision, error) {
+	if err := e.init(ctx); err != nil {
+		return nil, err
+	}
+	loc := e.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	ev := env{now: now.In(loc), latitude: e.Latitude, longitude: e.Longitude}
+	ev.azimuth, ev.elevation = SunPosition(now, e.Latitude, e.Longitude)
+	states, err := e.Controller.State(e.Park.Context(ctx), "Ctrl", e.PlantNo)
+	if err != nil {
+		return nil, fmt.Errorf("read Ctrl state: %w", err)
+	}
+	var decisions []Decision
+	for i, plant := range e.PlantNo {
+		if i >= len(states) {
+			break
+		}
+		if states[i].Err != nil {
+			e.log(ctx, slog.LevelWarn, plant, fmt.Sprintf("skipped, Ctrl state unknown: %s", states[i].Err))
+			continue
+		}
+		ev.inputs = nil
+		if e.Inputs != nil {
+			if ev.inputs, err = e.Inputs(ctx, plant); err != nil {
+				e.log(ctx, slog.LevelWarn, plant, fmt.Sprintf("inputs unknown, rules with inputs match: %s", err))
+			}
+		}
+		d := Decision{Time: now, PlantNo: plant, SunAzimuth: ev.azimuth, SunElevation: ev.elevation, Inputs: ev.inputs, Ctrl: states[i].CtrlState}
+		for _, r := range e.Rules {
+			if !r.appliesTo(plant) {
+				continue
+			}
+			if match, reasons := r.matches(ev); match {
+				d.Curtail = true
+				d.Rules = append(d.Rules, r.Name)
+				for _, reason := range reasons {
+					d.Reasons = append(d.Reasons, r.Name+": "+reason)
+				}
+			}
+		}
+		e.mu.Lock()
+		last, known := e.curtailed[plant]
+		e.curtailed[plant] = d.Curtail
+		stoppedByUs := e.stopped[plant]
+		e.mu.Unlock()
+		switch {
+		case d.Curtail && d.Ctrl == energontrol.CtrlValues["Start"]:
+			d.Action = energontrol.ActionStop60
+		case !d.Curtail && stoppedByUs && d.Ctrl == energontrol.CtrlValues["Stop60"]:
+			d.Action = energontrol.ActionStart
+		case !d.Curtail && stoppedByUs:
+			// stopped otherwise in the meantime, leave it to whoever did
+			d.Reasons = append(d.Reasons, fmt.Sprintf("not started, Ctrl state is %s", energontrol.CtrlStateText(d.Ctrl)))
+			e.setStopped(plant, false)
+		}
+		if d.Action != "" {
+			ok, errList := energontrol.Do(e.Park.Context(ctx), e.Controller, e.UserId, d.Action, false, plant)
+			d.Ok = len(ok) == 1 && ok[0]
+			if len(errList) == 1 && errList[0] != nil {
+				d.Error = errList[0].Error()
+			}
+			if d.Ok {
+				e.setStopped(plant, d.Action == energontrol.ActionStop60)
+			}
+		} else if known && last == d.Curtail {
+			continue
+		}
+		e.record(ctx, d)
+		decisions = append(decisions, d)
+	}
+	return decisions, nil
+}
+
+// Decisions Get a copy of the recorded decisions, oldest first
+func (e *Engine) Decisions() []Decision {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]Decision(nil), e.decisions...)
+}
+
+// record Keep, write and log the decision
+func (e *Engine) record(ctx context.Context, d Decision) {
+	e.mu.Lock()
+	e.decisions = append(e.decisions, d)
+	if len(e.decisions) > maxDecisions {
+		e.decisions = e.decisions[len(e.decisions)-maxDecisions:]
+	}
+	var err error
+	if e.DecisionLog != nil {
+		var line []byte
+		if line, err = json.Marshal(d); err == nil {
+			_, err = e.DecisionLog.Write(append(line, '\n'))
+		}
+	}
+	e.mu.Unlock()
+	if err != nil {
+		e.log(ctx, slog.LevelError, d.PlantNo, fmt.Sprintf("decision log: %s", err))
+	}
+	msg := fmt.Sprintf("curtail=%t by %v %v, Ctrl %s", d.Curtail, d.Rules, d.Reasons, energontrol.CtrlStateText(d.Ctrl))
+	if d.Action != "" {
+		msg += fmt.Sprintf(", %s: ok=%t", d.Action, d.Ok)
+	}
+	if d.Error != "" {
+		e.log(ctx, slog.LevelWarn, d.PlantNo, msg+", "+d.Error)
+	} else {
+		e.log(ctx, slog.LevelInfo, d.PlantNo, msg)
+	}
+}
+
+// setStopped Remember if the engine stopped the plant and save it to StatePath
+func (e *Engine) setStopped(PlantNo uint8, stopped bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if stopped {
+		e.stopped[PlantNo] = true
+	} else {
+		delete(e.stopped, PlantNo)
+	}
+	if e.StatePath == "" {
+		return
+	}
+	st := state{Stopped: []int{}}
+	for p := range e.stopped {
+		st.Stopped = append(st.Stopped, int(p))
+	}
+	sort.Ints(st.Stopped)
+	data, _ := json.Marshal(st)
+	tmp := e.StatePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err == nil {
+		_ = os.Rename(tmp, e.StatePath)
+	}
+}
+
+// init Validate the rules, load the plants stopped before a restart and find the plants to watch
+func (e *Engine) init(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.stopped == nil {
+		if err := ValidateRules(e.Rules); err != nil {
+			return err
+		}
+		stopped := make(map[uint8]bool)
+		if e.StatePath != "" {
+			var st state
+			data, err := os.ReadFile(e.StatePath)
+			if err == nil {
+				err = json.Unmarshal(data, &st)
+			}
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("curtailment state %s: %w", e.StatePath, err)
+			}
+			for _, p := range st.Stopped {
+				stopped[uint8(p)] = true
+			}
+		}
+		e.stopped = stopped
+		e.curtailed = make(map[uint8]bool)
+	}
+	if len(e.PlantNo) > 0 {
+		return nil
+	}
+	T, err := e.Controller.Turbines(e.Park.Context(ctx))
+	if err != nil {
+		return err
+	}
+	for _, plant := range T.PlantNo {
+		if T.Ctrl[plant] && e.Park.PlantAllowed(plant) {
+			e.PlantNo = append(e.PlantNo, plant)
+		}
+	}
+	return nil
+}
+
+func (e *Engine) log(ctx context.Context, level slog.Level, PlantNo uint8, msg string) {
+	ctx = e.Park.Context(ctx)
+	energontrol.Logger(ctx).LogAttrs(ctx, level, msg, slog.Int(energontrol.LogKeyPlantNo, int(PlantNo)), slog.String(energontrol.LogKeyAction, "Curtailment"))
+}
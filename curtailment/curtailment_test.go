@@ -0,0 +1,98 @@
+package curtailment
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dernate/energontrol"
+	"github.com/dernate/energontrol/energontroltest"
+)
+
+const berlinLat, berlinLon = 52.52, 13.405
+
+func TestSunPosition(t *testing.T) {
+	// solar noon at the equator on the equinox
+	_, elevation := SunPosition(time.Date(2026, 3, 20, 12, 7, 0, 0, time.UTC), 0, 0)
+	if elevation < 89 {
+		t.Errorf("Error: elevation %.2f", elevation)
+	}
+	azimuth, elevation := SunPosition(time.Date(2026, 6, 21, 11, 7, 0, 0, time.UTC), berlinLat, berlinLon)
+	if math.Abs(azimuth-180) > 2 || math.Abs(elevation-60.9) > 0.5 {
+		t.Errorf("Error: azimuth %.2f elevation %.2f", azimuth, elevation)
+	}
+	sunrise, sunset, ok := SunriseSunset(time.Date(2026, 6, 21, 12, 0, 0, 0, time.UTC), berlinLat, berlinLon)
+	wantSunrise := time.Date(2026, 6, 21, 2, 43, 0, 0, time.UTC)
+	wantSunset := time.Date(2026, 6, 21, 19, 33, 0, 0, time.UTC)
+	if !ok || sunrise.Sub(wantSunrise).Abs() > 3*time.Minute || sunset.Sub(wantSunset).Abs() > 3*time.Minute {
+		t.Errorf("Error: sunrise %s sunset %s", sunrise, sunset)
+	}
+	if _, _, ok = SunriseSunset(time.Date(2026, 12, 21, 12, 0, 0, 0, time.UTC), 78.2, 15.6); ok {
+		t.Error("Error: sunrise in the polar night")
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	scada := energontroltest.NewScada(4711, 1, 2, 3)
+	scada.SetPlant(3, energontroltest.Plant{Ctrl: energontrol.CtrlValues["Stop90"], Rbh: energontroltest.RbhStandardState})
+	minTemp, maxWind := 10.0, 6.0
+	var log bytes.Buffer
+	newEngine := func() *Engine {
+		return &Engine{
+			Park:       energontrol.ParkConfig{Name: "north"},
+			Controller: scada,
+			UserId:     1234,
+			Latitude:   berlinLat,
+			Longitude:  berlinLon,
+			Location:   time.UTC,
+			PlantNo:    []uint8{1, 2, 3},
+			Rules: []Rule{
+				{Name: "flicker", Plants: []uint8{1}, Sun: &SunWindow{AzimuthMin: 60, AzimuthMax: 100, ElevationMin: 3}},
+				{Name: "bats", Time: &TimeWindow{From: "sunset-30m", To: "sunrise+30m"}, Season: &Season{From: "04-01", To: "10-31"},
+					Inputs: map[string]Threshold{"temperature": {Min: &minTemp}, "wind_speed": {Max: &maxWind}}},
+			},
+			Inputs: func(ctx context.Context, PlantNo uint8) (map[string]float64, error) {
+				return map[string]float64{"temperature": 15, "wind_speed": 3}, nil
+			},
+			DecisionLog: &log,
+			StatePath:   filepath.Join(t.TempDir(), "curtailment.json"),
+		}
+	}
+	e := newEngine()
+	day := time.Date(2026, 6, 21, 0, 0, 0, 0, time.UTC)
+	steps := []struct {
+		at   time.Duration
+		ctrl [2]string // expected Ctrl of plant 1 and 2
+	}{
+		{1 * time.Hour, [2]string{"Stop60", "Stop60"}},                 // night, bats
+		{4 * time.Hour, [2]string{"Stop60", "Start"}},                  // morning sun in the east on plant 1
+		{12 * time.Hour, [2]string{"Start", "Start"}},                  // noon
+		{19*time.Hour + 30*time.Minute, [2]string{"Stop60", "Stop60"}}, // after sunset-30m
+	}
+	for i, step := range steps {
+		if i == 2 {
+			// a restarted engine still starts the plants it stopped
+			StatePath := e.StatePath
+			e = newEngine()
+			e.StatePath = StatePath
+		}
+		if _, err := e.Evaluate(context.Background(), day.Add(step.at)); err != nil {
+			t.Fatal(err)
+		}
+		for j, plant := range []uint8{1, 2} {
+			if got := scada.Plant(plant).Ctrl; got != energontrol.CtrlValues[step.ctrl[j]] {
+				t.Errorf("Error at %s: plant %d Ctrl %d, want %s", step.at, plant, got, step.ctrl[j])
+			}
+		}
+	}
+	if p := scada.Plant(3); p.Ctrl != energontrol.CtrlValues["Stop90"] {
+		t.Errorf("Error: plant 3 Ctrl %d", p.Ctrl)
+	}
+	if n := strings.Count(log.String(), "\n"); n < 6 || !strings.Contains(log.String(), `"bats: temperature 15"`) {
+		t.Errorf("Error: decision log %s", log.String())
+	}
+}
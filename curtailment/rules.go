@@ -0,0 +1,230 @@
+package curtailment
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Rule curtails its plants while all of its set parts match
+type Rule struct {
+	Name   string               `yaml:"name"`
+	Plants []uint8              `yaml:"plants"` // all plants of the engine if empty
+	Sun    *SunWindow           `yaml:"sun"`
+	Time   *TimeWindow          `yaml:"time"`
+	Season *Season              `yaml:"season"`
+	Inputs map[string]Threshold `yaml:"inputs"` // e.g. temperature or wind_speed, supplied by Engine.Inputs
+}
+
+// SunWindow range of the sun position in degrees, e.g. where a turbine casts its shadow on a house
+type SunWindow struct {
+	AzimuthMin   float64 `yaml:"azimuth_min"`
+	AzimuthMax   float64 `yaml:"azimuth_max"` // smaller than AzimuthMin for a range over north
+	ElevationMin float64 `yaml:"elevation_min"`
+	ElevationMax float64 `yaml:"elevation_max"` // 90 if 0
+}
+
+// TimeWindow daily window from From to To, over midnight if To is before From.
+// Both are a clock time "21:30" or relative to the sun "sunset-30m", "sunrise+1h".
+type TimeWindow struct {
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+}
+
+// Season dates "04-01" to "10-31", over new year if To is before From
+type Season struct {
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+}
+
+// Threshold an input matches, if it is at least Min and at most Max, where set
+type Threshold struct {
+	Min *float64 `yaml:"min"`
+	Max *float64 `yaml:"max"`
+}
+
+// LoadRules Read and validate rules from a YAML file with a list of rules
+func LoadRules(path string) ([]Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []Rule
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err = dec.Decode(&rules); err != nil {
+		return nil, fmt.Errorf("curtailment rules: %w", err)
+	}
+	return rules, ValidateRules(rules)
+}
+
+// ValidateRules Check names, windows and seasons of the rules
+func ValidateRules(rules []Rule) error {
+	var errList []error
+	names := make(map[string]bool)
+	for _, r := range rules {
+		if r.Name == "" {
+			errList = append(errList, errors.New("rule without name"))
+		} else if names[r.Name] {
+			errList = append(errList, fmt.Errorf("rule %q is defined more than once", r.Name))
+		}
+		names[r.Name] = true
+		if r.Sun == nil && r.Time == nil && r.Season == nil && len(r.Inputs) == 0 {
+			errList = append(errList, fmt.Errorf("rule %q: no condition", r.Name))
+		}
+		if r.Time != nil {
+			for _, s := range []string{r.Time.From, r.Time.To} {
+				if _, err := parseClock(s); err != nil {
+					errList = append(errList, fmt.Errorf("rule %q: %w", r.Name, err))
+				}
+			}
+		}
+		if r.Season != nil {
+			for _, s := range []string{r.Season.From, r.Season.To} {
+				if _, err := time.Parse("01-02", s); err != nil {
+					errList = append(errList, fmt.Errorf("rule %q: invalid season date %q, expected MM-DD", r.Name, s))
+				}
+			}
+		}
+	}
+	return errors.Join(errList...)
+}
+
+// clock a parsed time of a TimeWindow
+type clock struct {
+	sun    string // "", "sunrise" or "sunset"
+	offset time.Duration
+}
+
+func parseClock(s string) (clock, error) {
+	for _, sun := range []string{"sunrise", "sunset"} {
+		if rest, ok := strings.CutPrefix(s, sun); ok {
+			if rest == "" {
+				return clock{sun: sun}, nil
+			}
+			d, err := time.ParseDuration(rest)
+			if err != nil || (rest[0] != '+' && rest[0] != '-') {
+				return clock{}, fmt.Errorf("invalid time %q, expected e.g. %s-30m", s, sun)
+			}
+			return clock{sun: sun, offset: d}, nil
+		}
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return clock{}, fmt.Errorf("invalid time %q, expected HH:MM, sunrise or sunset with an offset", s)
+	}
+	return clock{offset: time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute}, nil
+}
+
+// at Get the time of the clock on the day of t. ok is false, if the sun doesn't rise or set that day.
+func (c clock) at(t time.Time, Latitude float64, Longitude float64) (time.Time, bool) {
+	if c.sun == "" {
+		y, m, d := t.Date()
+		return time.Date(y, m, d, 0, 0, 0, 0, t.Location()).Add(c.offset), true
+	}
+	sunrise, sunset, ok := SunriseSunset(t, Latitude, Longitude)
+	if !ok {
+		return time.Time{}, false
+	}
+	if c.sun == "sunrise" {
+		return sunrise.Add(c.offset), true
+	}
+	return sunset.Add(c.offset), true
+}
+
+// env what the rules are evaluated on
+type env struct {
+	now       time.Time // in the engine's location
+	latitude  float64
+	longitude float64
+	azimuth   float64
+	elevation float64
+	inputs    map[string]float64
+}
+
+// matches Check all parts of the rule and describe why they match
+func (r Rule) matches(e env) (bool, []string) {
+	var reasons []string
+	if r.Season != nil {
+		from, _ := time.Parse("01-02", r.Season.From)
+		to, _ := time.Parse("01-02", r.Season.To)
+		day := int(e.now.Month())*100 + e.now.Day()
+		lo, hi := int(from.Month())*100+from.Day(), int(to.Month())*100+to.Day()
+		in := lo <= day && day <= hi
+		if hi < lo {
+			in = day >= lo || day <= hi
+		}
+		if !in {
+			return false, nil
+		}
+		reasons = append(reasons, fmt.Sprintf("season %s to %s", r.Season.From, r.Season.To))
+	}
+	if r.Sun != nil {
+		azOk := e.azimuth >= r.Sun.AzimuthMin && e.azimuth <= r.Sun.AzimuthMax
+		if r.Sun.AzimuthMax < r.Sun.AzimuthMin {
+			azOk = e.azimuth >= r.Sun.AzimuthMin || e.azimuth <= r.Sun.AzimuthMax
+		}
+		elevationMax := r.Sun.ElevationMax
+		if elevationMax == 0 {
+			elevationMax = 90
+		}
+		if !azOk || e.elevation < r.Sun.ElevationMin || e.elevation > elevationMax {
+			return false, nil
+		}
+		reasons = append(reasons, fmt.Sprintf("sun azimuth %.1f° elevation %.1f°", e.azimuth, e.elevation))
+	}
+	if r.Time != nil {
+		fromClock, _ := parseClock(r.Time.From)
+		toClock, _ := parseClock(r.Time.To)
+		from, okFrom := fromClock.at(e.now, e.latitude, e.longitude)
+		to, okTo := toClock.at(e.now, e.latitude, e.longitude)
+		if !okFrom || !okTo {
+			return false, nil
+		}
+		in := !e.now.Before(from) && e.now.Before(to)
+		if to.Before(from) {
+			in = !e.now.Before(from) || e.now.Before(to)
+		}
+		if !in {
+			return false, nil
+		}
+		reasons = append(reasons, fmt.Sprintf("time %s to %s (%s to %s)", r.Time.From, r.Time.To, from.Format("15:04"), to.Format("15:04")))
+	}
+	names := make([]string, 0, len(r.Inputs))
+	for name := range r.Inputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		v, ok := e.inputs[name]
+		if !ok {
+			// without the input, the plant is curtailed to be on the safe side
+			reasons = append(reasons, fmt.Sprintf("%s unknown", name))
+			continue
+		}
+		th := r.Inputs[name]
+		if (th.Min != nil && v < *th.Min) || (th.Max != nil && v > *th.Max) {
+			return false, nil
+		}
+		reasons = append(reasons, fmt.Sprintf("%s %g", name, v))
+	}
+	return true, reasons
+}
+
+func (r Rule) appliesTo(PlantNo uint8) bool {
+	if len(r.Plants) == 0 {
+		return true
+	}
+	for _, p := range r.Plants {
+		if p == PlantNo {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package curtailment
+
+import (
+	"math"
+	"time"
+)
+
+// sunriseElevation elevation of the sun's center at sunrise and sunset, considering refraction and the sun's radius
+const sunriseElevation = -0.833
+
+// SunPosition Get the azimuth (degrees clockwise from north) and elevation (degrees above the horizon) of the sun
+// at t for a position, with the low precision formulas of the Astronomical Almanac (about 0.01° until 2050)
+func SunPosition(t time.Time, Latitude float64, Longitude float64) (Azimuth float64, Elevation float64) {
+	n := float64(t.UnixNano())/float64(24*time.Hour) + 2440587.5 - 2451545.0 // days since J2000.0
+	L := normDeg(280.460 + 0.9856474*n)                                      // mean longitude
+	g := rad(normDeg(357.528 + 0.9856003*n))                                 // mean anomaly
+	lambda := rad(L + 1.915*math.Sin(g) + 0.020*math.Sin(2*g))               // ecliptic longitude
+	epsilon := rad(23.439 - 0.0000004*n)                                     // obliquity of the ecliptic
+	ra := math.Atan2(math.Cos(epsilon)*math.Sin(lambda), math.Cos(lambda))
+	dec := math.Asin(math.Sin(epsilon) * math.Sin(lambda))
+	gmst := normDeg(280.46061837 + 360.98564736629*n) // Greenwich mean sidereal time in degrees
+	ha := rad(gmst+Longitude) - ra                    // local hour angle
+	lat := rad(Latitude)
+	Elevation = deg(math.Asin(math.Sin(lat)*math.Sin(dec) + math.Cos(lat)*math.Cos(dec)*math.Cos(ha)))
+	Azimuth = normDeg(deg(math.Atan2(-math.Sin(ha), math.Tan(dec)*math.Cos(lat)-math.Sin(lat)*math.Cos(ha))))
+	return Azimuth, Elevation
+}
+
+// SunriseSunset Get sunrise and sunset of the day of date in its location. ok is false on days without both, e.g. in polar night.
+func SunriseSunset(date time.Time, Latitude float64, Longitude float64) (Sunrise time.Time, Sunset time.Time, ok bool) {
+	y, m, d := date.Date()
+	start := time.Date(y, m, d, 0, 0, 0, 0, date.Location())
+	end := time.Date(y, m, d+1, 0, 0, 0, 0, date.Location())
+	above := func(t time.Time) bool {
+		_, e := SunPosition(t, Latitude, Longitude)
+		return e > sunriseElevation
+	}
+	const step = 10 * time.Minute
+	prev := above(start)
+	for t := start.Add(step); !t.After(end); t = t.Add(step) {
+		cur := above(t)
+		if cur != prev {
+			// bisect to a second
+			lo, hi := t.Add(-step), t
+			for hi.Sub(lo) > time.Second {
+				mid := lo.Add(hi.Sub(lo) / 2)
+				if above(mid) == prev {
+					lo = mid
+				} else {
+					hi = mid
+				}
+			}
+			if cur && Sunrise.IsZero() {
+				Sunrise = hi.Truncate(time.Second)
+			} else if !cur && Sunset.IsZero() {
+				Sunset = hi.Truncate(time.Second)
+			}
+		}
+		prev = cur
+	}
+	return Sunrise, Sunset, !Sunrise.IsZero() && !Sunset.IsZero()
+}
+
+func rad(d float64) float64 {
+	return d * math.Pi / 180
+}
+
+func deg(r float64) float64 {
+	return r * 180 / math.Pi
+}
+
+func normDeg(d float64) float64 {
+	d = math.Mod(d, 360)
+	if d < 0 {
+		d += 360
+	}
+	return d
+}
@@ -0,0 +1,225 @@
+package energontrol
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dernate/gopcxmlda"
+)
+
+// Discovery caches the TurbineInfo of a park and refreshes it incrementally: the plant list is browsed on every refresh,
+// the control items only of new plants and of plants browsed longer than CapabilityTTL ago.
+// The plants are browsed concurrently like by Turbines, see WithDiscoveryConcurrency.
+// A Discovery can be shared, e.g. by a ServerController and a CapabilityController of the same park.
+type Discovery struct {
+	Reader        ItemReader
+	TTL           time.Duration // age after which Turbines refreshes, 0 refreshes only with Refresh
+	CapabilityTTL time.Duration // age after which the control items of a known plant are browsed again, 0 never
+
+	mu        sync.Mutex
+	info      *TurbineInfo
+	readAt    time.Time
+	browsedAt map[uint8]time.Time
+	invalid   bool // set by Invalidate, the next refresh browses all plants
+}
+
+// DiscoveryChange the plants and capabilities, which were added or removed by a refresh
+type DiscoveryChange struct {
+	Added        []uint8            `json:"added,omitempty"`
+	Removed      []uint8            `json:"removed,omitempty"`
+	Capabilities []CapabilityChange `json:"capabilities,omitempty"`
+}
+
+// CapabilityChange a capability of a known plant became available or not available
+type CapabilityChange struct {
+	PlantNo    uint8  `json:"plant_no"`
+	Capability string `json:"capability"`
+	Available  bool   `json:"available"`
+}
+
+// Empty Check if the refresh found no change
+func (c DiscoveryChange) Empty() bool {
+	return len(c.Added) == 0 && len(c.Removed) == 0 && len(c.Capabilities) == 0
+}
+
+// Turbines Get the cached TurbineInfo, refresh it if there is none or it is older than TTL
+func (d *Discovery) Turbines(ctx context.Context) (TurbineInfo, error) {
+	d.mu.Lock()
+	if d.info != nil && !d.invalid && (d.TTL == 0 || time.Since(d.readAt) < d.TTL) {
+		T := d.info.clone()
+		d.mu.Unlock()
+		return T, nil
+	}
+	d.mu.Unlock()
+	T, _, err := d.Refresh(ctx)
+	return T, err
+}
+
+// Refresh Browse the plant list and the control items of new and outdated plants. The first refresh reports all plants as added.
+// If a browse fails, the cache is left unchanged.
+func (d *Discovery) Refresh(ctx context.Context) (TurbineInfo, DiscoveryChange, error) {
+	ctx, span := startSpan(ctx, "Discovery.Refresh")
+	defer span.End()
+	var change DiscoveryChange
+	values, err := d.Reader.ReadItems(ctx, "Loc/LocNo")
+	if err != nil {
+		return TurbineInfo{}, change, err
+	}
+	ParkNo, err := values[0].Uint64()
+	if err != nil {
+		return TurbineInfo{}, change, fmt.Errorf("ParkNo: %w", err)
+	}
+	elements, err := d.Reader.BrowseItems(ctx, "Loc/Wec", 1)
+	if err != nil {
+		return TurbineInfo{}, change, err
+	}
+	var PlantNo []uint8
+	for _, e := range elements {
+		if plant, ok := plantOfItem(e.ItemName); ok {
+			PlantNo = append(PlantNo, plant)
+		}
+	}
+	sort.Slice(PlantNo, func(i, j int) bool { return PlantNo[i] < PlantNo[j] })
+
+	d.mu.Lock()
+	var old TurbineInfo
+	if d.info != nil {
+		old = d.info.clone()
+	}
+	browsedAt := make(map[uint8]time.Time, len(d.browsedAt))
+	for plant, t := range d.browsedAt {
+		browsedAt[plant] = t
+	}
+	invalid := d.invalid
+	d.mu.Unlock()
+
+	now := time.Now()
+	var browse []uint8
+	for _, plant := range PlantNo {
+		t, known := browsedAt[plant]
+		if !known || invalid || (d.CapabilityTTL > 0 && now.Sub(t) >= d.CapabilityTTL) {
+			browse = append(browse, plant)
+		}
+	}
+	caps, err := browsePlants(ctx, d.browser(), browse)
+	if err != nil {
+		return TurbineInfo{}, change, err
+	}
+
+	T := TurbineInfo{
+		ParkNo:  ParkNo,
+		PlantNo: PlantNo,
+		Ctrl:    make(map[uint8]bool),
+		Rbh:     make(map[uint8]bool),
+		Reset:   make(map[uint8]bool),
+		Para:    make(map[uint8]bool),
+		IceDet:  make(map[uint8]bool),
+	}
+	current := make(map[uint8]bool, len(PlantNo))
+	for _, plant := range PlantNo {
+		current[plant] = true
+		if _, known := browsedAt[plant]; !known {
+			change.Added = append(change.Added, plant)
+			continue
+		}
+		T.Ctrl[plant], T.Rbh[plant], T.Reset[plant] = old.Ctrl[plant], old.Rbh[plant], old.Reset[plant]
+		T.Para[plant], T.IceDet[plant] = old.Para[plant], old.IceDet[plant]
+	}
+	for i, plant := range browse {
+		c := caps[i]
+		if _, known := browsedAt[plant]; known {
+			for _, f := range []struct {
+				name string
+				was  bool
+				is   bool
+			}{
+				{CapabilityCtrl, old.Ctrl[plant], c.Ctrl},
+				{CapabilityRbh, old.Rbh[plant], c.Rbh},
+				{CapabilityReset, old.Reset[plant], c.Reset},
+				{CapabilityPara, old.Para[plant], c.Para},
+				{CapabilityIceDet, old.IceDet[plant], c.IceDet},
+			} {
+				if f.was != f.is {
+					change.Capabilities = append(change.Capabilities, CapabilityChange{PlantNo: plant, Capability: f.name, Available: f.is})
+				}
+			}
+		}
+		T.Ctrl[plant], T.Rbh[plant], T.Reset[plant], T.Para[plant], T.IceDet[plant] = c.Ctrl, c.Rbh, c.Reset, c.Para, c.IceDet
+		browsedAt[plant] = now
+	}
+	for plant := range browsedAt {
+		if !current[plant] {
+			change.Removed = append(change.Removed, plant)
+			delete(browsedAt, plant)
+		}
+	}
+	sort.Slice(change.Removed, func(i, j int) bool { return change.Removed[i] < change.Removed[j] })
+
+	d.mu.Lock()
+	d.info, d.readAt, d.browsedAt = &T, now, browsedAt
+	if invalid {
+		d.invalid = false
+	}
+	d.mu.Unlock()
+	if !change.Empty() {
+		Logger(ctx).LogAttrs(ctx, slog.LevelInfo, fmt.Sprintf("turbines changed: added %v, removed %v, capabilities %+v", change.Added, change.Removed, change.Capabilities),
+			slog.String(LogKeyAction, "Discovery"))
+	}
+	return T.clone(), change, nil
+}
+
+// Invalidate Mark the cache as outdated, the next Turbines refreshes it and browses the control items of all plants again
+func (d *Discovery) Invalidate() {
+	d.mu.Lock()
+	d.invalid = true
+	d.mu.Unlock()
+}
+
+// browser Get the browseFunc of the Reader: the OPC browse of a ServerController, which filters on the server,
+// or BrowseItems of another ItemReader with the filters applied to its result
+func (d *Discovery) browser() browseFunc {
+	switch r := d.Reader.(type) {
+	case ServerController:
+		return serverBrowse(r.Server)
+	case *ServerController:
+		return serverBrowse(r.Server)
+	}
+	return func(ctx context.Context, ItemName string, options gopcxmlda.TBrowseOptions) ([]gopcxmlda.TBrowseElement, error) {
+		elements, err := d.Reader.BrowseItems(ctx, ItemName, 1)
+		if err != nil {
+			return nil, err
+		}
+		var filtered []gopcxmlda.TBrowseElement
+		for _, e := range elements {
+			if options.BrowseFilter == "branch" && !e.HasChildren || options.BrowseFilter == "item" && !e.IsItem {
+				continue
+			}
+			if options.ElementNameFilter != "" {
+				if match, _ := path.Match(options.ElementNameFilter, e.Name); !match {
+					continue
+				}
+			}
+			filtered = append(filtered, gopcxmlda.TBrowseElement{Name: e.Name, ItemName: e.ItemName, IsItem: e.IsItem, HasChildren: e.HasChildren})
+		}
+		return filtered, nil
+	}
+}
+
+// clone Copy T with its maps
+func (T TurbineInfo) clone() TurbineInfo {
+	c := T
+	c.PlantNo = append([]uint8(nil), T.PlantNo...)
+	for _, m := range []*map[uint8]bool{&c.Ctrl, &c.Rbh, &c.Reset, &c.Para, &c.IceDet} {
+		copied := make(map[uint8]bool, len(*m))
+		for plant, ok := range *m {
+			copied[plant] = ok
+		}
+		*m = copied
+	}
+	return c
+}
@@ -0,0 +1,91 @@
+package energontrol_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dernate/energontrol"
+	"github.com/dernate/energontrol/energontroltest"
+	"github.com/dernate/gopcxmlda"
+)
+
+// browseLog records the browse requests to an OpcServer
+type browseLog struct {
+	*energontroltest.OpcServer
+	mu      sync.Mutex
+	browsed []string
+}
+
+func (b *browseLog) Browse(ctx context.Context, ItemName string, ClientRequestHandle *string, ItemPath string, options gopcxmlda.TBrowseOptions) (gopcxmlda.TBrowse, error) {
+	b.mu.Lock()
+	b.browsed = append(b.browsed, ItemName+" "+options.BrowseFilter+" "+options.ElementNameFilter)
+	b.mu.Unlock()
+	return b.OpcServer.Browse(ctx, ItemName, ClientRequestHandle, ItemPath, options)
+}
+
+func (b *browseLog) requests() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.browsed...)
+}
+
+func TestDiscoveryServer(t *testing.T) {
+	scada := energontroltest.NewScada(4711, 1, 2, 3)
+	scada.Plants[2].NoCtrl = true
+	scada.Plants[3].NoReset = true
+	opc := &browseLog{OpcServer: energontroltest.NewOpcServer(scada)}
+	ctx := energontrol.WithOpcClient(context.Background(), opc)
+	ctx = energontrol.WithSessionWait(ctx, time.Millisecond, 50)
+
+	// the ServerController and the CapabilityController share the Discovery
+	d := &energontrol.Discovery{Reader: energontrol.ServerController{}}
+	ctrl := energontrol.ServerController{Discovery: d}
+	c := &energontrol.CapabilityController{Controller: ctrl}
+	T, err := ctrl.Turbines(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if T.ParkNo != 4711 || len(T.PlantNo) != 3 || !T.Ctrl[1] || !T.Reset[1] || T.Ctrl[2] || !T.Reset[2] || !T.Ctrl[3] || T.Reset[3] {
+		t.Errorf("Error: %+v", T)
+	}
+	// the plants are browsed with the filters of Turbines, the Data branch isn't
+	browsed := opc.requests()
+	for _, b := range browsed {
+		switch {
+		case strings.Contains(b, "/Data"):
+			t.Errorf("Error: browsed %q", b)
+		case strings.HasSuffix(b, "/Ctrl"), strings.HasSuffix(b, "/Reset"):
+			t.Errorf("Error: browsed %q without filter", b)
+		}
+	}
+	if len(browsed) != 1+3*3 {
+		t.Errorf("Error: %d browse requests %q", len(browsed), browsed)
+	}
+
+	ok, errList := c.Stop(ctx, 1234, false, false, 1, 2)
+	var capErr *energontrol.CapabilityError
+	if !ok[0] || errList[0] != nil || ok[1] || !errors.As(errList[1], &capErr) {
+		t.Errorf("Error: %v %v", ok, errList)
+	}
+	if _, err = ctrl.Turbines(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(opc.requests()); n != len(browsed) {
+		t.Errorf("Error: %d browse requests after the Stop, %d before", n, len(browsed))
+	}
+
+	// Invalidate of the CapabilityController browses the shared Discovery again
+	scada.Plants[2].NoCtrl = false
+	c.Invalidate()
+	ok, errList = c.Stop(ctx, 1234, false, false, 2)
+	if !ok[0] || errList[0] != nil {
+		t.Errorf("Error: %v %v", ok, errList)
+	}
+	if T, err = ctrl.Turbines(ctx); err != nil || !T.Ctrl[2] {
+		t.Errorf("Error: %+v %v", T, err)
+	}
+}
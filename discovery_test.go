@@ -0,0 +1,62 @@
+package energontrol_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dernate/energontrol"
+	"github.com/dernate/energontrol/energontroltest"
+)
+
+func TestDiscovery(t *testing.T) {
+	scada := energontroltest.NewScada(4711)
+	scada.Items = map[string]any{
+		"Loc/LocNo":                       uint64(4711),
+		"Loc/Wec/Plant1/Ctrl/SetCtrl":     uint64(0),
+		"Loc/Wec/Plant1/Ctrl/SetRbh":      uint64(0),
+		"Loc/Wec/Plant1/Reset/SetReset":   uint64(0),
+		"Loc/Wec/Plant1/Para/Rotor/Speed": float64(12),
+		"Loc/Wec/Plant2/Ctrl/Ctrl":        uint64(0),
+		"Loc/Wec/Plant3/Ctrl/SetCtrl":     uint64(0),
+	}
+	d := &energontrol.Discovery{Reader: scada}
+	ctx := energontrol.WithDiscoveryConcurrency(context.Background(), 2)
+	T, change, err := d.Refresh(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if T.ParkNo != 4711 || len(T.PlantNo) != 3 || !T.Ctrl[1] || !T.Rbh[1] || !T.Reset[1] || !T.Para[1] || T.Ctrl[2] || !T.Ctrl[3] || T.Rbh[3] {
+		t.Errorf("Error: %+v", T)
+	}
+	if len(change.Added) != 3 || len(change.Removed) != 0 {
+		t.Errorf("Error: %+v", change)
+	}
+
+	// known plants aren't browsed again without CapabilityTTL
+	delete(scada.Items, "Loc/Wec/Plant3/Ctrl/SetCtrl")
+	scada.Items["Loc/Wec/Plant3/Ctrl/Ctrl"] = uint64(0)
+	delete(scada.Items, "Loc/Wec/Plant2/Ctrl/Ctrl")
+	scada.Items["Loc/Wec/Plant4/Ctrl/SetCtrl"] = uint64(0)
+	if T, change, err = d.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(change.Added) != 1 || change.Added[0] != 4 || len(change.Removed) != 1 || change.Removed[0] != 2 || len(change.Capabilities) != 0 || !T.Ctrl[3] || !T.Ctrl[4] {
+		t.Errorf("Error: %+v, %+v", change, T)
+	}
+
+	// with CapabilityTTL the changed control items are found
+	d.CapabilityTTL = time.Nanosecond
+	if T, change, err = d.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(change.Capabilities) != 1 || change.Capabilities[0] != (energontrol.CapabilityChange{PlantNo: 3, Capability: energontrol.CapabilityCtrl}) || T.Ctrl[3] {
+		t.Errorf("Error: %+v, %+v", change, T)
+	}
+
+	// Turbines serves the cache, changes to the result don't touch it
+	T.Ctrl[1] = false
+	if T, err = d.Turbines(ctx); err != nil || !T.Ctrl[1] {
+		t.Errorf("Error: %+v, %v", T, err)
+	}
+}
@@ -5,9 +5,16 @@ import (
 	"errors"
 	"fmt"
 	"github.com/dernate/gopcxmlda"
+	"log/slog"
 )
 
 func Start(ctx context.Context, Server gopcxmlda.Server, UserId uint64, PlantNo ...uint8) ([]bool, []error) {
+	return observeCommand(ctx, "Start", UserId, PlantNo, func(ctx context.Context) ([]bool, []error) {
+		return start(ctx, Server, UserId, PlantNo...)
+	})
+}
+
+func start(ctx context.Context, Server gopcxmlda.Server, UserId uint64, PlantNo ...uint8) ([]bool, []error) {
 	var errList []error
 	var started []bool
 	if len(PlantNo) == 0 {
@@ -35,12 +42,16 @@ func Start(ctx context.Context, Server gopcxmlda.Server, UserId uint64, PlantNo
 		return make([]bool, len(PlantNo)), errList
 	}
 	// check if plants are already started. If not set an Action Bit
-	setActionToStart(&plantState)
+	setActionToStart(ctx, &plantState)
 	// Filter plants based on the evaluated Action Bit
 	var PlantNoToStart []uint8
 	for i, state := range plantState {
+		if state.Err != nil {
+			errList[i] = state.Err
+			continue
+		}
 		if !state.Action {
-			LogInfo(state.PlantNo, "Start", "Plant already started")
+			logPlant(ctx, slog.LevelInfo, state.PlantNo, "Start", "Plant already started")
 			started[i] = true
 		} else {
 			// Process just plants, that are not already started
@@ -59,7 +70,7 @@ func Start(ctx context.Context, Server gopcxmlda.Server, UserId uint64, PlantNo
 	if len(errListFiltered) > 0 {
 		for i, err := range errListFiltered {
 			if err != nil {
-				LogError(PlantNoToStart[i], "Start", err.Error())
+				logPlant(ctx, slog.LevelError, PlantNoToStart[i], "Start", err.Error())
 				for j, p := range PlantNo {
 					if PlantNoToStart[i] == p {
 						errList[j] = err
@@ -79,6 +90,16 @@ func Start(ctx context.Context, Server gopcxmlda.Server, UserId uint64, PlantNo
 
 // Stop FullStop = true stops to "Stop" (90° blade angle), while FullStop = false stops to "Stop60"
 func Stop(ctx context.Context, Server gopcxmlda.Server, UserId uint64, FullStop bool, ForceExplicitCommand bool, PlantNo ...uint8) ([]bool, []error) {
+	Action := "Stop60"
+	if FullStop {
+		Action = "Stop90"
+	}
+	return observeCommand(ctx, Action, UserId, PlantNo, func(ctx context.Context) ([]bool, []error) {
+		return stop(ctx, Server, UserId, FullStop, ForceExplicitCommand, PlantNo...)
+	})
+}
+
+func stop(ctx context.Context, Server gopcxmlda.Server, UserId uint64, FullStop bool, ForceExplicitCommand bool, PlantNo ...uint8) ([]bool, []error) {
 	var errList []error
 	var stopped []bool
 	if len(PlantNo) == 0 {
@@ -113,12 +134,16 @@ func Stop(ctx context.Context, Server gopcxmlda.Server, UserId uint64, FullStop
 		return make([]bool, len(PlantNo)), errList
 	}
 	// check if plants are already stopped. If not set an Action Bit. Consider ForceExplicitCommand.
-	setActionToStop(&plantState, ForceExplicitCommand, CtrlValue)
+	setActionToStop(ctx, &plantState, ForceExplicitCommand, CtrlValue)
 	// Filter plants based on the evaluated Action Bit
 	var PlantNoToStop []uint8
 	for i, state := range plantState {
+		if state.Err != nil {
+			errList[i] = state.Err
+			continue
+		}
 		if !state.Action {
-			LogInfo(state.PlantNo, Action, "Plant already stopped")
+			logPlant(ctx, slog.LevelInfo, state.PlantNo, Action, "Plant already stopped")
 			stopped[i] = true
 		} else {
 			// Process just plants, that are not already stopped
@@ -137,7 +162,7 @@ func Stop(ctx context.Context, Server gopcxmlda.Server, UserId uint64, FullStop
 	if len(errListFiltered) > 0 {
 		for i, err := range errListFiltered {
 			if err != nil {
-				LogError(PlantNoToStop[i], Action, err.Error())
+				logPlant(ctx, slog.LevelError, PlantNoToStop[i], Action, err.Error())
 				for j, p := range PlantNo {
 					if PlantNoToStop[i] == p {
 						errList[j] = err
@@ -156,6 +181,12 @@ func Stop(ctx context.Context, Server gopcxmlda.Server, UserId uint64, FullStop
 }
 
 func Reset(ctx context.Context, Server gopcxmlda.Server, UserId uint64, PlantNo ...uint8) ([]bool, []error) {
+	return observeCommand(ctx, "Reset", UserId, PlantNo, func(ctx context.Context) ([]bool, []error) {
+		return reset(ctx, Server, UserId, PlantNo...)
+	})
+}
+
+func reset(ctx context.Context, Server gopcxmlda.Server, UserId uint64, PlantNo ...uint8) ([]bool, []error) {
 	var errList []error
 	var resetted []bool
 	if len(PlantNo) == 0 {
@@ -180,7 +211,7 @@ func Reset(ctx context.Context, Server gopcxmlda.Server, UserId uint64, PlantNo
 	if len(errList) > 0 {
 		for i, err := range errList {
 			if err != nil {
-				LogError(PlantNo[i], Action, err.Error())
+				logPlant(ctx, slog.LevelError, PlantNo[i], Action, err.Error())
 			}
 		}
 	}
@@ -188,6 +219,12 @@ func Reset(ctx context.Context, Server gopcxmlda.Server, UserId uint64, PlantNo
 }
 
 func RbhOn(ctx context.Context, Server gopcxmlda.Server, UserId uint64, PlantNo ...uint8) ([]bool, []error) {
+	return observeCommand(ctx, "RbhOn", UserId, PlantNo, func(ctx context.Context) ([]bool, []error) {
+		return rbhOn(ctx, Server, UserId, PlantNo...)
+	})
+}
+
+func rbhOn(ctx context.Context, Server gopcxmlda.Server, UserId uint64, PlantNo ...uint8) ([]bool, []error) {
 	var errList []error
 	var rbhOn []bool
 	if len(PlantNo) == 0 {
@@ -219,8 +256,12 @@ func RbhOn(ctx context.Context, Server gopcxmlda.Server, UserId uint64, PlantNo
 	// Filter plants based on the evaluated Action Bit
 	var PlantNoToRbhOn []uint8
 	for i, state := range plantState {
+		if state.Err != nil {
+			errList[i] = state.Err
+			continue
+		}
 		if !state.Action {
-			LogInfo(state.PlantNo, "RbhOn", "Plant Rbh already On")
+			logPlant(ctx, slog.LevelInfo, state.PlantNo, "RbhOn", "Plant Rbh already On")
 			rbhOn[i] = true
 		} else {
 			// Process just plants, that are not already started
@@ -239,7 +280,7 @@ func RbhOn(ctx context.Context, Server gopcxmlda.Server, UserId uint64, PlantNo
 	if len(errListFiltered) > 0 {
 		for i, err := range errListFiltered {
 			if err != nil {
-				LogError(PlantNoToRbhOn[i], "RbhOn", err.Error())
+				logPlant(ctx, slog.LevelError, PlantNoToRbhOn[i], "RbhOn", err.Error())
 				for j, p := range PlantNo {
 					if PlantNoToRbhOn[i] == p {
 						errList[j] = err
@@ -258,6 +299,12 @@ func RbhOn(ctx context.Context, Server gopcxmlda.Server, UserId uint64, PlantNo
 }
 
 func RbhAutoOff(ctx context.Context, Server gopcxmlda.Server, UserId uint64, PlantNo ...uint8) ([]bool, []error) {
+	return observeCommand(ctx, "RbhAutoOff", UserId, PlantNo, func(ctx context.Context) ([]bool, []error) {
+		return rbhAutoOff(ctx, Server, UserId, PlantNo...)
+	})
+}
+
+func rbhAutoOff(ctx context.Context, Server gopcxmlda.Server, UserId uint64, PlantNo ...uint8) ([]bool, []error) {
 	var errList []error
 	var rbhAutoOff []bool
 	if len(PlantNo) == 0 {
@@ -289,8 +336,12 @@ func RbhAutoOff(ctx context.Context, Server gopcxmlda.Server, UserId uint64, Pla
 	// Filter plants based on the evaluated Action Bit
 	var PlantNoToRbhAutoOff []uint8
 	for i, state := range plantState {
+		if state.Err != nil {
+			errList[i] = state.Err
+			continue
+		}
 		if !state.Action {
-			LogInfo(state.PlantNo, "RbhAutoOff", "Plant Rbh already AutoOff")
+			logPlant(ctx, slog.LevelInfo, state.PlantNo, "RbhAutoOff", "Plant Rbh already AutoOff")
 			rbhAutoOff[i] = true
 		} else {
 			// Process just plants, that are not already started
@@ -309,7 +360,7 @@ func RbhAutoOff(ctx context.Context, Server gopcxmlda.Server, UserId uint64, Pla
 	if len(errListFiltered) > 0 {
 		for i, err := range errListFiltered {
 			if err != nil {
-				LogError(PlantNoToRbhAutoOff[i], "RbhAutoOff", err.Error())
+				logPlant(ctx, slog.LevelError, PlantNoToRbhAutoOff[i], "RbhAutoOff", err.Error())
 				for j, p := range PlantNo {
 					if PlantNoToRbhAutoOff[i] == p {
 						errList[j] = err
@@ -328,6 +379,12 @@ func RbhAutoOff(ctx context.Context, Server gopcxmlda.Server, UserId uint64, Pla
 }
 
 func RbhStandard(ctx context.Context, Server gopcxmlda.Server, UserId uint64, PlantNo ...uint8) ([]bool, []error) {
+	return observeCommand(ctx, "RbhStandard", UserId, PlantNo, func(ctx context.Context) ([]bool, []error) {
+		return rbhStandard(ctx, Server, UserId, PlantNo...)
+	})
+}
+
+func rbhStandard(ctx context.Context, Server gopcxmlda.Server, UserId uint64, PlantNo ...uint8) ([]bool, []error) {
 	var errList []error
 	var rbhStandard []bool
 	if len(PlantNo) == 0 {
@@ -359,8 +416,12 @@ func RbhStandard(ctx context.Context, Server gopcxmlda.Server, UserId uint64, Pl
 	// Filter plants based on the evaluated Action Bit
 	var PlantNoToRbhStandard []uint8
 	for i, state := range plantState {
+		if state.Err != nil {
+			errList[i] = state.Err
+			continue
+		}
 		if !state.Action {
-			LogInfo(state.PlantNo, "RbhStandard", "Plant Rbh already Standard")
+			logPlant(ctx, slog.LevelInfo, state.PlantNo, "RbhStandard", "Plant Rbh already Standard")
 			rbhStandard[i] = true
 		} else {
 			// Process just plants, that are not already started
@@ -379,7 +440,7 @@ func RbhStandard(ctx context.Context, Server gopcxmlda.Server, UserId uint64, Pl
 	if len(errListFiltered) > 0 {
 		for i, err := range errListFiltered {
 			if err != nil {
-				LogError(PlantNoToRbhStandard[i], "RbhStandard", err.Error())
+				logPlant(ctx, slog.LevelError, PlantNoToRbhStandard[i], "RbhStandard", err.Error())
 				for j, p := range PlantNo {
 					if PlantNoToRbhStandard[i] == p {
 						errList[j] = err
@@ -399,6 +460,12 @@ func RbhStandard(ctx context.Context, Server gopcxmlda.Server, UserId uint64, Pl
 
 // ControlAndRbh Set Ctrl and Rbh values for plants at the same time
 func ControlAndRbh(ctx context.Context, Server gopcxmlda.Server, UserId uint64, Values ControlAndRbhValue, PlantNo ...uint8) ([]bool, []error) {
+	return observeCommand(ctx, "ControlAndRbh", UserId, PlantNo, func(ctx context.Context) ([]bool, []error) {
+		return controlAndRbh(ctx, Server, UserId, Values, PlantNo...)
+	})
+}
+
+func controlAndRbh(ctx context.Context, Server gopcxmlda.Server, UserId uint64, Values ControlAndRbhValue, PlantNo ...uint8) ([]bool, []error) {
 	var errList []error
 	var controlled []bool
 	if len(PlantNo) == 0 {
@@ -431,9 +498,9 @@ func ControlAndRbh(ctx context.Context, Server gopcxmlda.Server, UserId uint64,
 			return controlled, errList
 		}
 		if Values.CtrlValue > 0 {
-			setActionToStop(&CtrlState, false, Values.CtrlValue)
+			setActionToStop(ctx, &CtrlState, false, Values.CtrlValue)
 		} else {
-			setActionToStart(&CtrlState)
+			setActionToStart(ctx, &CtrlState)
 		}
 		for _, state := range CtrlState {
 			if state.Action {
@@ -467,21 +534,37 @@ func ControlAndRbh(ctx context.Context, Server gopcxmlda.Server, UserId uint64,
 			Values.SetRbhValue = false
 		}
 	}
+	// plants, whose state couldn't be read, fail
+	readErr := make([]error, len(PlantNo))
+	for _, states := range [][]PlantState{CtrlState, RbhState} {
+		for i, state := range states {
+			if state.Err != nil && readErr[i] == nil {
+				readErr[i] = state.Err
+				errList[i] = state.Err
+			}
+		}
+	}
 	// Filter plants based on the evaluated Action Bit
 	var PlantNoToControl []uint8
 	if !Values.SetCtrlValue && !Values.SetRbhValue {
 		for i, p := range PlantNo {
-			LogInfo(p, "ControlAndRbh", "Ctrl & Rbh of Plant already controlled")
+			if readErr[i] != nil {
+				continue
+			}
+			logPlant(ctx, slog.LevelInfo, p, "ControlAndRbh", "Ctrl & Rbh of Plant already controlled")
 			controlled[i] = true
 		}
 	} else {
 		for i, p := range PlantNo {
+			if readErr[i] != nil {
+				continue
+			}
 			if Values.CtrlAction != nil && Values.CtrlAction[i] {
 				PlantNoToControl = append(PlantNoToControl, p)
 			} else if Values.RbhAction != nil && Values.RbhAction[i] {
 				PlantNoToControl = append(PlantNoToControl, p)
 			} else {
-				LogInfo(PlantNo[i], "ControlAndRbh", "Ctrl of Plant already controlled")
+				logPlant(ctx, slog.LevelInfo, PlantNo[i], "ControlAndRbh", "Ctrl of Plant already controlled")
 				controlled[i] = true
 			}
 		}
@@ -491,7 +574,7 @@ func ControlAndRbh(ctx context.Context, Server gopcxmlda.Server, UserId uint64,
 	if len(errListFiltered) > 0 {
 		for i, err := range errListFiltered {
 			if err != nil {
-				LogError(PlantNoToControl[i], "ControlAndRbh", err.Error())
+				logPlant(ctx, slog.LevelError, PlantNoToControl[i], "ControlAndRbh", err.Error())
 				for j, p := range PlantNo {
 					if PlantNoToControl[i] == p {
 						errList[j] = err
@@ -510,6 +593,8 @@ func ControlAndRbh(ctx context.Context, Server gopcxmlda.Server, UserId uint64,
 }
 
 func Turbines(ctx context.Context, Server gopcxmlda.Server) (TurbineInfo, error) {
+	ctx, span := startSpan(ctx, "Turbines")
+	defer span.End()
 	// check if Server is connected
 	if available, err := ServerAvailable(ctx, Server); !available {
 		return TurbineInfo{}, err
@@ -517,7 +602,7 @@ func Turbines(ctx context.Context, Server gopcxmlda.Server) (TurbineInfo, error)
 	// Browse for all Turbines
 	var ClientRequestHandle string
 	options := gopcxmlda.TBrowseOptions{}
-	b, err := Server.Browse(ctx, "Loc/Wec", &ClientRequestHandle, "", options)
+	b, err := opcBrowse(ctx, Server, "Loc/Wec", &ClientRequestHandle, "", options)
 	if err != nil {
 		return TurbineInfo{}, err
 	}
@@ -532,6 +617,8 @@ func Turbines(ctx context.Context, Server gopcxmlda.Server) (TurbineInfo, error)
 
 // ParkNoMatch Read the Park Number from the Server and compare it with the provided ParkNo
 func ParkNoMatch(ctx context.Context, Server gopcxmlda.Server, ParkNo uint64, checkAvailable bool) (bool, error) {
+	ctx, span := startSpan(ctx, "ParkNoMatch")
+	defer span.End()
 	if checkAvailable {
 		// check if Server is connected
 		if available, err := ServerAvailable(ctx, Server); !available {
@@ -549,20 +636,24 @@ func ParkNoMatch(ctx context.Context, Server gopcxmlda.Server, ParkNo uint64, ch
 			ItemName: "Loc/LocNo",
 		},
 	}
-	value, err := Server.Read(ctx, Item, &handle1, &handle2, "", options)
+	value, err := opcRead(ctx, Server, Item, &handle1, &handle2, "", options)
 	if err != nil {
 		return false, err
 	}
-	if value.Response.ItemList.Items[0].Value.Value == ParkNo {
-		return true, nil
+	read, err := itemValues(value, 1, toUint64)
+	if err != nil {
+		return false, err
 	}
-	return false, nil
+	return read[0] == ParkNo, nil
 }
 
+// GetPlantCtrlOrRbhState Read the Ctrl or Rbh state of the plants. Plants, whose item failed, have Err set, the others are still read.
 func GetPlantCtrlOrRbhState(ctx context.Context, Server gopcxmlda.Server, CtrlOrRbh string, PlantNo []uint8) ([]PlantState, error) {
 	if CtrlOrRbh != "Ctrl" && CtrlOrRbh != "Rbh" {
 		return nil, fmt.Errorf("CtrlOrRbh must be either Ctrl or Rbh")
 	}
+	ctx, span := startSpan(ctx, "GetPlant"+CtrlOrRbh+"State", plantsAttr(PlantNo))
+	defer span.End()
 	// check plant ctrl state
 	var handle1 string
 	var handle2 []string
@@ -575,15 +666,63 @@ func GetPlantCtrlOrRbhState(ctx context.Context, Server gopcxmlda.Server, CtrlOr
 			ItemName: fmt.Sprintf("Loc/Wec/Plant%d/Ctrl/%s", plant, CtrlOrRbh),
 		})
 	}
-	value, err := Server.Read(ctx, items, &handle1, &handle2, "", options)
+	value, err := opcRead(ctx, Server, items, &handle1, &handle2, "", options)
 	if err != nil {
 		return nil, err
 	} else {
+		names := make([]string, len(items))
+		for i, item := range items {
+			names[i] = item.ItemName
+		}
+		states, errs := itemsByName(value, names, toUint64)
 		plantState := make([]PlantState, len(PlantNo))
-		for i, item := range value.Response.ItemList.Items {
+		o := observer(ctx)
+		for i, state := range states {
 			plantState[i].PlantNo = PlantNo[i]
-			plantState[i].CtrlState = item.Value.Value.(uint64)
+			if errs[i] != nil {
+				// only this plant fails
+				plantState[i].Err = errs[i]
+				if CtrlOrRbh == "Ctrl" {
+					plantState[i].CtrlState = CtrlValues["CommunicationError"]
+				}
+				logPlant(ctx, slog.LevelWarn, PlantNo[i], "Get"+CtrlOrRbh+"State", errs[i].Error())
+				continue
+			}
+			plantState[i].CtrlState = state
+			if o != nil {
+				o.PlantState(PlantNo[i], CtrlOrRbh, plantState[i].CtrlState)
+			}
 		}
 		return plantState, nil
 	}
 }
+
+// GetSessionState Get the Ctrl or Reset session state of the plants without waiting for a state
+func GetSessionState(ctx context.Context, Server gopcxmlda.Server, CtrlOrReset string, PlantNo []uint8) ([]uint16, error) {
+	if CtrlOrReset != "Ctrl" && CtrlOrReset != "Reset" {
+		return nil, fmt.Errorf("CtrlOrReset must be either Ctrl or Reset")
+	}
+	ctx, span := startSpan(ctx, "Get"+CtrlOrReset+"SessionState", plantsAttr(PlantNo))
+	defer span.End()
+	var handle1 string
+	var handle2 []string
+	options := map[string]interface{}{
+		"returnItemName": true,
+	}
+	var items []gopcxmlda.TItem
+	for _, plant := range PlantNo {
+		items = append(items, gopcxmlda.TItem{
+			ItemName: fmt.Sprintf("Loc/Wec/Plant%d/%s/SessionState", plant, CtrlOrReset),
+		})
+	}
+	value, err := opcRead(ctx, Server, items, &handle1, &handle2, "", options)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(items))
+	for i, item := range items {
+		names[i] = item.ItemName
+	}
+	states, errs := itemsByName(value, names, toUint16)
+	return states, errors.Join(errs...)
+}
@@ -2,39 +2,76 @@ package energontrol
 
 import (
 	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"github.com/dernate/gopcxmlda"
-	"math/rand"
+	"go.opentelemetry.io/otel/attribute"
+	"io"
+	"log/slog"
 	"regexp"
 	"strconv"
+	"sync"
 	"time"
 )
 
 func ServerAvailable(ctx context.Context, Server gopcxmlda.Server) (bool, error) {
+	ctx, span := startSpan(ctx, "ServerAvailable")
+	defer span.End()
 	// check if Server is connected
 	var handle string
-	status, err := Server.GetStatus(ctx, &handle, "")
-	if err != nil {
-		return false, err
+	status, err := opcGetStatus(ctx, Server, &handle, "")
+	available := err == nil && status.Response.Result.ServerState == "running"
+	if o := observer(ctx); o != nil {
+		o.ServerAvailable(available, err)
 	}
-	return status.Response.Result.ServerState == "running", nil
+	return available, err
+}
+
+// GetServerStatus Read the state and vendor info of the Server with GetStatus
+func GetServerStatus(ctx context.Context, Server gopcxmlda.Server) (ServerStatus, error) {
+	ctx, span := startSpan(ctx, "GetServerStatus")
+	defer span.End()
+	var handle string
+	status, err := opcGetStatus(ctx, Server, &handle, "")
+	if err != nil {
+		return ServerStatus{}, err
+	}
+	return ServerStatus{
+		State:          status.Response.Result.ServerState,
+		ReplyTime:      status.Response.Result.ReplyTime,
+		StartTime:      status.Response.Status.StartTime,
+		VendorInfo:     status.Response.Status.VendorInfo,
+		ProductVersion: status.Response.Status.ProductVersion,
+		StatusInfo:     status.Response.Status.StatusInfo,
+	}, nil
 }
 
-func setActionToStart(plantState *[]PlantState) {
+func setActionToStart(ctx context.Context, plantState *[]PlantState) {
 	for i, state := range *plantState {
+		if state.Err != nil {
+			// the state is unknown, nothing is sent
+			(*plantState)[i].Action = false
+			continue
+		}
 		// If CtrlState is 0, the plant is already started.
 		// If CtrlState is 129 or above, we can't start the plant.
 		if state.CtrlState == 0 || state.CtrlState > 128 {
 			(*plantState)[i].Action = false
-			LogIfStateChangePermitted(state, state.PlantNo, 0) // 0=="Start". desiredState is just for the Log Message if even needed
+			logIfStateChangePermitted(ctx, state, state.PlantNo, 0) // 0=="Start". desiredState is just for the Log Message if even needed
 		} else {
 			(*plantState)[i].Action = true
 		}
 	}
 }
 
-func setActionToStop(plantState *[]PlantState, ForceExplicitCommand bool, Action uint64) {
+func setActionToStop(ctx context.Context, plantState *[]PlantState, ForceExplicitCommand bool, Action uint64) {
 	for i, state := range *plantState {
+		if state.Err != nil {
+			// the state is unknown, nothing is sent
+			(*plantState)[i].Action = false
+			continue
+		}
 		// If CtrlState is 129 or 130, the plant is already stopped, but we can't force a change.
 		// If CtrlState is 255, no one can change the state.
 		// If ForceExplicitCommand is true, we can force a change, e.g. from 60° Stop to a 90° Stop or vice versa.
@@ -42,9 +79,9 @@ func setActionToStop(plantState *[]PlantState, ForceExplicitCommand bool, Action
 			(!ForceExplicitCommand && state.CtrlState > 0) {
 			(*plantState)[i].Action = false
 			if ForceExplicitCommand {
-				LogIfStateChangePermitted(state, state.PlantNo, Action)
+				logIfStateChangePermitted(ctx, state, state.PlantNo, Action)
 			} else {
-				LogIfStateChangePermitted(state, state.PlantNo, Action) // 2=="Stop". desiredState is just for the Log Message if even needed
+				logIfStateChangePermitted(ctx, state, state.PlantNo, Action) // 2=="Stop". desiredState is just for the Log Message if even needed
 			}
 		} else {
 			(*plantState)[i].Action = true
@@ -54,6 +91,11 @@ func setActionToStop(plantState *[]PlantState, ForceExplicitCommand bool, Action
 
 func setActionRbh(plantState *[]PlantState, Action uint64) {
 	for i, state := range *plantState {
+		if state.Err != nil {
+			// the state is unknown, nothing is sent
+			(*plantState)[i].Action = false
+			continue
+		}
 		if rbhStatusRight(state.CtrlState, Action) {
 			(*plantState)[i].Action = false
 		} else {
@@ -122,12 +164,8 @@ func controlProcedure(ctx context.Context, Server gopcxmlda.Server, UserId uint6
 		success = append(success, false)
 	}
 	// Get session state
-	WaitFor := WaitForState{
-		Desired: 0,
-		Sleep:   100 * time.Millisecond,
-		Retries: 10,
-	}
-	SesState, err := sessionState(ctx, Server, SessionType, WaitFor, PlantNo...)
+	WaitFor := sessionWait(ctx, 0)
+	SesState, SesErr, err := sessionState(ctx, Server, SessionType, WaitFor, PlantNo...)
 	if err != nil {
 		for i := range errList {
 			errList[i] = err
@@ -142,29 +180,67 @@ func controlProcedure(ctx context.Context, Server gopcxmlda.Server, UserId uint6
 		}
 		return success, errList
 	}
+	sessions := startPlantSessions(ctx, SessionType, PlantNo)
+	defer func() { sessions.end(errList) }()
+	sessions.state(SesState, SesErr, errList)
 	var SessionRequestValues []SessionRequest
 	for range PlantNo {
 		SessionRequestValues = append(SessionRequestValues, SessionRequest{})
 	}
+	// writes, which failed with an unknown outcome, are checked with the next session state instead of being repeated
+	uncertain := make([]error, len(PlantNo))
+	// the SessionId of a plant is released, as soon as its session failed, the others when the procedure returns
+	open := make([]bool, len(PlantNo))
+	release := func() {
+		for i := range PlantNo {
+			if open[i] && errList[i] != nil {
+				releaseSessionId(newSessionKey(Server, PlantNo[i], SessionType), SessionRequestValues[i])
+				open[i] = false
+			}
+		}
+	}
+	defer func() {
+		for i := range PlantNo {
+			if open[i] {
+				releaseSessionId(newSessionKey(Server, PlantNo[i], SessionType), SessionRequestValues[i])
+			}
+		}
+	}()
 	for i, plant := range PlantNo {
+		if SesErr[i] != nil {
+			// only this plant's session state couldn't be read
+			errList[i] = SesErr[i]
+			success[i] = false
+			continue
+		}
 		if SesState[i] != 0 {
 			errMsg := fmt.Sprintf("Can't start session, %s", getSessionStateText(SesState[i]))
-			LogWarn(plant, Action, errMsg)
-			errList[i] = fmt.Errorf(errMsg)
+			logPlant(ctx, slog.LevelWarn, plant, Action, errMsg)
+			errList[i] = newSessionError(ctx, plant, SesState[i], errMsg)
 			success[i] = false
 			continue
 		}
 		// do session request
-		SessionRequestValues[i] = generateSessionRequest(UserId)
-		err = requestSession(ctx, Server, SessionRequestValues[i], plant, SessionType)
+		SessionRequestValues[i], err = generateSessionRequest(sessions.ctx[i], newSessionKey(Server, plant, SessionType), UserId)
 		if err != nil {
 			errList[i] = err
 			success[i] = false
+			continue
+		}
+		open[i] = true
+		err = requestSession(sessions.ctx[i], Server, SessionRequestValues[i], plant, SessionType)
+		if writeUncertain(err) {
+			uncertain[i] = err
+			logPlant(ctx, slog.LevelWarn, plant, Action, fmt.Sprintf("SessionRequest failed, checking the session state: %s", err))
+		} else if err != nil {
+			errList[i] = err
+			success[i] = false
 		}
 	}
+	release()
 	// Get new Session State
 	WaitFor.Desired = 1
-	SesState, err = sessionState(ctx, Server, SessionType, WaitFor, PlantNo...)
+	SesState, SesErr, err = sessionState(ctx, Server, SessionType, WaitFor, PlantNo...)
 	if err != nil {
 		for i := range errList {
 			errList[i] = err
@@ -179,20 +255,30 @@ func controlProcedure(ctx context.Context, Server gopcxmlda.Server, UserId uint6
 		}
 		return success, errList
 	}
+	sessions.state(SesState, SesErr, errList)
 	var PublicKeys []uint64
 	for range PlantNo {
 		PublicKeys = append(PublicKeys, 0)
 	}
 	for i, plant := range PlantNo {
+		if errList[i] != nil {
+			// failed in an earlier step
+			continue
+		}
+		if SesErr[i] != nil {
+			// only this plant's session state couldn't be read
+			errList[i] = SesErr[i]
+			success[i] = false
+			continue
+		}
 		if SesState[i] != 1 {
-			errMsg := fmt.Sprintf("Session error for Plant %d, %s", plant, getSessionStateText(SesState[i]))
-			LogWarn(plant, Action, errMsg)
-			errList[i] = fmt.Errorf(errMsg)
+			errList[i] = sessionStepError(ctx, plant, Action, SesState[i], uncertain[i])
 			success[i] = false
 			continue
 		}
+		confirmWrite(ctx, plant, Action, &uncertain[i])
 		var PublicKey uint64
-		PublicKey, err = getPublicKey(ctx, Server, plant, SessionType)
+		PublicKey, err = getPublicKey(sessions.ctx[i], Server, plant, SessionType)
 		if err != nil {
 			PublicKeys[i] = 0
 			errList[i] = err
@@ -200,17 +286,27 @@ func controlProcedure(ctx context.Context, Server gopcxmlda.Server, UserId uint6
 			continue
 		}
 		PublicKeys[i] = PublicKey
+		writeRbh := Values.SetRbhValue && Values.RbhAction[i]
 		if Values.SetCtrlValue && Values.CtrlAction[i] {
-			err = writeControlValue(ctx, Server, plant, Values.CtrlValue, SessionRequestValues[i].PrivateKey, PublicKey, "Ctrl")
-			if err != nil {
+			err = writeControlValue(sessions.ctx[i], Server, plant, Values.CtrlValue, SessionRequestValues[i].PrivateKey, PublicKey, "Ctrl")
+			// with an uncertain SetCtrl the session state can't tell, if SetRbh is still needed
+			if writeUncertain(err) && !writeRbh {
+				uncertain[i] = err
+				logPlant(ctx, slog.LevelWarn, plant, Action, fmt.Sprintf("SetCtrl failed, checking the session state: %s", err))
+				continue
+			} else if err != nil {
 				errList[i] = err
 				success[i] = false
 				continue
 			}
 		}
-		if Values.SetRbhValue && Values.RbhAction[i] {
-			err = writeControlValue(ctx, Server, plant, Values.RbhValue, SessionRequestValues[i].PrivateKey, PublicKey, "Rbh")
-			if err != nil {
+		if writeRbh {
+			err = writeControlValue(sessions.ctx[i], Server, plant, Values.RbhValue, SessionRequestValues[i].PrivateKey, PublicKey, "Rbh")
+			if writeUncertain(err) {
+				uncertain[i] = err
+				logPlant(ctx, slog.LevelWarn, plant, Action, fmt.Sprintf("SetRbh failed, checking the session state: %s", err))
+				continue
+			} else if err != nil {
 				errList[i] = err
 				success[i] = false
 				continue
@@ -218,9 +314,10 @@ func controlProcedure(ctx context.Context, Server gopcxmlda.Server, UserId uint6
 		}
 	}
 
+	release()
 	// Get new Session State
 	WaitFor.Desired = 2
-	SesState, err = sessionState(ctx, Server, SessionType, WaitFor, PlantNo...)
+	SesState, SesErr, err = sessionState(ctx, Server, SessionType, WaitFor, PlantNo...)
 	if err != nil {
 		for i := range errList {
 			errList[i] = err
@@ -235,24 +332,38 @@ func controlProcedure(ctx context.Context, Server gopcxmlda.Server, UserId uint6
 		}
 		return success, errList
 	}
+	sessions.state(SesState, SesErr, errList)
 	for i, plant := range PlantNo {
+		if errList[i] != nil {
+			// failed in an earlier step
+			continue
+		}
+		if SesErr[i] != nil {
+			// only this plant's session state couldn't be read
+			errList[i] = SesErr[i]
+			success[i] = false
+			continue
+		}
 		if SesState[i] != 2 {
-			errMsg := fmt.Sprintf("Session error for Plant %d, %s", plant, getSessionStateText(SesState[i]))
-			LogWarn(plant, Action, errMsg)
-			errList[i] = fmt.Errorf(errMsg)
+			errList[i] = sessionStepError(ctx, plant, Action, SesState[i], uncertain[i])
 			success[i] = false
 			continue
 		}
-		err = submitValue(ctx, Server, plant, SessionRequestValues[i].PrivateKey, PublicKeys[i], SessionType)
-		if err != nil {
+		confirmWrite(ctx, plant, Action, &uncertain[i])
+		err = submitValue(sessions.ctx[i], Server, plant, SessionRequestValues[i].PrivateKey, PublicKeys[i], SessionType)
+		if writeUncertain(err) {
+			uncertain[i] = err
+			logPlant(ctx, slog.LevelWarn, plant, Action, fmt.Sprintf("SessionSubmit failed, checking the session state: %s", err))
+		} else if err != nil {
 			errList[i] = err
 			success[i] = false
 			continue
 		}
 	}
+	release()
 	// Get new Session State
 	WaitFor.Desired = 4
-	SesState, err = sessionState(ctx, Server, SessionType, WaitFor, PlantNo...)
+	SesState, SesErr, err = sessionState(ctx, Server, SessionType, WaitFor, PlantNo...)
 	if err != nil {
 		for i := range errList {
 			errList[i] = err
@@ -267,30 +378,75 @@ func controlProcedure(ctx context.Context, Server gopcxmlda.Server, UserId uint6
 		}
 		return success, errList
 	}
+	sessions.state(SesState, SesErr, errList)
 	for i, plant := range PlantNo {
+		if errList[i] != nil {
+			// failed in an earlier step
+			continue
+		}
+		if SesErr[i] != nil {
+			// only this plant's session state couldn't be read
+			errList[i] = SesErr[i]
+			success[i] = false
+			continue
+		}
 		if SesState[i] != 4 {
-			errMsg := fmt.Sprintf("Session error for Plant %d, %s", plant, getSessionStateText(SesState[i]))
-			LogWarn(plant, Action, errMsg)
-			errList[i] = fmt.Errorf(errMsg)
+			errList[i] = sessionStepError(ctx, plant, Action, SesState[i], uncertain[i])
 			success[i] = false
 			continue
 		}
+		confirmWrite(ctx, plant, Action, &uncertain[i])
 		success[i] = true
 	}
 	return success, errList
 }
 
-// Get the session state of a plant
-func sessionState(ctx context.Context, Server gopcxmlda.Server, CtrlOrReset string, WaitFor WaitForState, PlantNo ...uint8) ([]uint16, error) {
+// writeUncertain Check if a write failed without an answer of the server, so it may have been executed anyway.
+// Such writes are never repeated, the next session state shows if they took effect.
+func writeUncertain(err error) bool {
+	var itemErr *ItemError
+	return err != nil && !errors.As(err, &itemErr)
+}
+
+// sessionStepError Get the error of a plant, whose session didn't reach the state of the next step. An uncertain write of the step
+// is joined, as it is the likely cause.
+func sessionStepError(ctx context.Context, PlantNo uint8, Action string, State uint16, uncertain error) error {
+	errMsg := fmt.Sprintf("Session error for Plant %d, %s", PlantNo, getSessionStateText(State))
+	logPlant(ctx, slog.LevelWarn, PlantNo, Action, errMsg)
+	err := newSessionError(ctx, PlantNo, State, errMsg)
+	if uncertain != nil {
+		return errors.Join(uncertain, err)
+	}
+	return err
+}
+
+// confirmWrite Clear an uncertain write, which the session state showed to have taken effect
+func confirmWrite(ctx context.Context, PlantNo uint8, Action string, uncertain *error) {
+	if *uncertain != nil {
+		logPlant(ctx, slog.LevelInfo, PlantNo, Action, fmt.Sprintf("session state confirms the write despite: %s", *uncertain))
+		*uncertain = nil
+	}
+}
+
+// Get the session state of the plants. A plant, whose item failed, has its error in the second slice.
+func sessionState(ctx context.Context, Server gopcxmlda.Server, CtrlOrReset string, WaitFor WaitForState, PlantNo ...uint8) ([]uint16, []error, error) {
 	if CtrlOrReset != "Ctrl" && CtrlOrReset != "Reset" {
-		return nil, fmt.Errorf("CtrlOrReset must be either Ctrl or Reset")
+		return nil, nil, fmt.Errorf("CtrlOrReset must be either Ctrl or Reset")
 	}
+	ctx, span := startSpan(ctx, "Wait for "+sessionStates[WaitFor.Desired],
+		attribute.String("energontrol.session.type", CtrlOrReset),
+		attribute.Int("energontrol.session.desired_state", int(WaitFor.Desired)),
+		plantsAttr(PlantNo))
+	defer span.End()
 	// read sessionState
 	var stateItems []gopcxmlda.TItem
+	var names []string
 	for _, plant := range PlantNo {
+		name := fmt.Sprintf("Loc/Wec/Plant%d/%s/SessionState", plant, CtrlOrReset)
 		stateItems = append(stateItems, gopcxmlda.TItem{
-			ItemName: fmt.Sprintf("Loc/Wec/Plant%d/%s/SessionState", plant, CtrlOrReset),
+			ItemName: name,
 		})
+		names = append(names, name)
 	}
 	var handle1 string
 	var handle2 []string
@@ -300,19 +456,21 @@ func sessionState(ctx context.Context, Server gopcxmlda.Server, CtrlOrReset stri
 	var value gopcxmlda.TRead
 	var err error
 	var retSessionState []uint16
+	var itemErrs []error
+	start := time.Now()
 	for range WaitFor.Retries + 1 {
-		value, err = Server.Read(ctx, stateItems, &handle1, &handle2, "", options)
+		value, err = opcRead(ctx, Server, stateItems, &handle1, &handle2, "", options)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
+		retSessionState, itemErrs = itemsByName(value, names, toUint16)
 		if WaitFor.Retries > 0 {
-			bOk := false
-			for _, item := range value.Response.ItemList.Items {
-				if WaitFor.Desired != item.Value.Value.(uint16) {
+			// plants without a readable state don't keep the others waiting
+			bOk := true
+			for i, state := range retSessionState {
+				if itemErrs[i] == nil && WaitFor.Desired != state {
 					bOk = false
 					break
-				} else {
-					bOk = true
 				}
 			}
 			if !bOk {
@@ -323,10 +481,41 @@ func sessionState(ctx context.Context, Server gopcxmlda.Server, CtrlOrReset stri
 			}
 		}
 	}
-	for _, item := range value.Response.ItemList.Items {
-		retSessionState = append(retSessionState, item.Value.Value.(uint16))
+	states := make([]int, len(retSessionState))
+	for i, state := range retSessionState {
+		states[i] = int(state)
 	}
-	return retSessionState, nil
+	span.SetAttributes(attribute.IntSlice("energontrol.session.states", states))
+	if o := observer(ctx); o != nil {
+		if WaitFor.Retries > 0 {
+			o.SessionPhase(sessionStates[WaitFor.Desired], time.Since(start))
+		}
+	}
+	return retSessionState, itemErrs, nil
+}
+
+// SessionError a session of a plant couldn't be used because of its session state
+type SessionError struct {
+	PlantNo uint8
+	State   uint16
+	Text    string
+}
+
+// newSessionError Create the SessionError of a failed plant and report it to the Observer, once per plant and command
+func newSessionError(ctx context.Context, PlantNo uint8, State uint16, Text string) *SessionError {
+	if o := observer(ctx); o != nil {
+		o.SessionError(PlantNo, State)
+	}
+	return &SessionError{PlantNo: PlantNo, State: State, Text: Text}
+}
+
+func (e *SessionError) Error() string {
+	return e.Text
+}
+
+// Occupied Check if the session is used by someone else, so it's worth trying again later
+func (e *SessionError) Occupied() bool {
+	return e.State == 108 || (e.State >= 1 && e.State <= 5)
 }
 
 func getSessionStateText(state uint16) string {
@@ -342,22 +531,86 @@ func getRbhStateText(state uint64) []string {
 	if state == 0 {
 		return []string{RbhStatus[RbhNoAccess]}
 	}
-	// Überprüfen der Bitmasken und Hinzufügen der entsprechenden Nachrichten
-	for mask, message := range RbhStatus {
-		if state&mask != 0 {
+	// Überprüfen der Bitmasken und Hinzufügen der entsprechenden Nachrichten, sortiert nach Bit
+	for bit := 0; bit < 64; bit++ {
+		mask := uint64(1) << bit
+		if message, exists := RbhStatus[mask]; exists && state&mask != 0 {
 			st = append(st, message)
 		}
 	}
 	return st
 }
 
-func generateSessionRequest(UserId uint64) SessionRequest {
-	var SR SessionRequest
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
-	SR.SessionId = uint8(r.Intn(20))
-	SR.PrivateKey = uint16(r.Intn(32000))
-	SR.UserId = UserId
-	return SR
+// sessionKey a session of a plant on a server, e.g. the Ctrl session of Plant 3
+type sessionKey struct {
+	Server  string
+	PlantNo uint8
+	Session string // Ctrl or Reset
+}
+
+// newSessionKey Get the sessionKey of a plant's session on Server
+func newSessionKey(Server gopcxmlda.Server, PlantNo uint8, Session string) sessionKey {
+	key := sessionKey{PlantNo: PlantNo, Session: Session}
+	if Server.Url != nil {
+		key.Server = Server.Url.String()
+	}
+	return key
+}
+
+// sessionIds the SessionIds used by the open sessions of each plant, as bits, so concurrent sessions of the process
+// never share one on the same plant. The SCADA accepts SessionIds 0 to 19.
+var sessionIds struct {
+	sync.Mutex
+	used map[sessionKey]uint32
+}
+
+// sessionIdCount the number of SessionIds the SCADA accepts
+const sessionIdCount = 20
+
+// generateSessionRequest Create a session request for the session Key with a random PrivateKey and a random SessionId,
+// which no other open session of the plant uses. The random numbers come from sessionRandom(ctx).
+// The SessionId has to be released with releaseSessionId after the session.
+func generateSessionRequest(ctx context.Context, Key sessionKey, UserId uint64) (SessionRequest, error) {
+	r := sessionRandom(ctx)
+	var b [3]byte
+	SR := SessionRequest{UserId: UserId}
+	// 240 and 64000 are multiples of 20 and 32000, so rejecting larger numbers avoids a bias of the modulo
+	for {
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return SR, fmt.Errorf("generate session request: %w", err)
+		}
+		if key := binary.BigEndian.Uint16(b[1:]); b[0] < 240 && key < 64000 {
+			SR.PrivateKey = key % 32000
+			break
+		}
+	}
+	sessionIds.Lock()
+	defer sessionIds.Unlock()
+	if sessionIds.used == nil {
+		sessionIds.used = make(map[sessionKey]uint32)
+	}
+	used := sessionIds.used[Key]
+	for i := range sessionIdCount {
+		id := (int(b[0]) + i) % sessionIdCount
+		if used&(1<<id) == 0 {
+			sessionIds.used[Key] = used | 1<<id
+			SR.SessionId = uint8(id)
+			return SR, nil
+		}
+	}
+	return SR, fmt.Errorf("generate session request: all %d SessionIds of plant %d are in use", sessionIdCount, Key.PlantNo)
+}
+
+// releaseSessionId Free the SessionId of a session request for the session Key, once the session ended
+func releaseSessionId(Key sessionKey, SR SessionRequest) {
+	sessionIds.Lock()
+	defer sessionIds.Unlock()
+	used := sessionIds.used[Key] &^ (1 << SR.SessionId)
+	if used == 0 {
+		delete(sessionIds.used, Key)
+	} else {
+		sessionIds.used[Key] = used
+	}
 }
 
 // requestSession Request a session
@@ -365,6 +618,8 @@ func requestSession(ctx context.Context, Server gopcxmlda.Server, SR SessionRequ
 	if CtrlOrReset != "Ctrl" && CtrlOrReset != "Reset" {
 		return fmt.Errorf("CtrlOrReset must be either Ctrl or Reset")
 	}
+	ctx, span := startSpan(ctx, "SessionRequest", plantAttr(PlantNo), attribute.String("energontrol.session.type", CtrlOrReset))
+	defer span.End()
 	items := []gopcxmlda.TItem{
 		{
 			ItemName: fmt.Sprintf("Loc/Wec/Plant%d/%s/SessionRequest", PlantNo, CtrlOrReset),
@@ -380,7 +635,10 @@ func requestSession(ctx context.Context, Server gopcxmlda.Server, SR SessionRequ
 		"ReturnItemName":  true,
 		"ReturnItemPath":  true,
 	}
-	_, err := Server.Write(ctx, items, &ClientRequestHandle, &ClientItemHandles, "", options)
+	value, err := opcWrite(ctx, Server, items, &ClientRequestHandle, &ClientItemHandles, "", options)
+	if err == nil {
+		err = writeError(value)
+	}
 	if err != nil {
 		return err
 	} else {
@@ -392,6 +650,8 @@ func getPublicKey(ctx context.Context, Server gopcxmlda.Server, PlantNo uint8, C
 	if CtrlOrReset != "Ctrl" && CtrlOrReset != "Reset" {
 		return 0, fmt.Errorf("CtrlOrReset must be either Ctrl or Reset")
 	}
+	ctx, span := startSpan(ctx, "SessionPubKey", plantAttr(PlantNo), attribute.String("energontrol.session.type", CtrlOrReset))
+	defer span.End()
 	var handle1 string
 	var handle2 []string
 	options := map[string]interface{}{
@@ -402,13 +662,17 @@ func getPublicKey(ctx context.Context, Server gopcxmlda.Server, PlantNo uint8, C
 			ItemName: fmt.Sprintf("Loc/Wec/Plant%d/%s/SessionPubKey", PlantNo, CtrlOrReset),
 		},
 	}
-	value, err := Server.Read(ctx, items, &handle1, &handle2, "", options)
+	value, err := opcRead(ctx, Server, items, &handle1, &handle2, "", options)
+	if err != nil {
+		return 0, err
+	}
+	key, err := itemValues(value, 1, toUint64)
 	if err != nil {
 		return 0, err
-	} else if value.Response.ItemList.Items[0].Value.Value.(uint64) == 0 {
+	} else if key[0] == 0 {
 		return 0, fmt.Errorf("public key is 0")
 	} else {
-		return value.Response.ItemList.Items[0].Value.Value.(uint64), nil
+		return key[0], nil
 	}
 }
 
@@ -416,6 +680,8 @@ func writeControlValue(ctx context.Context, Server gopcxmlda.Server, PlantNo uin
 	if CtrlOrRbh != "Ctrl" && CtrlOrRbh != "Rbh" {
 		return fmt.Errorf("CtrlOrRbh must be either Ctrl or Rbh")
 	}
+	ctx, span := startSpan(ctx, "Set"+CtrlOrRbh, plantAttr(PlantNo), attribute.Int64("energontrol.value", int64(CtrlValue)))
+	defer span.End()
 	items := []gopcxmlda.TItem{
 		{
 			ItemName: fmt.Sprintf("Loc/Wec/Plant%d/Ctrl/Set%s", PlantNo, CtrlOrRbh),
@@ -431,7 +697,10 @@ func writeControlValue(ctx context.Context, Server gopcxmlda.Server, PlantNo uin
 		"ReturnItemName":  true,
 		"ReturnItemPath":  true,
 	}
-	_, err := Server.Write(ctx, items, &ClientRequestHandle, &ClientItemHandles, "", options)
+	value, err := opcWrite(ctx, Server, items, &ClientRequestHandle, &ClientItemHandles, "", options)
+	if err == nil {
+		err = writeError(value)
+	}
 	if err != nil {
 		return err
 	} else {
@@ -443,6 +712,8 @@ func submitValue(ctx context.Context, Server gopcxmlda.Server, PlantNo uint8, Pr
 	if CtrlOrReset != "Ctrl" && CtrlOrReset != "Reset" {
 		return fmt.Errorf("CtrlOrReset must be either Ctrl or Reset")
 	}
+	ctx, span := startSpan(ctx, "SessionSubmit", plantAttr(PlantNo), attribute.String("energontrol.session.type", CtrlOrReset))
+	defer span.End()
 	items := []gopcxmlda.TItem{
 		{
 			ItemName: fmt.Sprintf("Loc/Wec/Plant%d/%s/SessionSubmit", PlantNo, CtrlOrReset),
@@ -458,7 +729,10 @@ func submitValue(ctx context.Context, Server gopcxmlda.Server, PlantNo uint8, Pr
 		"ReturnItemName":  true,
 		"ReturnItemPath":  true,
 	}
-	_, err := Server.Write(ctx, items, &ClientRequestHandle, &ClientItemHandles, "", options)
+	value, err := opcWrite(ctx, Server, items, &ClientRequestHandle, &ClientItemHandles, "", options)
+	if err == nil {
+		err = writeError(value)
+	}
 	if err != nil {
 		return err
 	} else {
@@ -467,6 +741,8 @@ func submitValue(ctx context.Context, Server gopcxmlda.Server, PlantNo uint8, Pr
 }
 
 func writeResetValue(ctx context.Context, Server gopcxmlda.Server, PlantNo uint8, PrivateKey uint16, PublicKey uint64) error {
+	ctx, span := startSpan(ctx, "SetReset", plantAttr(PlantNo))
+	defer span.End()
 	items := []gopcxmlda.TItem{
 		{
 			ItemName: fmt.Sprintf("Loc/Wec/Plant%d/Reset/SetReset", PlantNo),
@@ -482,7 +758,10 @@ func writeResetValue(ctx context.Context, Server gopcxmlda.Server, PlantNo uint8
 		"ReturnItemName":  true,
 		"ReturnItemPath":  true,
 	}
-	_, err := Server.Write(ctx, items, &ClientRequestHandle, &ClientItemHandles, "", options)
+	value, err := opcWrite(ctx, Server, items, &ClientRequestHandle, &ClientItemHandles, "", options)
+	if err == nil {
+		err = writeError(value)
+	}
 	if err != nil {
 		return err
 	} else {
@@ -499,7 +778,7 @@ func resetProcedure(ctx context.Context, Server gopcxmlda.Server, UserId uint64,
 		return nil, nil
 	}
 	// Get session state
-	SesState, err := sessionState(ctx, Server, SessionType, WaitForState{}, PlantNo...)
+	SesState, SesErr, err := sessionState(ctx, Server, SessionType, WaitForState{}, PlantNo...)
 	if err != nil {
 		for range PlantNo {
 			errList = append(errList, err)
@@ -507,104 +786,109 @@ func resetProcedure(ctx context.Context, Server gopcxmlda.Server, UserId uint64,
 		return nil, errList
 	}
 	for i, _sessionState := range SesState {
-		if _sessionState != 0 {
-			errMsg := fmt.Sprintf("Can't start session, %s", getSessionStateText(_sessionState))
-			LogWarn(PlantNo[i], Action, errMsg)
-			errList = append(errList, fmt.Errorf(errMsg))
-			success = append(success, false)
-			continue
-		}
-		// do session request
-		SessionRequestValues := generateSessionRequest(UserId)
-		err := requestSession(ctx, Server, SessionRequestValues, PlantNo[i], SessionType)
-		if err != nil {
-			errList = append(errList, err)
-			success = append(success, false)
-			continue
-		}
-		// Get new Session State
-		WaitFor := WaitForState{
-			Desired: 1,
-			Sleep:   100 * time.Millisecond,
-			Retries: 10,
-		}
-
-		SesState, err = sessionState(ctx, Server, SessionType, WaitFor, PlantNo[i])
-		if err != nil {
-			errList = append(errList, err)
-			success = append(success, false)
-			continue
-		}
-		if SesState[0] != 1 {
-			errMsg := fmt.Sprintf("Session error for Plant %d, %s", PlantNo[i], getSessionStateText(SesState[0]))
-			LogWarn(PlantNo[i], Action, errMsg)
-			errList = append(errList, fmt.Errorf(errMsg))
+		if SesErr[i] != nil {
+			errList = append(errList, SesErr[i])
 			success = append(success, false)
 			continue
 		}
-		PublicKey, err := getPublicKey(ctx, Server, PlantNo[i], SessionType)
-		if err != nil {
-			errList = append(errList, err)
-			success = append(success, false)
-			continue
-		}
-		err = writeResetValue(ctx, Server, PlantNo[i], SessionRequestValues.PrivateKey, PublicKey)
-		if err != nil {
-			errList = append(errList, err)
-			success = append(success, false)
-			continue
-		}
-		// Get new Session State
-		WaitFor = WaitForState{
-			Desired: 2,
-			Sleep:   100 * time.Millisecond,
-			Retries: 10,
-		}
-		SesState, err = sessionState(ctx, Server, SessionType, WaitFor, PlantNo[i])
-		if err != nil {
-			errList = append(errList, err)
-			success = append(success, false)
-			continue
-		}
-		if SesState[0] != 2 {
-			errMsg := fmt.Sprintf("Session error for Plant %d, %s", PlantNo[i], getSessionStateText(SesState[0]))
-			LogWarn(PlantNo[i], Action, errMsg)
-			errList = append(errList, fmt.Errorf(errMsg))
-			success = append(success, false)
-			continue
-		}
-		err = submitValue(ctx, Server, PlantNo[i], SessionRequestValues.PrivateKey, PublicKey, SessionType)
-		if err != nil {
-			errList = append(errList, err)
-			success = append(success, false)
-			continue
-		}
-		// Get new Session State
-		WaitFor = WaitForState{
-			Desired: 4,
-			Sleep:   100 * time.Millisecond,
-			Retries: 10,
-		}
-		SesState, err = sessionState(ctx, Server, SessionType, WaitFor, PlantNo[i])
-		if err != nil {
-			errList = append(errList, err)
-			success = append(success, false)
-			continue
-		}
-		if SesState[0] != 4 {
-			errMsg := fmt.Sprintf("Session error for Plant %d, %s", PlantNo[i], getSessionStateText(SesState[0]))
-			LogWarn(PlantNo[i], Action, errMsg)
-			errList = append(errList, fmt.Errorf(errMsg))
+		if _sessionState != 0 {
+			errMsg := fmt.Sprintf("Can't start session, %s", getSessionStateText(_sessionState))
+			logPlant(ctx, slog.LevelWarn, PlantNo[i], Action, errMsg)
+			errList = append(errList, newSessionError(ctx, PlantNo[i], _sessionState, errMsg))
 			success = append(success, false)
 			continue
-		} else {
-			errList = append(errList, nil)
-			success = append(success, true)
 		}
+		err = resetSession(ctx, Server, UserId, PlantNo[i])
+		errList = append(errList, err)
+		success = append(success, err == nil)
 	}
 	return success, errList
 }
 
+// resetSession Reset a plant, whose session is free. Its SessionId is released, as soon as the session ended.
+func resetSession(ctx context.Context, Server gopcxmlda.Server, UserId uint64, PlantNo uint8) (err error) {
+	ctx, span := startSpan(ctx, "Session Reset", plantAttr(PlantNo), attribute.String("energontrol.session.type", "Reset"))
+	defer func() { endSpan(span, err) }()
+	SessionType := "Reset"
+	Action := "Reset"
+	Key := newSessionKey(Server, PlantNo, SessionType)
+	SessionRequestValues, err := generateSessionRequest(ctx, Key, UserId)
+	if err != nil {
+		return err
+	}
+	defer releaseSessionId(Key, SessionRequestValues)
+	// a write, which failed with an unknown outcome, is checked with the next session state instead of being repeated
+	var uncertain error
+	err = requestSession(ctx, Server, SessionRequestValues, PlantNo, SessionType)
+	if writeUncertain(err) {
+		uncertain = err
+		logPlant(ctx, slog.LevelWarn, PlantNo, Action, fmt.Sprintf("SessionRequest failed, checking the session state: %s", err))
+	} else if err != nil {
+		return err
+	}
+	// Get new Session State
+	WaitFor := sessionWait(ctx, 1)
+	SesState, SesErr, err := sessionState(ctx, Server, SessionType, WaitFor, PlantNo)
+	if err == nil {
+		err = SesErr[0]
+	}
+	if err != nil {
+		return err
+	}
+	span.SetAttributes(sessionStateAttr(SesState[0]))
+	if SesState[0] != 1 {
+		return sessionStepError(ctx, PlantNo, Action, SesState[0], uncertain)
+	}
+	confirmWrite(ctx, PlantNo, Action, &uncertain)
+	PublicKey, err := getPublicKey(ctx, Server, PlantNo, SessionType)
+	if err != nil {
+		return err
+	}
+	err = writeResetValue(ctx, Server, PlantNo, SessionRequestValues.PrivateKey, PublicKey)
+	if writeUncertain(err) {
+		uncertain = err
+		logPlant(ctx, slog.LevelWarn, PlantNo, Action, fmt.Sprintf("SetReset failed, checking the session state: %s", err))
+	} else if err != nil {
+		return err
+	}
+	// Get new Session State
+	WaitFor = sessionWait(ctx, 2)
+	SesState, SesErr, err = sessionState(ctx, Server, SessionType, WaitFor, PlantNo)
+	if err == nil {
+		err = SesErr[0]
+	}
+	if err != nil {
+		return err
+	}
+	span.SetAttributes(sessionStateAttr(SesState[0]))
+	if SesState[0] != 2 {
+		return sessionStepError(ctx, PlantNo, Action, SesState[0], uncertain)
+	}
+	confirmWrite(ctx, PlantNo, Action, &uncertain)
+	err = submitValue(ctx, Server, PlantNo, SessionRequestValues.PrivateKey, PublicKey, SessionType)
+	if writeUncertain(err) {
+		uncertain = err
+		logPlant(ctx, slog.LevelWarn, PlantNo, Action, fmt.Sprintf("SessionSubmit failed, checking the session state: %s", err))
+	} else if err != nil {
+		return err
+	}
+	// Get new Session State
+	WaitFor = sessionWait(ctx, 4)
+	SesState, SesErr, err = sessionState(ctx, Server, SessionType, WaitFor, PlantNo)
+	if err == nil {
+		err = SesErr[0]
+	}
+	if err != nil {
+		return err
+	}
+	span.SetAttributes(sessionStateAttr(SesState[0]))
+	if SesState[0] != 4 {
+		return sessionStepError(ctx, PlantNo, Action, SesState[0], uncertain)
+	}
+	confirmWrite(ctx, PlantNo, Action, &uncertain)
+	return nil
+}
+
 // allFalse checks if all values in a slice are false
 func allFalse(b []bool) bool {
 	for _, value := range b {
@@ -617,19 +901,29 @@ func allFalse(b []bool) bool {
 
 func filterPlants(b gopcxmlda.TBrowse) []uint8 {
 	var plants []uint8
-	re := regexp.MustCompile(`^Loc/Wec/Plant(\d+)$`)
 	for _, item := range b.Response.Elements {
-		if matches := re.FindStringSubmatch(item.ItemName); matches != nil {
-			if num, err := strconv.Atoi(matches[1]); err == nil {
-				if num >= 0 && num <= 255 {
-					plants = append(plants, uint8(num))
-				}
-			}
+		if plant, ok := plantOfItem(item.ItemName); ok {
+			plants = append(plants, plant)
 		}
 	}
 	return plants
 }
 
+var plantItemRe = regexp.MustCompile(`^Loc/Wec/Plant(\d+)$`)
+
+// plantOfItem Get the PlantNo of a plant's branch, e.g. 2 for "Loc/Wec/Plant2"
+func plantOfItem(ItemName string) (uint8, bool) {
+	matches := plantItemRe.FindStringSubmatch(ItemName)
+	if matches == nil {
+		return 0, false
+	}
+	num, err := strconv.Atoi(matches[1])
+	if err != nil || num < 0 || num > 255 {
+		return 0, false
+	}
+	return uint8(num), true
+}
+
 func getPlantInfo(ctx context.Context, Server gopcxmlda.Server, T *TurbineInfo) error {
 	if T.Ctrl == nil {
 		T.Ctrl = make(map[uint8]bool)
@@ -648,7 +942,7 @@ func getPlantInfo(ctx context.Context, Server gopcxmlda.Server, T *TurbineInfo)
 	}
 	var ClientRequestHandle string
 	var ClientItemHandles []string
-	_parkNo, err := Server.Read(ctx, []gopcxmlda.TItem{
+	_parkNo, err := opcRead(ctx, Server, []gopcxmlda.TItem{
 		{
 			ItemName: "Loc/LocNo",
 		},
@@ -661,68 +955,134 @@ func getPlantInfo(ctx context.Context, Server gopcxmlda.Server, T *TurbineInfo)
 	if len(_parkNo.Response.ItemList.Items) == 0 {
 		return fmt.Errorf("ParkNo not found")
 	}
-	T.ParkNo = _parkNo.Response.ItemList.Items[0].Value.Value.(uint64)
-	for _, plant := range T.PlantNo {
-		optionsBranch := gopcxmlda.TBrowseOptions{
-			BrowseFilter: "branch",
-		}
-		b, err := Server.Browse(ctx, fmt.Sprintf("Loc/Wec/Plant%d", plant), &ClientRequestHandle, "", optionsBranch)
-		if err != nil {
-			return err
-		}
-		for _, item := range b.Response.Elements {
-			if item.Name == "Ctrl" && item.HasChildren {
-				b2, err := Server.Browse(ctx, fmt.Sprintf("Loc/Wec/Plant%d/Ctrl", plant), &ClientRequestHandle, "", gopcxmlda.TBrowseOptions{
-					ElementNameFilter: "Set*",
-				})
-				if err != nil {
-					return err
-				}
-				for _, item2 := range b2.Response.Elements {
-					if item2.Name == "SetCtrl" {
-						T.Ctrl[plant] = true
-					}
-					if item2.Name == "SetRbh" {
-						T.Rbh[plant] = true
-					}
-					if item2.Name == "SetIceDet" {
-						T.IceDet[plant] = true
-					}
-				}
+	ParkNo, err := itemValues(_parkNo, 1, toUint64)
+	if err != nil {
+		return fmt.Errorf("ParkNo: %w", err)
+	}
+	T.ParkNo = ParkNo[0]
+	caps, err := browsePlants(ctx, serverBrowse(Server), T.PlantNo)
+	if err != nil {
+		return err
+	}
+	for i, plant := range T.PlantNo {
+		T.Ctrl[plant] = caps[i].Ctrl
+		T.Rbh[plant] = caps[i].Rbh
+		T.Reset[plant] = caps[i].Reset
+		T.IceDet[plant] = caps[i].IceDet
+		T.Para[plant] = caps[i].Para
+	}
+	return nil
+}
+
+// plantCapabilities the control items of a plant
+type plantCapabilities struct {
+	Ctrl, Rbh, Reset, Para, IceDet bool
+}
+
+// browseFunc Browse the children of ItemName with the filters of options
+type browseFunc func(ctx context.Context, ItemName string, options gopcxmlda.TBrowseOptions) ([]gopcxmlda.TBrowseElement, error)
+
+// serverBrowse Get the browseFunc, which browses Server
+func serverBrowse(Server gopcxmlda.Server) browseFunc {
+	return func(ctx context.Context, ItemName string, options gopcxmlda.TBrowseOptions) ([]gopcxmlda.TBrowseElement, error) {
+		var ClientRequestHandle string
+		b, err := opcBrowse(ctx, Server, ItemName, &ClientRequestHandle, "", options)
+		return b.Response.Elements, err
+	}
+}
+
+// browsePlants Browse the plants for their control items, discoveryConcurrency(ctx) plants at a time
+func browsePlants(ctx context.Context, browse browseFunc, PlantNo []uint8) ([]plantCapabilities, error) {
+	caps := make([]plantCapabilities, len(PlantNo))
+	errList := make([]error, len(PlantNo))
+	limit := make(chan struct{}, discoveryConcurrency(ctx))
+	var wg sync.WaitGroup
+	for i, plant := range PlantNo {
+		wg.Add(1)
+		limit <- struct{}{}
+		go func() {
+			defer wg.Done()
+			caps[i], errList[i] = browsePlant(ctx, browse, plant)
+			<-limit
+		}()
+	}
+	wg.Wait()
+	return caps, errors.Join(errList...)
+}
+
+// browsePlant Browse the Ctrl and Reset branch of a plant for SetCtrl, SetRbh, SetIceDet and SetReset
+func browsePlant(ctx context.Context, browse browseFunc, plant uint8) (plantCapabilities, error) {
+	var caps plantCapabilities
+	elements, err := browse(ctx, fmt.Sprintf("Loc/Wec/Plant%d", plant), gopcxmlda.TBrowseOptions{
+		BrowseFilter: "branch",
+	})
+	if err != nil {
+		return caps, err
+	}
+	for _, item := range elements {
+		if item.Name == "Ctrl" && item.HasChildren {
+			elements2, err := browse(ctx, fmt.Sprintf("Loc/Wec/Plant%d/Ctrl", plant), gopcxmlda.TBrowseOptions{
+				ElementNameFilter: "Set*",
+			})
+			if err != nil {
+				return caps, err
 			}
-			if item.Name == "Reset" && item.HasChildren {
-				b2, err := Server.Browse(ctx, fmt.Sprintf("Loc/Wec/Plant%d/Reset", plant), &ClientRequestHandle, "", gopcxmlda.TBrowseOptions{
-					ElementNameFilter: "SetReset",
-				})
-				if err != nil {
-					return err
+			for _, item2 := range elements2 {
+				if item2.Name == "SetCtrl" {
+					caps.Ctrl = true
 				}
-				for _, item2 := range b2.Response.Elements {
-					if item2.Name == "SetReset" {
-						T.Reset[plant] = true
-					}
+				if item2.Name == "SetRbh" {
+					caps.Rbh = true
+				}
+				if item2.Name == "SetIceDet" {
+					caps.IceDet = true
 				}
 			}
-			if item.Name == "Para" && item.HasChildren {
-				T.Para[plant] = true
-			}
-		}
-		// set false values for plants that have no Ctrl/Rbh/Reset/IceDet/...
-		if _, ok := T.Ctrl[plant]; !ok {
-			T.Ctrl[plant] = false
 		}
-		if _, ok := T.Rbh[plant]; !ok {
-			T.Rbh[plant] = false
-		}
-		if _, ok := T.Reset[plant]; !ok {
-			T.Reset[plant] = false
+		if item.Name == "Reset" && item.HasChildren {
+			elements2, err := browse(ctx, fmt.Sprintf("Loc/Wec/Plant%d/Reset", plant), gopcxmlda.TBrowseOptions{
+				ElementNameFilter: "SetReset",
+			})
+			if err != nil {
+				return caps, err
+			}
+			for _, item2 := range elements2 {
+				if item2.Name == "SetReset" {
+					caps.Reset = true
+				}
+			}
 		}
-		if _, ok := T.IceDet[plant]; !ok {
-			T.IceDet[plant] = false
+		if item.Name == "Para" && item.HasChildren {
+			caps.Para = true
 		}
-		if _, ok := T.Para[plant]; !ok {
-			T.Para[plant] = false
+	}
+	return caps, nil
+}
+
+// RbhMatches Check if the actual Rbh state fulfills the desired Rbh value (0 Standard, 2 AutoOff, 10 ManualOn)
+func RbhMatches(actual uint64, desired uint64) bool {
+	return rbhStatusRight(actual, desired)
+}
+
+// CtrlStateText Get the name of a Ctrl state as used in CtrlValues, e.g. "Stop60"
+func CtrlStateText(state uint64) string {
+	for name, value := range CtrlValues {
+		if value == state {
+			return name
 		}
 	}
-	return nil
+	return fmt.Sprintf("Unknown (%d)", state)
+}
+
+// RbhStateText Get the descriptions of all Rbh status bits set in state, sorted by bit
+func RbhStateText(state uint64) []string {
+	return getRbhStateText(state)
+}
+
+// SessionStateText Get the name of a session state, e.g. "Occupied" for 108
+func SessionStateText(state uint16) string {
+	if stateText, exists := sessionStates[state]; exists {
+		return stateText
+	}
+	return "Unknown"
 }
@@ -1,14 +1,24 @@
 package energontrol
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/dernate/gopcxmlda"
 	"github.com/joho/godotenv"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"io"
+	"log/slog"
 	"net/url"
 	"os"
 	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -43,25 +53,25 @@ func TestSetAction(t *testing.T) {
 		{PlantNo: 4, CtrlState: 1},
 		{PlantNo: 5, CtrlState: 2},
 	}
-	setActionToStop(&plantState, true, CtrlValues["Stop60"])
+	setActionToStop(context.Background(), &plantState, true, CtrlValues["Stop60"])
 	plantState2 := []PlantState{
 		{PlantNo: 2, CtrlState: 0},
 		{PlantNo: 4, CtrlState: 1},
 		{PlantNo: 5, CtrlState: 2},
 	}
-	setActionToStop(&plantState2, true, CtrlValues["Stop"])
+	setActionToStop(context.Background(), &plantState2, true, CtrlValues["Stop"])
 	plantState3 := []PlantState{
 		{PlantNo: 2, CtrlState: 0},
 		{PlantNo: 4, CtrlState: 1},
 		{PlantNo: 5, CtrlState: 2},
 	}
-	setActionToStop(&plantState3, false, CtrlValues["Stop60"])
+	setActionToStop(context.Background(), &plantState3, false, CtrlValues["Stop60"])
 	plantState4 := []PlantState{
 		{PlantNo: 2, CtrlState: 0},
 		{PlantNo: 4, CtrlState: 1},
 		{PlantNo: 5, CtrlState: 1},
 	}
-	setActionToStart(&plantState4)
+	setActionToStart(context.Background(), &plantState4)
 	if !(plantState[0].Action == true && plantState[1].Action == false && plantState[2].Action == true) {
 		t.Errorf("Error at Stop ForceExplicitCommand: %t, Action: %s", true, "Stop60")
 	}
@@ -253,7 +263,10 @@ func TestSessionState(t *testing.T) {
 	}
 	PlantNo := []uint8{1, 3, 4}
 	WaitFor := WaitForState{}
-	s, err := sessionState(context.Background(), Server, "Ctrl", WaitFor, PlantNo...)
+	s, itemErrs, err := sessionState(context.Background(), Server, "Ctrl", WaitFor, PlantNo...)
+	if err == nil {
+		err = errors.Join(itemErrs...)
+	}
 	if err != nil {
 		t.Errorf("Error: %s", err)
 	} else {
@@ -683,3 +696,307 @@ func TestParkNoMatch(t *testing.T) {
 		t.Error("Test failed, ParkNo does not match")
 	}
 }
+
+func TestParseFleetConfig(t *testing.T) {
+	data := []byte(`
+parks:
+  - name: north
+    url: http://10.0.0.1:8080/DA
+    park_no: 1234
+    plants: [2, 4, 5]
+    aliases:
+      WEA-A: 2
+      WEA-B: 4
+      North-2: 2
+    user_id:
+      env: ENERGONTROL_TEST_USERID
+    session:
+      sleep: 200ms
+      retries: 5
+`)
+	F, err := ParseFleetConfig(data)
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	P, err := F.Park("north")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if P.LocaleID != "en-us" || P.Timeout != 10*time.Second {
+		t.Errorf("Error: defaults not set, %s ; %s", P.LocaleID, P.Timeout)
+	}
+	Server, err := P.Server()
+	if err != nil || Server.Url.Host != "10.0.0.1:8080" {
+		t.Errorf("Error: %v ; %v", err, Server.Url)
+	}
+	PlantNo, err := P.ResolvePlants("WEA-B", "5")
+	if err != nil || len(PlantNo) != 2 || PlantNo[0] != 4 || PlantNo[1] != 5 {
+		t.Errorf("Error: %v ; %v", err, PlantNo)
+	}
+	// a plant with several aliases always gets the first in sort order
+	for range 10 {
+		if name := P.PlantName(2); name != "North-2" {
+			t.Fatalf("Error: %s", name)
+		}
+	}
+	if name := P.PlantName(5); name != "Plant5" {
+		t.Errorf("Error: %s", name)
+	}
+	if _, err = P.ResolvePlants("7"); err == nil {
+		t.Errorf("Error: plant 7 is not in the allowlist")
+	}
+	t.Setenv("ENERGONTROL_TEST_USERID", "4711")
+	if UserId, err := P.ResolveUserId(); err != nil || UserId != 4711 {
+		t.Errorf("Error: %v ; %d", err, UserId)
+	}
+	WaitFor := sessionWait(P.Context(context.Background()), 2)
+	if WaitFor.Sleep != 200*time.Millisecond || WaitFor.Retries != 5 || WaitFor.Desired != 2 {
+		t.Errorf("Error: %v", WaitFor)
+	}
+
+	invalid := []byte(`
+parks:
+  - name: south
+    url: 10.0.0.2
+    plants: [1]
+    aliases:
+      WEA-C: 3
+`)
+	if _, err = ParseFleetConfig(invalid); err == nil {
+		t.Errorf("Error: invalid config accepted")
+	} else {
+		t.Log(err)
+	}
+}
+
+func TestTracing(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+	ctx := WithTracerProvider(context.Background(), tp)
+	_url, _ := url.Parse("http://127.0.0.1:1/DA")
+	Server := gopcxmlda.Server{
+		Url:      _url,
+		LocaleID: "en-us",
+		Timeout:  time.Second,
+	}
+	Stop(ctx, Server, 1234, true, false, 2, 4)
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, s := range sr.Ended() {
+		spans[s.Name()] = s
+	}
+	root, ok := spans["Stop90"]
+	if !ok {
+		t.Fatalf("Error: no Stop90 span in %v", spans)
+	}
+	if root.Status().Code != codes.Error {
+		t.Errorf("Error: Stop90 span status %v", root.Status())
+	}
+	var plants []int64
+	for _, a := range root.Attributes() {
+		if a.Key == "energontrol.plants" {
+			plants = a.Value.AsInt64Slice()
+		}
+	}
+	if len(plants) != 2 || plants[0] != 2 || plants[1] != 4 {
+		t.Errorf("Error: plants attribute %v", plants)
+	}
+	available, ok := spans["ServerAvailable"]
+	if !ok || available.Parent().SpanID() != root.SpanContext().SpanID() {
+		t.Errorf("Error: ServerAvailable is no child of Stop90")
+	}
+	if opc, ok := spans["OPC GetStatus"]; !ok || opc.Parent().SpanID() != available.SpanContext().SpanID() {
+		t.Errorf("Error: OPC GetStatus is no child of ServerAvailable")
+	}
+}
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	ctx := WithLogger(context.Background(), slog.New(slog.NewJSONHandler(&buf, nil)))
+	ctx = ParkConfig{Name: "north", ParkNo: 4711}.Context(ctx)
+	observeCommand(ctx, "Start", 12345, []uint8{3}, func(ctx context.Context) ([]bool, []error) {
+		logIfStateChangePermitted(ctx, PlantState{PlantNo: 3, CtrlState: 255}, 3, 0)
+		return []bool{false}, []error{nil}
+	})
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("Error: %v ; %q", err, buf.String())
+	}
+	want := map[string]any{"level": "WARN", "Park": "north", "ParkNo": 4711.0, "UserId": 12345.0, "PlantNo": 3.0, "Action": "Start"}
+	for k, v := range want {
+		if record[k] != v {
+			t.Errorf("Error: %s = %v, want %v", k, record[k], v)
+		}
+	}
+}
+
+func TestItemValues(t *testing.T) {
+	var value gopcxmlda.TRead
+	value.Response.ItemList.Items = []gopcxmlda.TItem{
+		{ItemName: "Loc/Wec/Plant1/Ctrl/Ctrl", Value: gopcxmlda.TValue{Value: int32(2)}},
+		{ItemName: "Loc/Wec/Plant2/Ctrl/Ctrl", Value: gopcxmlda.TValue{Value: uint8(130)}},
+	}
+	states, err := itemValues(value, 2, toUint64)
+	if err != nil || states[0] != 2 || states[1] != 130 {
+		t.Errorf("Error: %v, %v", states, err)
+	}
+	if _, err = itemValues(value, 3, toUint64); err == nil {
+		t.Errorf("Error: missing item accepted")
+	}
+	value.Response.ItemList.Items[0].Value.Value = int32(-1)
+	if _, err = itemValues(value, 2, toUint16); err == nil {
+		t.Errorf("Error: negative session state accepted")
+	}
+	value.Response.ItemList.Items[0].Value.Value = uint32(70000)
+	if _, err = itemValues(value, 2, toUint16); err == nil {
+		t.Errorf("Error: overflow accepted")
+	}
+	value.Response.ItemList.Items[1] = gopcxmlda.TItem{ItemName: "Loc/Wec/Plant2/Ctrl/Ctrl", ResultID: "E_UNKNOWNITEMNAME"}
+	value.Response.Errors = []gopcxmlda.TOPCError{{ID: "E_UNKNOWNITEMNAME", Text: "The item name is no longer available"}}
+	value.Response.ItemList.Items[0].Value.Value = uint64(0)
+	_, err = itemValues(value, 2, toUint64)
+	var itemErr *ItemError
+	if !errors.As(err, &itemErr) || itemErr.ItemName != "Loc/Wec/Plant2/Ctrl/Ctrl" || itemErr.Text == "" {
+		t.Errorf("Error: %v", err)
+	}
+	value.Response.ItemList.Items[1].ResultID = "s:S_CLAMP"
+	value.Response.ItemList.Items[1].Value.Value = uint64(1)
+	if _, err = itemValues(value, 2, toUint64); err != nil {
+		t.Errorf("Error: success code failed: %v", err)
+	}
+}
+
+func TestItemsByName(t *testing.T) {
+	var value gopcxmlda.TRead
+	// plant 2 has no Ctrl branch, the server left it out and sorted the others differently
+	value.Response.ItemList.Items = []gopcxmlda.TItem{
+		{ItemName: "Loc/Wec/Plant3/Ctrl/Ctrl", Value: gopcxmlda.TValue{Value: uint64(1)}},
+		{ItemName: "Loc/Wec/Plant4/Ctrl/Ctrl", ResultID: "E_UNKNOWNITEMNAME"},
+		{ItemName: "Loc/Wec/Plant1/Ctrl/Ctrl", Value: gopcxmlda.TValue{Value: uint64(0)}},
+	}
+	names := []string{"Loc/Wec/Plant1/Ctrl/Ctrl", "Loc/Wec/Plant2/Ctrl/Ctrl", "Loc/Wec/Plant3/Ctrl/Ctrl", "Loc/Wec/Plant4/Ctrl/Ctrl"}
+	states, errs := itemsByName(value, names, toUint64)
+	if errs[0] != nil || states[0] != 0 || errs[2] != nil || states[2] != 1 {
+		t.Errorf("Error: %v, %v", states, errs)
+	}
+	var itemErr *ItemError
+	if errs[1] == nil || !errors.As(errs[3], &itemErr) || itemErr.ItemName != names[3] {
+		t.Errorf("Error: %v", errs)
+	}
+
+	// without returned names the items are mapped by position
+	for i := range value.Response.ItemList.Items {
+		value.Response.ItemList.Items[i].ItemName = ""
+		value.Response.ItemList.Items[i].ResultID = ""
+	}
+	value.Response.ItemList.Items[1].Value.Value = uint64(2)
+	if states, errs = itemsByName(value, names[:3], toUint64); errors.Join(errs...) != nil || states[0] != 1 || states[1] != 2 || states[2] != 0 {
+		t.Errorf("Error: %v, %v", states, errs)
+	}
+}
+
+func TestGenerateSessionRequest(t *testing.T) {
+	// a deterministic source gives the same requests
+	seed := bytes.Repeat([]byte{7, 0x12, 0x34}, 2)
+	ctx := WithSessionRandom(context.Background(), bytes.NewReader(seed))
+	key := sessionKey{Server: "http://scada:8080", PlantNo: 1, Session: "Ctrl"}
+	SR, err := generateSessionRequest(ctx, key, 1234)
+	if err != nil || SR.SessionId != 7 || SR.PrivateKey != 0x1234 || SR.UserId != 1234 {
+		t.Fatalf("Error: %+v, %v", SR, err)
+	}
+	// the same SessionId isn't given to a second open session
+	SR2, err := generateSessionRequest(ctx, key, 1234)
+	if err != nil || SR2.SessionId != 8 || SR2.PrivateKey != SR.PrivateKey {
+		t.Errorf("Error: %+v, %v", SR2, err)
+	}
+	releaseSessionId(key, SR)
+	releaseSessionId(key, SR2)
+	if _, err = generateSessionRequest(ctx, key, 1234); err == nil {
+		t.Errorf("Error: exhausted random source accepted")
+	}
+
+	// random numbers, which would bias the modulo, are skipped
+	ctx = WithSessionRandom(context.Background(), bytes.NewReader([]byte{245, 0, 1, 27, 0xff, 0xff, 27, 0, 2}))
+	if SR, err = generateSessionRequest(ctx, key, 1234); err != nil || SR.SessionId != 7 || SR.PrivateKey != 2 {
+		t.Errorf("Error: %+v, %v", SR, err)
+	}
+	releaseSessionId(key, SR)
+
+	// concurrent sessions get distinct SessionIds from crypto/rand, all of 0 to 19
+	requests := make([]SessionRequest, 20)
+	var wg sync.WaitGroup
+	for i := range requests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			SR, err := generateSessionRequest(context.Background(), key, 1234)
+			if err != nil {
+				t.Error(err)
+			}
+			requests[i] = SR
+		}()
+	}
+	wg.Wait()
+	seen := make(map[uint8]bool)
+	for _, SR := range requests {
+		if seen[SR.SessionId] || SR.SessionId >= 20 || SR.PrivateKey >= 32000 {
+			t.Errorf("Error: %+v", SR)
+		}
+		seen[SR.SessionId] = true
+	}
+	if _, err = generateSessionRequest(context.Background(), key, 1234); err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Errorf("Error: 21st open session got a SessionId, %v", err)
+	}
+	// the other plants, session types and servers still have all SessionIds
+	for _, other := range []sessionKey{
+		{Server: key.Server, PlantNo: 2, Session: "Ctrl"},
+		{Server: key.Server, PlantNo: 1, Session: "Reset"},
+		{Server: "http://scada2:8080", PlantNo: 1, Session: "Ctrl"},
+	} {
+		SR, err := generateSessionRequest(context.Background(), other, 1234)
+		if err != nil {
+			t.Errorf("Error: %+v, %v", other, err)
+			continue
+		}
+		releaseSessionId(other, SR)
+	}
+	for _, SR := range requests {
+		releaseSessionId(key, SR)
+	}
+	sessionIds.Lock()
+	defer sessionIds.Unlock()
+	if len(sessionIds.used) != 0 {
+		t.Errorf("Error: released SessionIds kept: %v", sessionIds.used)
+	}
+}
+
+// sessionErrorCounter Observer counting the session errors per plant
+type sessionErrorCounter struct {
+	mu     sync.Mutex
+	errors map[uint8]int
+}
+
+func (c *sessionErrorCounter) OpcRequest(string, time.Duration, error)           {}
+func (c *sessionErrorCounter) ServerAvailable(bool, error)                       {}
+func (c *sessionErrorCounter) Command(string, uint8, bool, error, time.Duration) {}
+func (c *sessionErrorCounter) SessionPhase(string, time.Duration)                {}
+func (c *sessionErrorCounter) PlantState(uint8, string, uint64)                  {}
+func (c *sessionErrorCounter) SessionError(PlantNo uint8, State uint16) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.errors[PlantNo]++
+}
+
+func TestSessionErrorObserved(t *testing.T) {
+	c := &sessionErrorCounter{errors: make(map[uint8]int)}
+	ctx := WithObserver(context.Background(), c)
+	// plant 1 is occupied before the session request, plant 2 fails after an uncertain write
+	err1 := newSessionError(ctx, 1, 108, "Can't start session")
+	err2 := sessionStepError(ctx, 2, "Start", 0, io.ErrUnexpectedEOF)
+	var se *SessionError
+	if err1.State != 108 || !errors.As(err2, &se) || se.PlantNo != 2 || !errors.Is(err2, io.ErrUnexpectedEOF) {
+		t.Errorf("Error: %v, %v", err1, err2)
+	}
+	if len(c.errors) != 2 || c.errors[1] != 1 || c.errors[2] != 1 {
+		t.Errorf("Error: session errors counted %v, want one per failed plant", c.errors)
+	}
+}
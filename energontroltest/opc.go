@@ -0,0 +1,320 @@
+package energontroltest
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dernate/energontrol"
+	"github.com/dernate/gopcxmlda"
+)
+
+// OpcServer is an energontrol.OpcClient answering the OPC requests from the plants of a Scada, so the session handshakes
+// of energontrol run against the stand-in:
+//
+//	ctx = energontrol.WithOpcClient(ctx, energontroltest.NewOpcServer(s))
+//	energontrol.Stop(ctx, Server, UserId, false, false, 1, 2)
+//
+// Sessions go through the states 0, 1, 2 and 4 like on the SCADA, a plant with a SessionState other than 0 reports it
+// for both session types. A submitted session stays in state 4 for SessionEnd.
+type OpcServer struct {
+	Scada       *Scada
+	NoItemNames bool           // return read items without ItemName like some servers do
+	SessionEnd  time.Duration  // time a submitted session stays in state 4, 0 frees it after state 4 was read once
+	Requests    map[string]int // requests by method, e.g. "Browse"
+	sessions    map[sessionKey]*session
+}
+
+type sessionKey struct {
+	PlantNo uint8
+	Type    string // Ctrl or Reset
+}
+
+type session struct {
+	State      uint16
+	SessionId  uint64
+	PrivateKey uint64
+	PubKey     uint64
+	submit     []func(p *Plant) error
+	submitted  time.Time
+}
+
+// NewOpcServer Create an OpcServer for the plants of s
+func NewOpcServer(s *Scada) *OpcServer {
+	return &OpcServer{Scada: s, Requests: make(map[string]int), sessions: make(map[sessionKey]*session)}
+}
+
+// RequestCount Get the number of requests of a method, e.g. "Browse"
+func (o *OpcServer) RequestCount(Method string) int {
+	o.Scada.mu.Lock()
+	defer o.Scada.mu.Unlock()
+	return o.Requests[Method]
+}
+
+var plantItem = regexp.MustCompile(`^Loc/Wec/Plant(\d+)/(Ctrl|Reset)/(\w+)$`)
+
+func (o *OpcServer) GetStatus(ctx context.Context, ClientRequestHandle *string, ClientItemHandle string) (gopcxmlda.TServerStatus, error) {
+	s := o.Scada
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o.Requests["GetStatus"]++
+	var status gopcxmlda.TServerStatus
+	if s.Err != nil {
+		return status, s.Err
+	}
+	status.Response.Result.ServerState = "suspended"
+	if s.Running {
+		status.Response.Result.ServerState = "running"
+	}
+	status.Response.Status.VendorInfo = "energontroltest"
+	return status, nil
+}
+
+func (o *OpcServer) Read(ctx context.Context, items []gopcxmlda.TItem, ClientRequestHandle *string, ClientItemHandles *[]string, ItemPath string, options map[string]interface{}) (gopcxmlda.TRead, error) {
+	s := o.Scada
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o.Requests["Read"]++
+	var value gopcxmlda.TRead
+	if s.Err != nil {
+		return value, s.Err
+	}
+	for _, item := range items {
+		read := gopcxmlda.TItem{ItemName: item.ItemName}
+		v, ok := o.read(item.ItemName)
+		if ok {
+			read.Value.Value = v
+		} else {
+			read.ResultID = "E_UNKNOWNITEMNAME"
+		}
+		if o.NoItemNames {
+			read.ItemName = ""
+		}
+		value.Response.ItemList.Items = append(value.Response.ItemList.Items, read)
+	}
+	return value, nil
+}
+
+// read Get the value of an item, a finished session is free again after its state 4 was read
+func (o *OpcServer) read(ItemName string) (any, bool) {
+	s := o.Scada
+	if ItemName == "Loc/LocNo" {
+		return s.ParkNo, true
+	}
+	if v, ok := s.Items[ItemName]; ok {
+		return v, true
+	}
+	m := plantItem.FindStringSubmatch(ItemName)
+	if m == nil {
+		return nil, false
+	}
+	plant, _ := strconv.Atoi(m[1])
+	p, ok := s.Plants[uint8(plant)]
+	if !ok {
+		return nil, false
+	}
+	key := sessionKey{PlantNo: uint8(plant), Type: m[2]}
+	ses := o.session(key)
+	switch m[3] {
+	case "Ctrl", "Rbh":
+		if m[2] != "Ctrl" {
+			return nil, false
+		}
+		if m[3] == "Rbh" {
+			return p.Rbh, true
+		}
+		return p.Ctrl, true
+	case "SessionState":
+		if p.SessionState != 0 {
+			return p.SessionState, true
+		}
+		if ses == nil {
+			return uint16(0), true
+		}
+		if ses.State == 4 && o.SessionEnd == 0 {
+			delete(o.sessions, key)
+		}
+		return ses.State, true
+	case "SessionPubKey":
+		if ses == nil {
+			return uint64(0), true
+		}
+		return ses.PubKey, true
+	}
+	return nil, false
+}
+
+func (o *OpcServer) Write(ctx context.Context, items []gopcxmlda.TItem, ClientRequestHandle *string, ClientItemHandles *[]string, ItemPath string, options map[string]interface{}) (gopcxmlda.TWrite, error) {
+	s := o.Scada
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o.Requests["Write"]++
+	var value gopcxmlda.TWrite
+	if s.Err != nil {
+		return value, s.Err
+	}
+	for _, item := range items {
+		written := gopcxmlda.TItem{ItemName: item.ItemName}
+		if err := o.write(item.ItemName, item.Value.Value); err != nil {
+			written.ResultID = "E_BADRIGHTS"
+			value.Response.Errors = append(value.Response.Errors, gopcxmlda.TOPCError{ID: written.ResultID, Text: err.Error()})
+		}
+		value.Response.ItemList.Items = append(value.Response.ItemList.Items, written)
+	}
+	return value, nil
+}
+
+// session Get the session of a plant, nil if it is free. A submitted session is free after SessionEnd.
+func (o *OpcServer) session(key sessionKey) *session {
+	ses := o.sessions[key]
+	if ses != nil && ses.State == 4 && o.SessionEnd > 0 && time.Since(ses.submitted) >= o.SessionEnd {
+		delete(o.sessions, key)
+		return nil
+	}
+	return ses
+}
+
+// write Apply a write to the session of a plant
+func (o *OpcServer) write(ItemName string, Value any) error {
+	m := plantItem.FindStringSubmatch(ItemName)
+	if m == nil {
+		return fmt.Errorf("item %s is not writable", ItemName)
+	}
+	plant, _ := strconv.Atoi(m[1])
+	p, ok := o.Scada.Plants[uint8(plant)]
+	if !ok {
+		return fmt.Errorf("plant %d unknown", plant)
+	}
+	v, ok := Value.([]uint64)
+	if !ok {
+		return fmt.Errorf("value of %s is %T instead of []uint64", ItemName, Value)
+	}
+	key := sessionKey{PlantNo: uint8(plant), Type: m[2]}
+	ses := o.session(key)
+	if m[3] == "SessionRequest" {
+		if len(v) != 3 {
+			return fmt.Errorf("SessionRequest needs SessionId, UserId and PrivateKey")
+		}
+		if p.SessionState != 0 || ses != nil {
+			return fmt.Errorf("session of plant %d is not free", plant)
+		}
+		if v[0] > 19 {
+			return fmt.Errorf("SessionId %d out of range", v[0])
+		}
+		o.sessions[key] = &session{State: 1, SessionId: v[0], PrivateKey: v[2], PubKey: 1000 + uint64(plant)}
+		return nil
+	}
+	if ses == nil {
+		return fmt.Errorf("no session of plant %d", plant)
+	}
+	keys := v[max(len(v)-2, 0):]
+	if len(keys) != 2 || keys[0] != ses.PrivateKey || keys[1] != ses.PubKey {
+		return fmt.Errorf("wrong keys for the session of plant %d", plant)
+	}
+	switch m[2] + "/" + m[3] {
+	case "Ctrl/SetCtrl", "Ctrl/SetRbh":
+		if ses.State != 1 && ses.State != 2 {
+			return fmt.Errorf("session of plant %d expects no values", plant)
+		}
+		if p.NoCtrl {
+			return fmt.Errorf("plant %d has no %s", plant, m[3])
+		}
+		if m[3] == "SetCtrl" {
+			ses.submit = append(ses.submit, func(p *Plant) error { return setCtrl(p, v[0]) })
+		} else {
+			ses.submit = append(ses.submit, func(p *Plant) error { return setRbh(p, v[0]) })
+		}
+		ses.State = 2
+	case "Reset/SetReset":
+		if ses.State != 1 {
+			return fmt.Errorf("session of plant %d expects no values", plant)
+		}
+		if p.NoReset {
+			return fmt.Errorf("plant %d has no SetReset", plant)
+		}
+		ses.State = 2
+	case "Ctrl/SessionSubmit", "Reset/SessionSubmit":
+		if ses.State != 2 {
+			return fmt.Errorf("session of plant %d has no values", plant)
+		}
+		for _, f := range ses.submit {
+			if err := f(p); err != nil {
+				return err
+			}
+		}
+		ses.State, ses.submitted = 4, time.Now()
+	default:
+		return fmt.Errorf("item %s is not writable", ItemName)
+	}
+	return nil
+}
+
+func (o *OpcServer) Browse(ctx context.Context, ItemName string, ClientRequestHandle *string, ItemPath string, options gopcxmlda.TBrowseOptions) (gopcxmlda.TBrowse, error) {
+	s := o.Scada
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o.Requests["Browse"]++
+	var b gopcxmlda.TBrowse
+	if s.Err != nil {
+		return b, s.Err
+	}
+	prefix := strings.TrimSuffix(ItemName, "/") + "/"
+	children := make(map[string]bool) // name -> has children
+	names := o.itemNames()
+	for name := range names {
+		if rest, ok := strings.CutPrefix(name, prefix); ok {
+			child, _, deeper := strings.Cut(rest, "/")
+			children[child] = children[child] || deeper
+		}
+	}
+	for name, hasChildren := range children {
+		e := gopcxmlda.TBrowseElement{Name: name, ItemName: prefix + name, HasChildren: hasChildren}
+		e.IsItem = names[e.ItemName]
+		if options.BrowseFilter == "branch" && !hasChildren || options.BrowseFilter == "item" && !e.IsItem {
+			continue
+		}
+		if options.ElementNameFilter != "" {
+			if match, _ := path.Match(options.ElementNameFilter, name); !match {
+				continue
+			}
+		}
+		b.Response.Elements = append(b.Response.Elements, e)
+	}
+	sort.Slice(b.Response.Elements, func(i, j int) bool { return b.Response.Elements[i].Name < b.Response.Elements[j].Name })
+	return b, nil
+}
+
+// itemNames Get the names of all items of the server
+func (o *OpcServer) itemNames() map[string]bool {
+	s := o.Scada
+	names := map[string]bool{"Loc/LocNo": true}
+	for name := range s.Items {
+		names[name] = true
+	}
+	for plant, p := range s.Plants {
+		prefix := fmt.Sprintf("Loc/Wec/Plant%d/", plant)
+		items := []string{"Data/Status", "Ctrl/Ctrl", "Ctrl/Rbh"}
+		for _, session := range []string{"Ctrl", "Reset"} {
+			for _, item := range []string{"SessionState", "SessionRequest", "SessionPubKey", "SessionSubmit"} {
+				items = append(items, session+"/"+item)
+			}
+		}
+		if !p.NoCtrl {
+			items = append(items, "Ctrl/SetCtrl", "Ctrl/SetRbh")
+		}
+		if !p.NoReset {
+			items = append(items, "Reset/SetReset")
+		}
+		for _, item := range items {
+			names[prefix+item] = true
+		}
+	}
+	return names
+}
+
+var _ energontrol.OpcClient = (*OpcServer)(nil)
@@ -0,0 +1,348 @@
+// Package energontroltest provides a stand-in SCADA for testing code built on energontrol without a real OPC XML DA server.
+package energontroltest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dernate/energontrol"
+)
+
+// Rbh states the stand-in reports after the corresponding command
+const (
+	RbhStandardState = energontrol.RbhInstalled | energontrol.RbhAutoDeicingAllowed
+	RbhAutoOffState  = energontrol.RbhInstalled | energontrol.RbhAutoOffWEA
+	RbhOnState       = energontrol.RbhInstalled | energontrol.RbhAutoOffWEA | energontrol.RbhManualOnSCADA
+)
+
+// Plant state of one plant in the stand-in SCADA
+type Plant struct {
+	Ctrl         uint64
+	Rbh          uint64
+	SessionState uint16 // a state other than 0 makes every command fail like an occupied session
+	NoCtrl       bool   // plant without SetCtrl/SetRbh
+	NoReset      bool   // plant without SetReset
+}
+
+// Call a command received by the stand-in SCADA
+type Call struct {
+	Action  string
+	UserId  uint64
+	PlantNo []uint8
+}
+
+// Scada is an in-memory energontrol.Controller. Commands change the plant states like the SCADA would,
+// states above 128 can't be changed and plants with a SessionState other than 0 fail.
+type Scada struct {
+	mu      sync.Mutex
+	ParkNo  uint64
+	Running bool
+	Err     error // if set, every call fails with Err
+	Plants  map[uint8]*Plant
+	Items   map[string]any // further items for ReadItems and BrowseItems, by item name
+	Calls   []Call
+}
+
+// NewScada Create a running stand-in SCADA with started plants and Rbh in Standard
+func NewScada(ParkNo uint64, PlantNo ...uint8) *Scada {
+	s := &Scada{ParkNo: ParkNo, Running: true, Plants: make(map[uint8]*Plant)}
+	for _, plant := range PlantNo {
+		s.Plants[plant] = &Plant{Rbh: RbhStandardState}
+	}
+	return s
+}
+
+// Plant Get a copy of the state of a plant
+func (s *Scada) Plant(PlantNo uint8) Plant {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p, ok := s.Plants[PlantNo]; ok {
+		return *p
+	}
+	return Plant{}
+}
+
+// SetPlant Replace the state of a plant
+func (s *Scada) SetPlant(PlantNo uint8, p Plant) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Plants[PlantNo] = &p
+}
+
+// CallLog Get a copy of all received commands
+func (s *Scada) CallLog() []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Call(nil), s.Calls...)
+}
+
+func (s *Scada) ServerAvailable(ctx context.Context) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Err != nil {
+		return false, s.Err
+	}
+	return s.Running, nil
+}
+
+// ServerStatus Get "running" or "suspended" with the vendor info of the stand-in
+func (s *Scada) ServerStatus(ctx context.Context) (energontrol.ServerStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Err != nil {
+		return energontrol.ServerStatus{}, s.Err
+	}
+	status := energontrol.ServerStatus{State: "running", ReplyTime: time.Now(), VendorInfo: "energontroltest", ProductVersion: "1"}
+	if !s.Running {
+		status.State = "suspended"
+	}
+	return status, nil
+}
+
+func (s *Scada) Turbines(ctx context.Context) (energontrol.TurbineInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Err != nil {
+		return energontrol.TurbineInfo{}, s.Err
+	}
+	T := energontrol.TurbineInfo{
+		ParkNo: s.ParkNo,
+		Ctrl:   make(map[uint8]bool),
+		Rbh:    make(map[uint8]bool),
+		Reset:  make(map[uint8]bool),
+		Para:   make(map[uint8]bool),
+		IceDet: make(map[uint8]bool),
+	}
+	for plant, p := range s.Plants {
+		T.PlantNo = append(T.PlantNo, plant)
+		T.Ctrl[plant] = !p.NoCtrl
+		T.Rbh[plant] = !p.NoCtrl
+		T.Reset[plant] = !p.NoReset
+		T.Para[plant] = false
+		T.IceDet[plant] = false
+	}
+	sort.Slice(T.PlantNo, func(i, j int) bool { return T.PlantNo[i] < T.PlantNo[j] })
+	return T, nil
+}
+
+func (s *Scada) State(ctx context.Context, CtrlOrRbh string, PlantNo []uint8) ([]energontrol.PlantState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	if CtrlOrRbh != "Ctrl" && CtrlOrRbh != "Rbh" {
+		return nil, fmt.Errorf("CtrlOrRbh must be either Ctrl or Rbh")
+	}
+	var states []energontrol.PlantState
+	for _, plant := range PlantNo {
+		p, ok := s.Plants[plant]
+		if !ok {
+			// like the server, only the unknown plant fails
+			state := energontrol.PlantState{PlantNo: plant, Err: &energontrol.ItemError{ItemName: energontrol.PlantItem(plant, "Ctrl/"+CtrlOrRbh), ResultID: "E_UNKNOWNITEMNAME"}}
+			if CtrlOrRbh == "Ctrl" {
+				state.CtrlState = energontrol.CtrlValues["CommunicationError"]
+			}
+			states = append(states, state)
+			continue
+		}
+		state := energontrol.PlantState{PlantNo: plant, CtrlState: p.Ctrl}
+		if CtrlOrRbh == "Rbh" {
+			state.CtrlState = p.Rbh
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// SessionState Get the SessionState of the plants, the same for Ctrl and Reset
+func (s *Scada) SessionState(ctx context.Context, CtrlOrReset string, PlantNo []uint8) ([]uint16, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	if CtrlOrReset != "Ctrl" && CtrlOrReset != "Reset" {
+		return nil, fmt.Errorf("CtrlOrReset must be either Ctrl or Reset")
+	}
+	var states []uint16
+	for _, plant := range PlantNo {
+		p, ok := s.Plants[plant]
+		if !ok {
+			return nil, fmt.Errorf("item Loc/Wec/Plant%d/%s/SessionState unknown", plant, CtrlOrReset)
+		}
+		states = append(states, p.SessionState)
+	}
+	return states, nil
+}
+
+// BrowseItems Browse the item names of Items
+func (s *Scada) BrowseItems(ctx context.Context, ItemName string, Depth int) ([]energontrol.BrowseElement, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	return s.browse(strings.TrimSuffix(ItemName, "/"), Depth, 1), nil
+}
+
+func (s *Scada) browse(ItemName string, Depth int, level int) []energontrol.BrowseElement {
+	prefix := ItemName + "/"
+	children := make(map[string]bool) // name -> has children
+	for name := range s.Items {
+		if rest, ok := strings.CutPrefix(name, prefix); ok {
+			child, _, deeper := strings.Cut(rest, "/")
+			children[child] = children[child] || deeper
+		}
+	}
+	var elements []energontrol.BrowseElement
+	for name, hasChildren := range children {
+		e := energontrol.BrowseElement{Name: name, ItemName: prefix + name, HasChildren: hasChildren}
+		_, e.IsItem = s.Items[e.ItemName]
+		if hasChildren && (Depth == 0 || level < Depth) {
+			e.Children = s.browse(e.ItemName, Depth, level+1)
+		}
+		elements = append(elements, e)
+	}
+	sort.Slice(elements, func(i, j int) bool { return elements[i].Name < elements[j].Name })
+	return elements
+}
+
+// ReadItems Read values of Items, unknown items have Err set
+func (s *Scada) ReadItems(ctx context.Context, ItemName ...string) ([]energontrol.ItemValue, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	if len(ItemName) == 0 {
+		return nil, fmt.Errorf("no ItemName provided")
+	}
+	values := make([]energontrol.ItemValue, len(ItemName))
+	for i, name := range ItemName {
+		values[i].ItemName = name
+		v, ok := s.Items[name]
+		if !ok {
+			values[i].Err = fmt.Errorf("item %s not returned by the server", name)
+			continue
+		}
+		values[i].Value = v
+		values[i].Type = fmt.Sprintf("%T", v)
+	}
+	return values, nil
+}
+
+// command Apply f to every plant like a session handshake would
+func (s *Scada) command(Action string, UserId uint64, PlantNo []uint8, f func(p *Plant) error) ([]bool, []error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Calls = append(s.Calls, Call{Action: Action, UserId: UserId, PlantNo: append([]uint8(nil), PlantNo...)})
+	if len(PlantNo) == 0 {
+		return nil, []error{fmt.Errorf("no PlantNo provided")}
+	}
+	ok := make([]bool, len(PlantNo))
+	errList := make([]error, len(PlantNo))
+	for i, plant := range PlantNo {
+		p, exists := s.Plants[plant]
+		switch {
+		case s.Err != nil:
+			errList[i] = s.Err
+		case !exists:
+			errList[i] = fmt.Errorf("plant %d unknown", plant)
+		case p.SessionState != 0:
+			errList[i] = &energontrol.SessionError{PlantNo: plant, State: p.SessionState,
+				Text: fmt.Sprintf("Can't start session, Session is '%s'", energontrol.SessionStateText(p.SessionState))}
+		default:
+			errList[i] = f(p)
+			ok[i] = errList[i] == nil
+		}
+	}
+	return ok, errList
+}
+
+func setCtrl(p *Plant, Value uint64) error {
+	if p.NoCtrl {
+		return fmt.Errorf("plant has no SetCtrl")
+	}
+	if p.Ctrl <= 128 {
+		p.Ctrl = Value
+	}
+	return nil
+}
+
+func setRbh(p *Plant, Value uint64) error {
+	if p.NoCtrl {
+		return fmt.Errorf("plant has no SetRbh")
+	}
+	switch Value {
+	case 0:
+		p.Rbh = RbhStandardState
+	case 2:
+		p.Rbh = RbhAutoOffState
+	case 10:
+		p.Rbh = RbhOnState
+	default:
+		return fmt.Errorf("invalid Rbh value %d", Value)
+	}
+	return nil
+}
+
+func (s *Scada) Start(ctx context.Context, UserId uint64, PlantNo ...uint8) ([]bool, []error) {
+	return s.command("Start", UserId, PlantNo, func(p *Plant) error { return setCtrl(p, 0) })
+}
+
+func (s *Scada) Stop(ctx context.Context, UserId uint64, FullStop bool, ForceExplicitCommand bool, PlantNo ...uint8) ([]bool, []error) {
+	Value := uint64(1)
+	if FullStop {
+		Value = 2
+	}
+	return s.command("Stop", UserId, PlantNo, func(p *Plant) error {
+		if p.Ctrl > 0 && !ForceExplicitCommand {
+			return nil
+		}
+		return setCtrl(p, Value)
+	})
+}
+
+func (s *Scada) Reset(ctx context.Context, UserId uint64, PlantNo ...uint8) ([]bool, []error) {
+	return s.command("Reset", UserId, PlantNo, func(p *Plant) error {
+		if p.NoReset {
+			return fmt.Errorf("plant has no SetReset")
+		}
+		return nil
+	})
+}
+
+func (s *Scada) RbhOn(ctx context.Context, UserId uint64, PlantNo ...uint8) ([]bool, []error) {
+	return s.command("RbhOn", UserId, PlantNo, func(p *Plant) error { return setRbh(p, 10) })
+}
+
+func (s *Scada) RbhAutoOff(ctx context.Context, UserId uint64, PlantNo ...uint8) ([]bool, []error) {
+	return s.command("RbhAutoOff", UserId, PlantNo, func(p *Plant) error { return setRbh(p, 2) })
+}
+
+func (s *Scada) RbhStandard(ctx context.Context, UserId uint64, PlantNo ...uint8) ([]bool, []error) {
+	return s.command("RbhStandard", UserId, PlantNo, func(p *Plant) error { return setRbh(p, 0) })
+}
+
+func (s *Scada) ControlAndRbh(ctx context.Context, UserId uint64, Values energontrol.ControlAndRbhValue, PlantNo ...uint8) ([]bool, []error) {
+	return s.command("ControlAndRbh", UserId, PlantNo, func(p *Plant) error {
+		if Values.SetCtrlValue {
+			if err := setCtrl(p, Values.CtrlValue); err != nil {
+				return err
+			}
+		}
+		if Values.SetRbhValue {
+			return setRbh(p, Values.RbhValue)
+		}
+		return nil
+	})
+}
+
+var _ energontrol.Controller = (*Scada)(nil)
+var _ energontrol.SessionStater = (*Scada)(nil)
+var _ energontrol.ItemReader = (*Scada)(nil)
@@ -0,0 +1,164 @@
+package energontrol
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// InventoryRow a plant of a park with its capabilities and current decoded states
+type InventoryRow struct {
+	Park      string   `json:"park"`
+	ParkNo    uint64   `json:"park_no"`
+	PlantNo   uint8    `json:"plant_no"`
+	Name      string   `json:"name"`
+	Ctrl      bool     `json:"ctrl"`
+	Rbh       bool     `json:"rbh"`
+	Reset     bool     `json:"reset"`
+	Para      bool     `json:"para"`
+	IceDet    bool     `json:"ice_det"`
+	CtrlState *uint64  `json:"ctrl_state,omitempty"` // nil for plants without Ctrl
+	CtrlText  string   `json:"ctrl_text,omitempty"`
+	RbhState  *uint64  `json:"rbh_state,omitempty"` // nil for plants without Rbh
+	RbhFlags  []string `json:"rbh_flags,omitempty"`
+	Error     string   `json:"error,omitempty"` // why the states are missing
+}
+
+// inventoryHeader columns of WriteInventoryCSV
+var inventoryHeader = []string{"Park", "ParkNo", "PlantNo", "Name", "Ctrl", "Rbh", "Reset", "Para", "IceDet", "CtrlState", "CtrlText", "RbhState", "RbhFlags", "Error"}
+
+// Inventory Get the allowed turbines of a park with their capabilities and current Ctrl and Rbh states.
+// A failed state read is reported per row, so the capabilities are exported anyway.
+func Inventory(ctx context.Context, c Controller, Park ParkConfig) ([]InventoryRow, error) {
+	ctx = Park.Context(ctx)
+	T, err := c.Turbines(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ParkNo := T.ParkNo
+	if Park.ParkNo != 0 {
+		ParkNo = Park.ParkNo
+	}
+	var rows []InventoryRow
+	var ctrlPlants, rbhPlants []uint8
+	for _, plant := range T.PlantNo {
+		if !Park.PlantAllowed(plant) {
+			continue
+		}
+		rows = append(rows, InventoryRow{
+			Park:    Park.Name,
+			ParkNo:  ParkNo,
+			PlantNo: plant,
+			Name:    Park.PlantName(plant),
+			Ctrl:    T.Ctrl[plant],
+			Rbh:     T.Rbh[plant],
+			Reset:   T.Reset[plant],
+			Para:    T.Para[plant],
+			IceDet:  T.IceDet[plant],
+		})
+		if T.Ctrl[plant] {
+			ctrlPlants = append(ctrlPlants, plant)
+		}
+		if T.Rbh[plant] {
+			rbhPlants = append(rbhPlants, plant)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].PlantNo < rows[j].PlantNo })
+	byPlant := make(map[uint8]*InventoryRow, len(rows))
+	for i := range rows {
+		byPlant[rows[i].PlantNo] = &rows[i]
+	}
+	for _, CtrlOrRbh := range []string{"Ctrl", "Rbh"} {
+		PlantNo := ctrlPlants
+		if CtrlOrRbh == "Rbh" {
+			PlantNo = rbhPlants
+		}
+		if len(PlantNo) == 0 {
+			continue
+		}
+		states, err := c.State(ctx, CtrlOrRbh, PlantNo)
+		if err != nil {
+			for _, plant := range PlantNo {
+				row := byPlant[plant]
+				if row.Error != "" {
+					row.Error += "; "
+				}
+				row.Error += "read " + CtrlOrRbh + " state: " + err.Error()
+			}
+			continue
+		}
+		for _, state := range states {
+			row, ok := byPlant[state.PlantNo]
+			if !ok {
+				continue
+			}
+			if state.Err != nil {
+				if row.Error != "" {
+					row.Error += "; "
+				}
+				row.Error += "read " + CtrlOrRbh + " state: " + state.Err.Error()
+				continue
+			}
+			value := state.CtrlState
+			if CtrlOrRbh == "Ctrl" {
+				row.CtrlState = &value
+				row.CtrlText = CtrlStateText(value)
+			} else {
+				row.RbhState = &value
+				row.RbhFlags = RbhStateText(value)
+			}
+		}
+	}
+	return rows, nil
+}
+
+// WriteInventoryJSON Write the rows as an indented JSON array
+func WriteInventoryJSON(w io.Writer, rows []InventoryRow) error {
+	if rows == nil {
+		rows = []InventoryRow{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(rows)
+}
+
+// WriteInventoryCSV Write the rows as CSV with a header line. Missing states are empty, Rbh flags are joined by "; ".
+func WriteInventoryCSV(w io.Writer, rows []InventoryRow) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(inventoryHeader); err != nil {
+		return err
+	}
+	optional := func(v *uint64) string {
+		if v == nil {
+			return ""
+		}
+		return strconv.FormatUint(*v, 10)
+	}
+	for _, r := range rows {
+		record := []string{
+			r.Park,
+			strconv.FormatUint(r.ParkNo, 10),
+			strconv.Itoa(int(r.PlantNo)),
+			r.Name,
+			strconv.FormatBool(r.Ctrl),
+			strconv.FormatBool(r.Rbh),
+			strconv.FormatBool(r.Reset),
+			strconv.FormatBool(r.Para),
+			strconv.FormatBool(r.IceDet),
+			optional(r.CtrlState),
+			r.CtrlText,
+			optional(r.RbhState),
+			strings.Join(r.RbhFlags, "; "),
+			r.Error,
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,71 @@
+package energontrol_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/dernate/energontrol"
+	"github.com/dernate/energontrol/energontroltest"
+)
+
+func TestInventory(t *testing.T) {
+	scada := energontroltest.NewScada(4711, 1, 2, 3)
+	scada.SetPlant(2, energontroltest.Plant{Ctrl: energontrol.CtrlValues["Stop90"], Rbh: energontroltest.RbhAutoOffState})
+	scada.SetPlant(3, energontroltest.Plant{NoCtrl: true, NoReset: true})
+	Park := energontrol.ParkConfig{Name: "north", Aliases: map[string]uint8{"hill": 2}}
+	rows, err := energontrol.Inventory(context.Background(), scada, Park)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("Error: %+v", rows)
+	}
+	if r := rows[1]; r.Name != "hill" || r.ParkNo != 4711 || r.CtrlState == nil || r.CtrlText != "Stop90" || r.RbhState == nil || len(r.RbhFlags) == 0 {
+		t.Errorf("Error: %+v", r)
+	}
+	if r := rows[2]; r.Ctrl || r.Reset || r.CtrlState != nil || r.RbhState != nil {
+		t.Errorf("Error: %+v", r)
+	}
+
+	var buf bytes.Buffer
+	if err = energontrol.WriteInventoryCSV(&buf, rows); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], "Park,ParkNo,PlantNo,") || !strings.HasPrefix(lines[2], "north,4711,2,hill,true,true,true,false,false,2,Stop90,") {
+		t.Errorf("Error: %q", lines)
+	}
+	if !strings.HasSuffix(lines[3], ",,,,,") {
+		t.Errorf("Error: plant without states %q", lines[3])
+	}
+
+	buf.Reset()
+	if err = energontrol.WriteInventoryJSON(&buf, rows); err != nil {
+		t.Fatal(err)
+	}
+	var decoded []energontrol.InventoryRow
+	if err = json.Unmarshal(buf.Bytes(), &decoded); err != nil || len(decoded) != 3 || *decoded[1].CtrlState != 2 {
+		t.Errorf("Error: %s, %v", buf.String(), err)
+	}
+
+	// a failed state read is reported per row
+	failing := &failingState{Controller: scada}
+	if rows, err = energontrol.Inventory(context.Background(), failing, Park); err != nil {
+		t.Fatal(err)
+	}
+	if rows[0].Error == "" || rows[0].CtrlState != nil || rows[2].Error != "" {
+		t.Errorf("Error: %+v", rows)
+	}
+}
+
+// failingState fails every state read
+type failingState struct {
+	energontrol.Controller
+}
+
+func (f *failingState) State(ctx context.Context, CtrlOrRbh string, PlantNo []uint8) ([]energontrol.PlantState, error) {
+	return nil, context.DeadlineExceeded
+}
@@ -0,0 +1,256 @@
+package energontrol
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/dernate/gopcxmlda"
+	"gopkg.in/yaml.v3"
+	"log/slog"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+// LoadFleetConfig Read a YAML fleet configuration from the file at path and validate it
+func LoadFleetConfig(path string) (FleetConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return FleetConfig{}, err
+	}
+	return ParseFleetConfig(data)
+}
+
+// ParseFleetConfig Parse and validate a YAML fleet configuration. Missing locale and timeout are set to "en-us" and 10s.
+func ParseFleetConfig(data []byte) (FleetConfig, error) {
+	var F FleetConfig
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&F); err != nil {
+		return FleetConfig{}, fmt.Errorf("fleet config: %w", err)
+	}
+	for i := range F.Parks {
+		if F.Parks[i].LocaleID == "" {
+			F.Parks[i].LocaleID = "en-us"
+		}
+		if F.Parks[i].Timeout == 0 {
+			F.Parks[i].Timeout = 10 * time.Second
+		}
+	}
+	if err := F.Validate(); err != nil {
+		return FleetConfig{}, err
+	}
+	return F, nil
+}
+
+// Validate Check all parks of the fleet and return all found problems joined in one error
+func (F FleetConfig) Validate() error {
+	var errList []error
+	if len(F.Parks) == 0 {
+		errList = append(errList, errors.New("fleet config: no parks configured"))
+	}
+	names := make(map[string]bool)
+	for i, P := range F.Parks {
+		if P.Name == "" {
+			errList = append(errList, fmt.Errorf("fleet config: park %d has no name", i))
+		} else if names[P.Name] {
+			errList = append(errList, fmt.Errorf("fleet config: park name %q is used more than once", P.Name))
+		}
+		names[P.Name] = true
+		if err := P.Validate(); err != nil {
+			errList = append(errList, err)
+		}
+	}
+	return errors.Join(errList...)
+}
+
+// Validate Check the connection details, plant allowlist, aliases, user id source and session timing of the park
+func (P ParkConfig) Validate() error {
+	var errList []error
+	_url, err := url.Parse(P.Url)
+	if err != nil {
+		errList = append(errList, fmt.Errorf("park %q: invalid url: %w", P.Name, err))
+	} else if _url.Scheme == "" || _url.Host == "" {
+		errList = append(errList, fmt.Errorf("park %q: url %q needs scheme and host", P.Name, P.Url))
+	}
+	if P.Timeout < 0 {
+		errList = append(errList, fmt.Errorf("park %q: timeout must not be negative", P.Name))
+	}
+	allowed := make(map[uint8]bool)
+	for _, plant := range P.Plants {
+		if allowed[plant] {
+			errList = append(errList, fmt.Errorf("park %q: plant %d is listed more than once", P.Name, plant))
+		}
+		allowed[plant] = true
+	}
+	for alias, plant := range P.Aliases {
+		if alias == "" {
+			errList = append(errList, fmt.Errorf("park %q: empty alias for plant %d", P.Name, plant))
+		}
+		if _, err := strconv.ParseUint(alias, 10, 8); err == nil {
+			errList = append(errList, fmt.Errorf("park %q: alias %q must not be a number", P.Name, alias))
+		}
+		if len(P.Plants) > 0 && !allowed[plant] {
+			errList = append(errList, fmt.Errorf("park %q: alias %q refers to plant %d, which is not in plants", P.Name, alias, plant))
+		}
+	}
+	if P.UserId.Value != 0 && P.UserId.Env != "" {
+		errList = append(errList, fmt.Errorf("park %q: user_id must have either value or env, not both", P.Name))
+	} else if P.UserId.Value == 0 && P.UserId.Env == "" {
+		errList = append(errList, fmt.Errorf("park %q: user_id needs value or env", P.Name))
+	}
+	if P.Session.Sleep < 0 {
+		errList = append(errList, fmt.Errorf("park %q: session sleep must not be negative", P.Name))
+	}
+	return errors.Join(errList...)
+}
+
+// Park Get the configuration of the park with the given Name
+func (F FleetConfig) Park(Name string) (ParkConfig, error) {
+	for _, P := range F.Parks {
+		if P.Name == Name {
+			return P, nil
+		}
+	}
+	return ParkConfig{}, fmt.Errorf("park %q not found in fleet config", Name)
+}
+
+// Servers Get a ready to use Server for every park, mapped by park name
+func (F FleetConfig) Servers() (map[string]gopcxmlda.Server, error) {
+	servers := make(map[string]gopcxmlda.Server)
+	for _, P := range F.Parks {
+		S, err := P.Server()
+		if err != nil {
+			return nil, err
+		}
+		servers[P.Name] = S
+	}
+	return servers, nil
+}
+
+// Server Get a ready to use Server for the park
+func (P ParkConfig) Server() (gopcxmlda.Server, error) {
+	_url, err := url.Parse(P.Url)
+	if err != nil {
+		return gopcxmlda.Server{}, fmt.Errorf("park %q: invalid url: %w", P.Name, err)
+	}
+	return gopcxmlda.Server{
+		Url:      _url,
+		LocaleID: P.LocaleID,
+		Timeout:  P.Timeout,
+	}, nil
+}
+
+// ResolveUserId Get the UserId of the park, either from the config value or from the configured environment variable
+func (P ParkConfig) ResolveUserId() (uint64, error) {
+	UserId, err := P.UserId.Resolve()
+	if err != nil {
+		return 0, fmt.Errorf("park %q: %w", P.Name, err)
+	}
+	return UserId, nil
+}
+
+// Resolve Get the UserId, either the value or the number in the environment variable Env
+func (S UserIdSource) Resolve() (uint64, error) {
+	if S.Env == "" {
+		return S.Value, nil
+	}
+	userIdStr, ok := os.LookupEnv(S.Env)
+	if !ok {
+		return 0, fmt.Errorf("environment variable %s is not set", S.Env)
+	}
+	UserId, err := strconv.ParseUint(userIdStr, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("environment variable %s: %w", S.Env, err)
+	}
+	return UserId, nil
+}
+
+// ResolvePlants Get the PlantNo for plant numbers or aliases. If the park has a plant allowlist, other plants are rejected.
+func (P ParkConfig) ResolvePlants(Plants ...string) ([]uint8, error) {
+	var PlantNo []uint8
+	for _, plant := range Plants {
+		num, ok := P.Aliases[plant]
+		if !ok {
+			n, err := strconv.ParseUint(plant, 10, 8)
+			if err != nil {
+				return nil, fmt.Errorf("park %q: unknown plant %q", P.Name, plant)
+			}
+			num = uint8(n)
+		}
+		if !P.PlantAllowed(num) {
+			return nil, fmt.Errorf("park %q: plant %d is not allowed", P.Name, num)
+		}
+		PlantNo = append(PlantNo, num)
+	}
+	return PlantNo, nil
+}
+
+// PlantAllowed Check if the plant is in the allowlist of the park. Without allowlist every plant is allowed.
+func (P ParkConfig) PlantAllowed(PlantNo uint8) bool {
+	if len(P.Plants) == 0 {
+		return true
+	}
+	for _, plant := range P.Plants {
+		if plant == PlantNo {
+			return true
+		}
+	}
+	return false
+}
+
+// PlantName Get the alias of the plant, the first in sort order if it has several, or "Plant<PlantNo>" if it has none
+func (P ParkConfig) PlantName(PlantNo uint8) string {
+	name := ""
+	for alias, plant := range P.Aliases {
+		if plant == PlantNo && (name == "" || alias < name) {
+			name = alias
+		}
+	}
+	if name == "" {
+		return fmt.Sprintf("Plant%d", PlantNo)
+	}
+	return name
+}
+
+// Context Return a context, which applies the session timing of the park to the library's functions
+// and tags their log messages with the park
+func (P ParkConfig) Context(ctx context.Context) context.Context {
+	ctx = WithLogAttrs(ctx, slog.String(LogKeyPark, P.Name))
+	if P.ParkNo != 0 {
+		ctx = WithLogAttrs(ctx, slog.Uint64(LogKeyParkNo, P.ParkNo))
+	}
+	if P.Session.Sleep == 0 && P.Session.Retries == 0 {
+		return ctx
+	}
+	Sleep := P.Session.Sleep
+	if Sleep == 0 {
+		Sleep = defaultSessionWait.Sleep
+	}
+	Retries := P.Session.Retries
+	if Retries == 0 {
+		Retries = defaultSessionWait.Retries
+	}
+	return WithSessionWait(ctx, Sleep, Retries)
+}
+
+// VerifyParkNo Check that the Server of the park reports the configured ParkNo. Parks without park_no are not checked.
+func (P ParkConfig) VerifyParkNo(ctx context.Context) error {
+	if P.ParkNo == 0 {
+		return nil
+	}
+	Server, err := P.Server()
+	if err != nil {
+		return err
+	}
+	match, err := ParkNoMatch(ctx, Server, P.ParkNo, true)
+	if err != nil {
+		return err
+	}
+	if !match {
+		return fmt.Errorf("park %q: server does not report ParkNo %d", P.Name, P.ParkNo)
+	}
+	return nil
+}
@@ -0,0 +1,313 @@
+package energontrol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// BreakerState state of the circuit breaker of a Supervisor
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"    // the server works, calls are passed on
+	BreakerOpen     BreakerState = "open"      // calls fail fast with ErrCircuitOpen
+	BreakerHalfOpen BreakerState = "half-open" // OpenTimeout passed, a probe decides
+)
+
+// ErrCircuitOpen calls of a Supervisor fail with it while the circuit breaker is open
+var ErrCircuitOpen = errors.New("circuit breaker open, server unavailable")
+
+// maxTransitions transitions kept by a Supervisor
+const maxTransitions = 100
+
+// Health the health of a server tracked by a Supervisor
+type Health struct {
+	Breaker     BreakerState  `json:"breaker"`
+	Since       time.Time     `json:"since"` // of the breaker state
+	Available   bool          `json:"available"`
+	Status      ServerStatus  `json:"status"`      // of the last successful check, if the Controller is a StatusReader
+	Latency     time.Duration `json:"latency"`     // of the last check
+	AvgLatency  time.Duration `json:"avg_latency"` // moving average over the successful checks
+	Failures    int           `json:"failures"`    // consecutive failed checks and reads
+	LastCheck   time.Time     `json:"last_check"`
+	LastSuccess time.Time     `json:"last_success"`
+	LastError   string        `json:"last_error,omitempty"`
+}
+
+// HealthTransition a change of the breaker state
+type HealthTransition struct {
+	Time   time.Time    `json:"time"`
+	From   BreakerState `json:"from"`
+	To     BreakerState `json:"to"`
+	Reason string       `json:"reason"`
+}
+
+// Supervisor Controller, which checks the server of the wrapped Controller every Interval with GetStatus and fails calls
+// fast with ErrCircuitOpen after FailureThreshold consecutive failed checks or reads. Once OpenTimeout passed, the next call
+// or check probes the server and closes the breaker again if it works. Failed commands don't count, they fail per plant.
+type Supervisor struct {
+	Controller
+	Interval         time.Duration          // between the checks of Run, 30s if 0
+	FailureThreshold int                    // consecutive failures, which open the breaker, 3 if 0
+	OpenTimeout      time.Duration          // time the breaker stays open before a probe, 1m if 0
+	OnTransition     func(HealthTransition) // called on every transition of the breaker, e.g. to alert
+
+	mu          sync.Mutex
+	health      Health
+	transitions []HealthTransition
+}
+
+// Run Check the server every Interval until ctx is done
+func (s *Supervisor) Run(ctx context.Context) error {
+	interval := s.Interval
+	if interval == 0 {
+		interval = 30 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		s.Check(ctx)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check Probe the server with GetStatus, or ServerAvailable if the Controller is no StatusReader, and update the health
+func (s *Supervisor) Check(ctx context.Context) Health {
+	start := time.Now()
+	var status ServerStatus
+	var err error
+	if r, ok := s.Controller.(StatusReader); ok {
+		if status, err = r.ServerStatus(ctx); err == nil && status.State != "running" {
+			err = fmt.Errorf("server state is %q", status.State)
+		}
+	} else {
+		var available bool
+		if available, err = s.Controller.ServerAvailable(ctx); err == nil && !available {
+			err = errors.New("server not running")
+		}
+	}
+	latency := time.Since(start)
+
+	s.mu.Lock()
+	s.health.LastCheck = start
+	s.health.Latency = latency
+	if err == nil {
+		s.health.Status = status
+		if s.health.AvgLatency == 0 {
+			s.health.AvgLatency = latency
+		} else {
+			s.health.AvgLatency = (7*s.health.AvgLatency + latency) / 8
+		}
+	}
+	t := s.record(err, "check")
+	h := s.health
+	s.mu.Unlock()
+	s.notify(ctx, t)
+	return h
+}
+
+// Health Get the current health
+func (s *Supervisor) Health() Health {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h := s.health
+	if h.Breaker == "" {
+		h.Breaker = BreakerClosed
+	}
+	return h
+}
+
+// Transitions Get the last transitions of the breaker, the oldest first
+func (s *Supervisor) Transitions() []HealthTransition {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]HealthTransition(nil), s.transitions...)
+}
+
+// record Update the health with the outcome of a check or read. s.mu must be held.
+func (s *Supervisor) record(err error, source string) *HealthTransition {
+	now := time.Now()
+	if s.health.Breaker == "" {
+		s.health.Breaker, s.health.Since = BreakerClosed, now
+	}
+	if err == nil {
+		s.health.Available = true
+		s.health.Failures = 0
+		s.health.LastSuccess = now
+		s.health.LastError = ""
+		if s.health.Breaker != BreakerClosed {
+			return s.transition(now, BreakerClosed, source+" succeeded")
+		}
+		return nil
+	}
+	s.health.Available = false
+	s.health.Failures++
+	s.health.LastError = err.Error()
+	threshold := s.FailureThreshold
+	if threshold == 0 {
+		threshold = 3
+	}
+	switch {
+	case s.health.Breaker == BreakerHalfOpen:
+		return s.transition(now, BreakerOpen, "probe failed: "+err.Error())
+	case s.health.Breaker == BreakerClosed && s.health.Failures >= threshold:
+		return s.transition(now, BreakerOpen, fmt.Sprintf("%d consecutive failures, last: %s", s.health.Failures, err))
+	}
+	return nil
+}
+
+// transition Change the breaker state. s.mu must be held.
+func (s *Supervisor) transition(now time.Time, to BreakerState, reason string) *HealthTransition {
+	t := HealthTransition{Time: now, From: s.health.Breaker, To: to, Reason: reason}
+	s.health.Breaker, s.health.Since = to, now
+	s.transitions = append(s.transitions, t)
+	if len(s.transitions) > maxTransitions {
+		s.transitions = s.transitions[len(s.transitions)-maxTransitions:]
+	}
+	return &t
+}
+
+// notify Log a transition and pass it to OnTransition
+func (s *Supervisor) notify(ctx context.Context, t *HealthTransition) {
+	if t == nil {
+		return
+	}
+	level := slog.LevelWarn
+	if t.To == BreakerClosed {
+		level = slog.LevelInfo
+	}
+	Logger(ctx).LogAttrs(ctx, level, fmt.Sprintf("circuit breaker %s -> %s: %s", t.From, t.To, t.Reason), slog.String(LogKeyAction, "Health"))
+	if s.OnTransition != nil {
+		s.OnTransition(*t)
+	}
+}
+
+// allow Check if a call may pass. An open breaker past OpenTimeout turns half-open and the server is probed.
+func (s *Supervisor) allow(ctx context.Context) error {
+	timeout := s.OpenTimeout
+	if timeout == 0 {
+		timeout = time.Minute
+	}
+	s.mu.Lock()
+	switch s.health.Breaker {
+	case BreakerOpen:
+		if time.Since(s.health.Since) < timeout {
+			s.mu.Unlock()
+			return ErrCircuitOpen
+		}
+		t := s.transition(time.Now(), BreakerHalfOpen, "open timeout passed")
+		s.mu.Unlock()
+		s.notify(ctx, t)
+		if h := s.Check(ctx); h.Breaker != BreakerClosed {
+			return ErrCircuitOpen
+		}
+		return nil
+	case BreakerHalfOpen:
+		// another call probes the server
+		s.mu.Unlock()
+		return ErrCircuitOpen
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// result Update the health with the outcome of a read
+func (s *Supervisor) result(ctx context.Context, err error) {
+	s.mu.Lock()
+	t := s.record(err, "read")
+	s.mu.Unlock()
+	s.notify(ctx, t)
+}
+
+// command Fail all plants with ErrCircuitOpen if the breaker is open, otherwise run cmd
+func (s *Supervisor) command(ctx context.Context, PlantNo []uint8, cmd func() ([]bool, []error)) ([]bool, []error) {
+	if err := s.allow(ctx); err != nil {
+		errList := make([]error, len(PlantNo))
+		for i := range PlantNo {
+			errList[i] = err
+		}
+		return make([]bool, len(PlantNo)), errList
+	}
+	return cmd()
+}
+
+func (s *Supervisor) ServerAvailable(ctx context.Context) (bool, error) {
+	if err := s.allow(ctx); err != nil {
+		return false, err
+	}
+	available, err := s.Controller.ServerAvailable(ctx)
+	if err == nil && !available {
+		s.result(ctx, errors.New("server not running"))
+	} else {
+		s.result(ctx, err)
+	}
+	return available, err
+}
+
+func (s *Supervisor) Turbines(ctx context.Context) (TurbineInfo, error) {
+	if err := s.allow(ctx); err != nil {
+		return TurbineInfo{}, err
+	}
+	T, err := s.Controller.Turbines(ctx)
+	s.result(ctx, err)
+	return T, err
+}
+
+func (s *Supervisor) State(ctx context.Context, CtrlOrRbh string, PlantNo []uint8) ([]PlantState, error) {
+	if err := s.allow(ctx); err != nil {
+		return nil, err
+	}
+	states, err := s.Controller.State(ctx, CtrlOrRbh, PlantNo)
+	s.result(ctx, err)
+	return states, err
+}
+
+func (s *Supervisor) Start(ctx context.Context, UserId uint64, PlantNo ...uint8) ([]bool, []error) {
+	return s.command(ctx, PlantNo, func() ([]bool, []error) {
+		return s.Controller.Start(ctx, UserId, PlantNo...)
+	})
+}
+
+func (s *Supervisor) Stop(ctx context.Context, UserId uint64, FullStop bool, ForceExplicitCommand bool, PlantNo ...uint8) ([]bool, []error) {
+	return s.command(ctx, PlantNo, func() ([]bool, []error) {
+		return s.Controller.Stop(ctx, UserId, FullStop, ForceExplicitCommand, PlantNo...)
+	})
+}
+
+func (s *Supervisor) Reset(ctx context.Context, UserId uint64, PlantNo ...uint8) ([]bool, []error) {
+	return s.command(ctx, PlantNo, func() ([]bool, []error) {
+		return s.Controller.Reset(ctx, UserId, PlantNo...)
+	})
+}
+
+func (s *Supervisor) RbhOn(ctx context.Context, UserId uint64, PlantNo ...uint8) ([]bool, []error) {
+	return s.command(ctx, PlantNo, func() ([]bool, []error) {
+		return s.Controller.RbhOn(ctx, UserId, PlantNo...)
+	})
+}
+
+func (s *Supervisor) RbhAutoOff(ctx context.Context, UserId uint64, PlantNo ...uint8) ([]bool, []error) {
+	return s.command(ctx, PlantNo, func() ([]bool, []error) {
+		return s.Controller.RbhAutoOff(ctx, UserId, PlantNo...)
+	})
+}
+
+func (s *Supervisor) RbhStandard(ctx context.Context, UserId uint64, PlantNo ...uint8) ([]bool, []error) {
+	return s.command(ctx, PlantNo, func() ([]bool, []error) {
+		return s.Controller.RbhStandard(ctx, UserId, PlantNo...)
+	})
+}
+
+func (s *Supervisor) ControlAndRbh(ctx context.Context, UserId uint64, Values ControlAndRbhValue, PlantNo ...uint8) ([]bool, []error) {
+	return s.command(ctx, PlantNo, func() ([]bool, []error) {
+		return s.Controller.ControlAndRbh(ctx, UserId, Values, PlantNo...)
+	})
+}
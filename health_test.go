@@ -0,0 +1,54 @@
+package energontrol_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dernate/energontrol"
+	"github.com/dernate/energontrol/energontroltest"
+)
+
+func TestSupervisor(t *testing.T) {
+	scada := energontroltest.NewScada(4711, 1)
+	var transitions []energontrol.HealthTransition
+	s := &energontrol.Supervisor{Controller: scada, FailureThreshold: 2, OpenTimeout: 20 * time.Millisecond,
+		OnTransition: func(tr energontrol.HealthTransition) { transitions = append(transitions, tr) }}
+	ctx := context.Background()
+	if h := s.Check(ctx); !h.Available || h.Breaker != energontrol.BreakerClosed || h.Status.VendorInfo != "energontroltest" {
+		t.Errorf("Error: %+v", h)
+	}
+
+	scada.Err = errors.New("connection refused")
+	s.Check(ctx)
+	if _, err := s.State(ctx, "Ctrl", []uint8{1}); err == nil || errors.Is(err, energontrol.ErrCircuitOpen) {
+		t.Errorf("Error: %v", err)
+	}
+	if h := s.Health(); h.Breaker != energontrol.BreakerOpen || h.Failures != 2 || h.Available {
+		t.Errorf("Error: %+v", h)
+	}
+	// commands fail fast without reaching the server
+	scada.Err = nil
+	if _, errList := s.Start(ctx, 1234, 1); !errors.Is(errList[0], energontrol.ErrCircuitOpen) || len(scada.CallLog()) != 0 {
+		t.Errorf("Error: %v, %+v", errList, scada.CallLog())
+	}
+
+	// after OpenTimeout the next call probes the server and passes
+	time.Sleep(25 * time.Millisecond)
+	if ok, errList := s.Start(ctx, 1234, 1); !ok[0] || errList[0] != nil {
+		t.Errorf("Error: %v", errList)
+	}
+	want := []energontrol.BreakerState{energontrol.BreakerOpen, energontrol.BreakerHalfOpen, energontrol.BreakerClosed}
+	if len(transitions) != len(want) {
+		t.Fatalf("Error: %+v", transitions)
+	}
+	for i, tr := range transitions {
+		if tr.To != want[i] {
+			t.Errorf("Error: transition %d %+v", i, tr)
+		}
+	}
+	if got := s.Transitions(); len(got) != 3 || got[2] != transitions[2] {
+		t.Errorf("Error: %+v", got)
+	}
+}
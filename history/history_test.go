@@ -0,0 +1,156 @@
+package history
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dernate/energontrol"
+	"github.com/dernate/energontrol/energontroltest"
+)
+
+func TestRecorder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	scada := energontroltest.NewScada(4711, 1, 2)
+	r := &Recorder{Park: energontrol.ParkConfig{Name: "north"}, Controller: scada, Store: &Store{Path: path}}
+	t0 := time.Date(2026, 2, 1, 8, 0, 0, 0, time.UTC)
+	record := func(at time.Duration, want int) {
+		t.Helper()
+		changed, err := r.Record(context.Background(), t0.Add(at))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(changed) != want {
+			t.Errorf("Error at %s: %d records, want %d: %+v", at, len(changed), want, changed)
+		}
+	}
+	record(0, 2)
+	record(time.Minute, 0)
+	scada.SetPlant(2, energontroltest.Plant{Ctrl: energontrol.CtrlValues["Stop60"], Rbh: energontroltest.RbhStandardState, SessionState: 108})
+	record(2*time.Minute, 1)
+	scada.SetPlant(2, energontroltest.Plant{Ctrl: energontrol.CtrlValues["Stop60"], Rbh: energontroltest.RbhAutoOffState})
+	record(3*time.Minute, 1)
+
+	// a new recorder continues from the store
+	r = &Recorder{Park: energontrol.ParkConfig{Name: "north"}, Controller: scada, Store: &Store{Path: path}}
+	record(4*time.Minute, 0)
+
+	state, ok, err := r.Store.StateAt(2, t0.Add(150*time.Second))
+	if err != nil || !ok {
+		t.Fatal(ok, err)
+	}
+	if state.Ctrl != energontrol.CtrlValues["Stop60"] || state.Session == nil || *state.Session != 108 {
+		t.Errorf("Error: state at 2m30s %+v", state)
+	}
+	if _, ok, _ = r.Store.StateAt(2, t0.Add(-time.Second)); ok {
+		t.Errorf("Error: state before the first record")
+	}
+
+	transitions, err := r.Store.Transitions(t0.Add(time.Minute), t0.Add(time.Hour), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Transition{
+		{Time: t0.Add(2 * time.Minute), PlantNo: 2, Field: "Ctrl", From: 0, To: 1},
+		{Time: t0.Add(2 * time.Minute), PlantNo: 2, Field: "Session", From: 0, To: 108},
+		{Time: t0.Add(3 * time.Minute), PlantNo: 2, Field: "Rbh", From: energontroltest.RbhStandardState, To: energontroltest.RbhAutoOffState},
+		{Time: t0.Add(3 * time.Minute), PlantNo: 2, Field: "Session", From: 108, To: 0},
+	}
+	if len(transitions) != len(want) {
+		t.Fatalf("Error: transitions %+v", transitions)
+	}
+	for i := range want {
+		if !transitions[i].Time.Equal(want[i].Time) || transitions[i].Field != want[i].Field || transitions[i].From != want[i].From || transitions[i].To != want[i].To {
+			t.Errorf("Error: transition %d %+v, want %+v", i, transitions[i], want[i])
+		}
+	}
+
+	// an incomplete last line is ignored
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString(`{"time":"2026-02-01T`)
+	f.Close()
+	if latest, err := r.Store.Latest(); err != nil || len(latest) != 2 {
+		t.Errorf("Error: latest %+v, %v", latest, err)
+	}
+	// and cut off by the next record
+	scada.SetPlant(1, energontroltest.Plant{Ctrl: energontrol.CtrlValues["Stop90"], Rbh: energontroltest.RbhStandardState})
+	record(5*time.Minute, 1)
+	if state, ok, err = r.Store.StateAt(1, t0.Add(time.Hour)); err != nil || !ok || state.Ctrl != energontrol.CtrlValues["Stop90"] {
+		t.Errorf("Error: state %+v, %t, %v", state, ok, err)
+	}
+}
+
+// sessionFailer fails to read the session states while fail is set
+type sessionFailer struct {
+	*energontroltest.Scada
+	fail bool
+}
+
+func (s *sessionFailer) SessionState(ctx context.Context, CtrlOrReset string, PlantNo []uint8) ([]uint16, error) {
+	if s.fail {
+		return nil, errors.New("session state not readable")
+	}
+	return s.Scada.SessionState(ctx, CtrlOrReset, PlantNo)
+}
+
+func TestSessionRecorded(t *testing.T) {
+	scada := &sessionFailer{Scada: energontroltest.NewScada(4711, 1), fail: true}
+	r := &Recorder{Park: energontrol.ParkConfig{Name: "north"}, Controller: scada, Store: &Store{Path: filepath.Join(t.TempDir(), "history.jsonl")}}
+	t0 := time.Date(2026, 2, 1, 8, 0, 0, 0, time.UTC)
+	changed, err := r.Record(context.Background(), t0)
+	if err != nil || len(changed) != 1 || changed[0].Session != nil {
+		t.Fatalf("Error: %+v, %v", changed, err)
+	}
+	// the first readable session state is a change, an unreadable one isn't
+	scada.fail = false
+	if changed, err = r.Record(context.Background(), t0.Add(time.Minute)); err != nil || len(changed) != 1 || changed[0].Session == nil || *changed[0].Session != 0 {
+		t.Errorf("Error: %+v, %v", changed, err)
+	}
+	scada.fail = true
+	if changed, err = r.Record(context.Background(), t0.Add(2*time.Minute)); err != nil || len(changed) != 0 {
+		t.Errorf("Error: %+v, %v", changed, err)
+	}
+}
+
+func TestParks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	north := energontroltest.NewScada(4711, 1)
+	south := energontroltest.NewScada(4712, 1)
+	south.SetPlant(1, energontroltest.Plant{Ctrl: energontrol.CtrlValues["Stop90"], Rbh: energontroltest.RbhStandardState})
+	t0 := time.Date(2026, 2, 1, 8, 0, 0, 0, time.UTC)
+	for i, scada := range []*energontroltest.Scada{north, south} {
+		name := []string{"north", "south"}[i]
+		r := &Recorder{Park: energontrol.ParkConfig{Name: name}, Controller: scada, Store: &Store{Path: path}}
+		if changed, err := r.Record(context.Background(), t0.Add(time.Duration(i)*time.Minute)); err != nil || len(changed) != 1 || changed[0].Park != name {
+			t.Fatalf("Error: %s %+v, %v", name, changed, err)
+		}
+	}
+	// a new recorder of north continues from its own records, not the later ones of south
+	r := &Recorder{Park: energontrol.ParkConfig{Name: "north"}, Controller: north, Store: &Store{Path: path, Park: "north"}}
+	if changed, err := r.Record(context.Background(), t0.Add(2*time.Minute)); err != nil || len(changed) != 0 {
+		t.Errorf("Error: %+v, %v", changed, err)
+	}
+	state, ok, err := r.Store.StateAt(1, t0.Add(time.Hour))
+	if err != nil || !ok || state.Park != "north" || state.Ctrl != 0 {
+		t.Errorf("Error: state %+v, %t, %v", state, ok, err)
+	}
+	// records of north and south of the same plant aren't a transition
+	if transitions, err := (&Store{Path: path, Park: "south"}).Transitions(t0, t0.Add(time.Hour)); err != nil || len(transitions) != 0 {
+		t.Errorf("Error: transitions %+v, %v", transitions, err)
+	}
+
+	// the store of south refuses the records of north
+	r = &Recorder{Park: energontrol.ParkConfig{Name: "north"}, Controller: north, Store: &Store{Path: path, Park: "south"}}
+	if _, err = r.Record(context.Background(), t0.Add(3*time.Minute)); err == nil {
+		t.Errorf("Error: north recorded to the store of south")
+	}
+	if err = (&Store{Path: path, Park: "south"}).Append(Record{Time: t0, Park: "north", PlantNo: 1}); err == nil {
+		t.Errorf("Error: appended a record of north to the store of south")
+	}
+}
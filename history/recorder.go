@@ -0,0 +1,142 @@
+// Package history records the Ctrl, Rbh and session state of plants to a local append-only Store, so incident reviews
+// don't depend on the SCADA's own history. The Recorder only reads from the SCADA.
+//
+// A Record is written for a plant, when one of its states changed since the last record, so the state of a plant at
+// any time is its last record before. Records carry the name of the park, so the Store of a park can't mix in others. Session states are recorded, if the Controller is an energontrol.SessionStater.
+package history
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/dernate/energontrol"
+)
+
+// Recorder polls the states of the plants of one park
+type Recorder struct {
+	Park       energontrol.ParkConfig
+	Controller energontrol.Controller
+	Store      *Store
+	PlantNo    []uint8       // plants to record, all allowed turbines with Ctrl if empty
+	Interval   time.Duration // polling interval of Run, 1m if 0
+
+	mu   sync.Mutex
+	last map[uint8]Record
+}
+
+// Run Record every Interval until ctx is done
+func (r *Recorder) Run(ctx context.Context) error {
+	interval := r.Interval
+	if interval == 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := r.Record(ctx, time.Now()); err != nil {
+			r.log(ctx, slog.LevelError, 0, err.Error())
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Record Read the states of the plants and store the changed ones with time now
+func (r *Recorder) Record(ctx context.Context, now time.Time) ([]Record, error) {
+	if err := r.init(ctx); err != nil {
+		return nil, err
+	}
+	pctx := r.Park.Context(ctx)
+	ctrl, err := r.Controller.State(pctx, "Ctrl", r.PlantNo)
+	if err != nil {
+		return nil, fmt.Errorf("read Ctrl state: %w", err)
+	}
+	rbh, err := r.Controller.State(pctx, "Rbh", r.PlantNo)
+	if err != nil {
+		return nil, fmt.Errorf("read Rbh state: %w", err)
+	}
+	var session []uint16
+	if s, ok := r.Controller.(energontrol.SessionStater); ok {
+		if session, err = s.SessionState(pctx, "Ctrl", r.PlantNo); err != nil {
+			// Ctrl and Rbh are still worth recording
+			r.log(ctx, slog.LevelWarn, 0, fmt.Sprintf("session states not recorded: %s", err))
+			session = nil
+		}
+	}
+	var changed []Record
+	r.mu.Lock()
+	for i, plant := range r.PlantNo {
+		if i >= len(ctrl) || i >= len(rbh) {
+			break
+		}
+		if err := errors.Join(ctrl[i].Err, rbh[i].Err); err != nil {
+			r.log(ctx, slog.LevelWarn, plant, fmt.Sprintf("state not recorded: %s", err))
+			continue
+		}
+		rec := Record{Time: now, Park: r.Park.Name, PlantNo: plant, Ctrl: ctrl[i].CtrlState, Rbh: rbh[i].CtrlState}
+		if i < len(session) {
+			rec.Session = &session[i]
+		}
+		if last, ok := r.last[plant]; ok && last.Ctrl == rec.Ctrl && last.Rbh == rec.Rbh && sameSession(last.Session, rec.Session) {
+			continue
+		}
+		changed = append(changed, rec)
+	}
+	r.mu.Unlock()
+	if err = r.Store.Append(changed...); err != nil {
+		return nil, fmt.Errorf("history store: %w", err)
+	}
+	r.mu.Lock()
+	for _, rec := range changed {
+		r.last[rec.PlantNo] = rec
+	}
+	r.mu.Unlock()
+	return changed, nil
+}
+
+// sameSession Compare the last session state with the read one. A state read after none was recorded is a change,
+// a state, which couldn't be read, isn't.
+func sameSession(last *uint16, read *uint16) bool {
+	return read == nil || last != nil && *last == *read
+}
+
+// init Load the last records from the store and find the plants to record
+func (r *Recorder) init(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.Store.Park != "" && r.Store.Park != r.Park.Name {
+		return fmt.Errorf("history store %s is of park %q, not %q", r.Store.Path, r.Store.Park, r.Park.Name)
+	}
+	if r.last == nil {
+		last, err := r.Store.latest(r.Park.Name)
+		if err != nil {
+			return fmt.Errorf("history store: %w", err)
+		}
+		r.last = last
+	}
+	if len(r.PlantNo) > 0 {
+		return nil
+	}
+	T, err := r.Controller.Turbines(r.Park.Context(ctx))
+	if err != nil {
+		return err
+	}
+	for _, plant := range T.PlantNo {
+		if T.Ctrl[plant] && r.Park.PlantAllowed(plant) {
+			r.PlantNo = append(r.PlantNo, plant)
+		}
+	}
+	return nil
+}
+
+func (r *Recorder) log(ctx context.Context, level slog.Level, PlantNo uint8, msg string) {
+	ctx = r.Park.Context(ctx)
+	energontrol.Logger(ctx).LogAttrs(ctx, level, msg, slog.Int(energontrol.LogKeyPlantNo, int(PlantNo)), slog.String(energontrol.LogKeyAction, "History"))
+}
@@ -0,0 +1,195 @@
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Record the state of a plant of Park at Time. Session is nil if the Controller can't read session states.
+type Record struct {
+	Time    time.Time `json:"time"`
+	Park    string    `json:"park,omitempty"` // name of the park, empty in records written without one
+	PlantNo uint8     `json:"plant_no"`
+	Ctrl    uint64    `json:"ctrl"`
+	Rbh     uint64    `json:"rbh"`
+	Session *uint16   `json:"session,omitempty"`
+}
+
+// Transition a change of Ctrl, Rbh or Session of a plant
+type Transition struct {
+	Time    time.Time `json:"time"`
+	PlantNo uint8     `json:"plant_no"`
+	Field   string    `json:"field"` // Ctrl, Rbh or Session
+	From    uint64    `json:"from"`
+	To      uint64    `json:"to"`
+}
+
+// Store an append-only file with one Record as JSON per line, in order of time. A Store with a Park only reads the
+// records of this park and records without a park, and refuses to append records of another park.
+type Store struct {
+	Path string
+	Park string
+
+	mu sync.Mutex
+}
+
+// Append Write records to the end of the file
+func (s *Store) Append(records ...Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+	var data []byte
+	for _, r := range records {
+		if s.Park != "" && r.Park != s.Park {
+			return fmt.Errorf("record of park %q in the store %s of park %q", r.Park, s.Path, s.Park)
+		}
+		line, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		data = append(append(data, line...), '\n')
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(s.Path, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return err
+	}
+	end, err := completeEnd(f)
+	if err == nil {
+		_, err = f.WriteAt(data, end)
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// completeEnd Get the end of the last complete line and cut off an incomplete one
+func completeEnd(f *os.File) (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	end := info.Size()
+	buf := make([]byte, 4096)
+	for end > 0 {
+		n := min(int64(len(buf)), end)
+		if _, err = f.ReadAt(buf[:n], end-n); err != nil {
+			return 0, err
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			end = end - n + int64(i) + 1
+			break
+		}
+		end -= n
+	}
+	if end < info.Size() {
+		return end, f.Truncate(end)
+	}
+	return end, nil
+}
+
+// scan Call f for every record of Park until f returns false, for all records if Park is empty. An incomplete last line,
+// e.g. after a crash while writing, is ignored and cut off by the next Append.
+func (s *Store) scan(Park string, f func(r Record) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	file, err := os.Open(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	for lineNo := 1; ; lineNo++ {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		var r Record
+		if err = json.Unmarshal(line, &r); err != nil {
+			return fmt.Errorf("%s line %d: %w", s.Path, lineNo, err)
+		}
+		if Park != "" && r.Park != "" && r.Park != Park {
+			continue
+		}
+		if !f(r) {
+			return nil
+		}
+	}
+}
+
+// Latest Get the last record of every plant
+func (s *Store) Latest() (map[uint8]Record, error) {
+	return s.latest(s.Park)
+}
+
+// latest Get the last record of every plant of Park
+func (s *Store) latest(Park string) (map[uint8]Record, error) {
+	latest := make(map[uint8]Record)
+	err := s.scan(Park, func(r Record) bool {
+		latest[r.PlantNo] = r
+		return true
+	})
+	return latest, err
+}
+
+// StateAt Get the state of a plant at t, which is its last record not after t. ok is false, if there is none.
+func (s *Store) StateAt(PlantNo uint8, t time.Time) (state Record, ok bool, err error) {
+	err = s.scan(s.Park, func(r Record) bool {
+		if r.Time.After(t) {
+			return false
+		}
+		if r.PlantNo == PlantNo {
+			state, ok = r, true
+		}
+		return true
+	})
+	return state, ok, err
+}
+
+// Transitions Get all changes from From to To (both included) of the plants, of all plants if PlantNo is empty
+func (s *Store) Transitions(From time.Time, To time.Time, PlantNo ...uint8) ([]Transition, error) {
+	wanted := make(map[uint8]bool)
+	for _, plant := range PlantNo {
+		wanted[plant] = true
+	}
+	last := make(map[uint8]Record)
+	var transitions []Transition
+	err := s.scan(s.Park, func(r Record) bool {
+		if r.Time.After(To) {
+			return false
+		}
+		if len(wanted) > 0 && !wanted[r.PlantNo] {
+			return true
+		}
+		prev, known := last[r.PlantNo]
+		last[r.PlantNo] = r
+		if !known || r.Time.Before(From) {
+			return true
+		}
+		if prev.Ctrl != r.Ctrl {
+			transitions = append(transitions, Transition{Time: r.Time, PlantNo: r.PlantNo, Field: "Ctrl", From: prev.Ctrl, To: r.Ctrl})
+		}
+		if prev.Rbh != r.Rbh {
+			transitions = append(transitions, Transition{Time: r.Time, PlantNo: r.PlantNo, Field: "Rbh", From: prev.Rbh, To: r.Rbh})
+		}
+		if prev.Session != nil && r.Session != nil && *prev.Session != *r.Session {
+			transitions = append(transitions, Transition{Time: r.Time, PlantNo: r.PlantNo, Field: "Session", From: uint64(*prev.Session), To: uint64(*r.Session)})
+		}
+		return true
+	})
+	return transitions, err
+}
@@ -0,0 +1,447 @@
+// Package httpapi exposes energontrol turbine control as an HTTP/JSON API.
+//
+// Routes:
+//
+//	GET  /parks
+//	GET  /parks/{park}/turbines
+//	GET  /parks/{park}/plants/{n}/state
+//	POST /parks/{park}/plants/{n}/{action}  action is start, stop, reset or rbh
+//	POST /parks/{park}/jobs                 asynchronous command for several plants
+//	GET  /jobs/{id}
+//
+// Every request needs "Authorization: Bearer <token>". The token selects the API user. Its Enercon UserId for a park comes
+// from Handler.Credentials, or else from the user_id of the user, and is written to the audit log with ResolveOperator.
+// A job can only be read by the user who created it and by admins.
+// A request id is taken from the X-Request-ID header or generated, returned in the response and written to the log.
+package httpapi
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dernate/energontrol"
+)
+
+// Park a park served by the API
+type Park struct {
+	Config     energontrol.ParkConfig
+	Controller energontrol.Controller
+}
+
+// User an API user and the Enercon UserId used for its commands
+type User struct {
+	Name     string `yaml:"name"`
+	Token    string `yaml:"token"`
+	TokenEnv string `yaml:"token_env"` // read the token from this environment variable instead
+	UserId   uint64 `yaml:"user_id"`   // used, if Handler.Credentials doesn't know the user
+	Admin    bool   `yaml:"admin"`     // may read the jobs of all users
+}
+
+// Command a control command, as request body of the plant and job routes
+type Command struct {
+	Action string  `json:"action,omitempty"` // start, stop, reset or rbh; only for jobs
+	Plants []uint8 `json:"plants,omitempty"` // only for jobs
+	Full   bool    `json:"full,omitempty"`   // stop: Stop90 instead of Stop60
+	Force  bool    `json:"force,omitempty"`  // stop: ForceExplicitCommand
+	Mode   string  `json:"mode,omitempty"`   // rbh: on, auto-off or standard
+}
+
+// PlantResult result of a command for one plant
+type PlantResult struct {
+	PlantNo uint8  `json:"plant_no"`
+	Ok      bool   `json:"ok"`
+	Error   string `json:"error,omitempty"`
+}
+
+// PlantStateResponse decoded Ctrl and Rbh state of a plant
+type PlantStateResponse struct {
+	PlantNo  uint8    `json:"plant_no"`
+	Ctrl     uint64   `json:"ctrl"`
+	CtrlText string   `json:"ctrl_text"`
+	Rbh      uint64   `json:"rbh"`
+	RbhFlags []string `json:"rbh_flags"`
+}
+
+type ctxKey int
+
+const (
+	requestIdKey ctxKey = iota
+	userKey
+)
+
+// DefaultCommandTimeout limit of a synchronous plant command, used if Handler.CommandTimeout is 0
+const DefaultCommandTimeout = 2 * time.Minute
+
+// Handler serves the API
+type Handler struct {
+	// CommandTimeout limits a synchronous plant command. The command isn't cancelled, if the client disconnects,
+	// so a session handshake is never left half done.
+	CommandTimeout time.Duration
+	// Credentials resolves the UserId of an API user per park, by the user's name as operator.
+	// Users it doesn't know, or all users if it is nil, use their user_id.
+	Credentials energontrol.CredentialProvider
+
+	parks map[string]Park
+	users []User
+	jobs  *jobStore
+	mux   *http.ServeMux
+	wg    sync.WaitGroup
+}
+
+// NewHandler Create the API for parks and users. Every user needs a name and a unique token.
+func NewHandler(parks []Park, users []User) (*Handler, error) {
+	h := &Handler{
+		parks: make(map[string]Park),
+		jobs:  newJobStore(),
+		mux:   http.NewServeMux(),
+	}
+	for _, p := range parks {
+		if p.Controller == nil {
+			return nil, fmt.Errorf("park %q has no controller", p.Config.Name)
+		}
+		h.parks[p.Config.Name] = p
+	}
+	tokens := make(map[string]string)
+	for _, u := range users {
+		if u.Name == "" || u.Token == "" {
+			return nil, fmt.Errorf("user %q needs name and token", u.Name)
+		}
+		if other, ok := tokens[u.Token]; ok {
+			return nil, fmt.Errorf("users %q and %q have the same token", other, u.Name)
+		}
+		tokens[u.Token] = u.Name
+		h.users = append(h.users, u)
+	}
+	h.mux.HandleFunc("GET /parks", h.listParks)
+	h.mux.HandleFunc("GET /parks/{park}/turbines", h.turbines)
+	h.mux.HandleFunc("GET /parks/{park}/plants/{n}/state", h.plantState)
+	h.mux.HandleFunc("POST /parks/{park}/plants/{n}/{action}", h.plantCommand)
+	h.mux.HandleFunc("POST /parks/{park}/jobs", h.createJob)
+	h.mux.HandleFunc("GET /jobs/{id}", h.getJob)
+	return h, nil
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	requestId := r.Header.Get("X-Request-ID")
+	if requestId == "" {
+		requestId = newId()
+	}
+	w.Header().Set("X-Request-ID", requestId)
+	ctx := context.WithValue(r.Context(), requestIdKey, requestId)
+	user, ok := h.authenticate(r)
+	if !ok {
+		writeError(w, ctx, http.StatusUnauthorized, errors.New("missing or invalid token"))
+		return
+	}
+	ctx = context.WithValue(ctx, userKey, user)
+	ctx = energontrol.WithOperator(ctx, user.Name)
+	h.mux.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// Wait Block until all running jobs are finished
+func (h *Handler) Wait() {
+	h.wg.Wait()
+}
+
+func (h *Handler) authenticate(r *http.Request) (User, bool) {
+	auth := r.Header.Get("Authorization")
+	const prefix = "Bearer "
+	if len(auth) <= len(prefix) || auth[:len(prefix)] != prefix {
+		return User{}, false
+	}
+	token := []byte(auth[len(prefix):])
+	for _, u := range h.users {
+		if subtle.ConstantTimeCompare(token, []byte(u.Token)) == 1 {
+			return u, true
+		}
+	}
+	return User{}, false
+}
+
+func (h *Handler) listParks(w http.ResponseWriter, r *http.Request) {
+	var names []string
+	for name := range h.parks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	writeJSON(w, http.StatusOK, names)
+}
+
+func (h *Handler) park(w http.ResponseWriter, r *http.Request) (Park, bool) {
+	p, ok := h.parks[r.PathValue("park")]
+	if !ok {
+		writeError(w, r.Context(), http.StatusNotFound, fmt.Errorf("park %q not found", r.PathValue("park")))
+	}
+	return p, ok
+}
+
+func (h *Handler) plant(w http.ResponseWriter, r *http.Request, p Park) (uint8, bool) {
+	n, err := strconv.ParseUint(r.PathValue("n"), 10, 8)
+	if err != nil {
+		writeError(w, r.Context(), http.StatusBadRequest, fmt.Errorf("invalid plant number %q", r.PathValue("n")))
+		return 0, false
+	}
+	if !p.Config.PlantAllowed(uint8(n)) {
+		writeError(w, r.Context(), http.StatusForbidden, fmt.Errorf("plant %d is not allowed in park %q", n, p.Config.Name))
+		return 0, false
+	}
+	return uint8(n), true
+}
+
+func (h *Handler) turbines(w http.ResponseWriter, r *http.Request) {
+	p, ok := h.park(w, r)
+	if !ok {
+		return
+	}
+	T, err := p.Controller.Turbines(p.Config.Context(r.Context()))
+	if err != nil {
+		writeError(w, r.Context(), http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, T)
+}
+
+func (h *Handler) plantState(w http.ResponseWriter, r *http.Request) {
+	p, ok := h.park(w, r)
+	if !ok {
+		return
+	}
+	plant, ok := h.plant(w, r, p)
+	if !ok {
+		return
+	}
+	ctx := p.Config.Context(r.Context())
+	CtrlState, err := p.Controller.State(ctx, "Ctrl", []uint8{plant})
+	if err != nil {
+		writeError(w, r.Context(), http.StatusBadGateway, err)
+		return
+	}
+	RbhState, err := p.Controller.State(ctx, "Rbh", []uint8{plant})
+	if err == nil {
+		err = errors.Join(CtrlState[0].Err, RbhState[0].Err)
+	}
+	if err != nil {
+		writeError(w, r.Context(), http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, PlantStateResponse{
+		PlantNo:  plant,
+		Ctrl:     CtrlState[0].CtrlState,
+		CtrlText: energontrol.CtrlStateText(CtrlState[0].CtrlState),
+		Rbh:      RbhState[0].CtrlState,
+		RbhFlags: energontrol.RbhStateText(RbhState[0].CtrlState),
+	})
+}
+
+func (h *Handler) plantCommand(w http.ResponseWriter, r *http.Request) {
+	p, ok := h.park(w, r)
+	if !ok {
+		return
+	}
+	plant, ok := h.plant(w, r, p)
+	if !ok {
+		return
+	}
+	var cmd Command
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&cmd); err != nil {
+			writeError(w, r.Context(), http.StatusBadRequest, fmt.Errorf("invalid body: %w", err))
+			return
+		}
+	}
+	cmd.Action = r.PathValue("action")
+	cmd.Plants = []uint8{plant}
+	if err := cmd.validate(); err != nil {
+		writeError(w, r.Context(), http.StatusBadRequest, err)
+		return
+	}
+	timeout := h.CommandTimeout
+	if timeout == 0 {
+		timeout = DefaultCommandTimeout
+	}
+	ctx, cancel := context.WithTimeout(p.Config.Context(context.WithoutCancel(r.Context())), timeout)
+	defer cancel()
+	user := userFrom(r.Context())
+	ctx, UserId, err := h.userId(ctx, p, user)
+	if err != nil {
+		writeError(w, r.Context(), http.StatusForbidden, err)
+		return
+	}
+	results := execute(ctx, p, user, UserId, requestIdFrom(r.Context()), cmd)
+	status := http.StatusOK
+	if !results[0].Ok {
+		status = http.StatusBadGateway
+	}
+	writeJSON(w, status, results[0])
+}
+
+func (h *Handler) createJob(w http.ResponseWriter, r *http.Request) {
+	p, ok := h.park(w, r)
+	if !ok {
+		return
+	}
+	var cmd Command
+	if err := json.NewDecoder(r.Body).Decode(&cmd); err != nil {
+		writeError(w, r.Context(), http.StatusBadRequest, fmt.Errorf("invalid body: %w", err))
+		return
+	}
+	if err := cmd.validate(); err != nil {
+		writeError(w, r.Context(), http.StatusBadRequest, err)
+		return
+	}
+	if len(cmd.Plants) == 0 {
+		writeError(w, r.Context(), http.StatusBadRequest, errors.New("no plants provided"))
+		return
+	}
+	for _, plant := range cmd.Plants {
+		if !p.Config.PlantAllowed(plant) {
+			writeError(w, r.Context(), http.StatusForbidden, fmt.Errorf("plant %d is not allowed in park %q", plant, p.Config.Name))
+			return
+		}
+	}
+	user := userFrom(r.Context())
+	requestId := requestIdFrom(r.Context())
+	// the job outlives the request, but keeps its operator and log attributes
+	ctx, UserId, err := h.userId(p.Config.Context(context.WithoutCancel(r.Context())), p, user)
+	if err != nil {
+		writeError(w, r.Context(), http.StatusForbidden, err)
+		return
+	}
+	job := h.jobs.create(p.Config.Name, user.Name, requestId, cmd)
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		h.jobs.update(job.Id, func(j *Job) { j.Status = JobRunning })
+		results := execute(ctx, p, user, UserId, requestId, cmd)
+		h.jobs.finish(job.Id, results)
+	}()
+	w.Header().Set("Location", "/jobs/"+job.Id)
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+func (h *Handler) getJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.jobs.get(r.PathValue("id"))
+	// other users' jobs are reported as missing, so their ids aren't confirmed either
+	if user := userFrom(r.Context()); ok && job.User != user.Name && !user.Admin {
+		ok = false
+	}
+	if !ok {
+		writeError(w, r.Context(), http.StatusNotFound, fmt.Errorf("job %q not found", r.PathValue("id")))
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+func (cmd Command) validate() error {
+	switch cmd.Action {
+	case "start", "stop", "reset":
+	case "rbh":
+		if cmd.Mode != "on" && cmd.Mode != "auto-off" && cmd.Mode != "standard" {
+			return fmt.Errorf("rbh mode must be on, auto-off or standard, not %q", cmd.Mode)
+		}
+	default:
+		return fmt.Errorf("unknown action %q", cmd.Action)
+	}
+	return nil
+}
+
+// energontrolAction Get the energontrol action for the validated command
+func (cmd Command) energontrolAction() string {
+	switch cmd.Action {
+	case "start":
+		return energontrol.ActionStart
+	case "stop":
+		if cmd.Full {
+			return energontrol.ActionStop90
+		}
+		return energontrol.ActionStop60
+	case "reset":
+		return energontrol.ActionReset
+	}
+	switch cmd.Mode {
+	case "on":
+		return energontrol.ActionRbhOn
+	case "auto-off":
+		return energontrol.ActionRbhAutoOff
+	}
+	return energontrol.ActionRbhStandard
+}
+
+// userId Resolve the UserId of the API user for the park, first with h.Credentials, then from the user's user_id.
+// ResolveOperator records it in the audit log.
+func (h *Handler) userId(ctx context.Context, p Park, user User) (context.Context, uint64, error) {
+	var providers energontrol.ChainCredentials
+	if h.Credentials != nil {
+		providers = append(providers, h.Credentials)
+	}
+	if user.UserId != 0 {
+		providers = append(providers, energontrol.CredentialFunc(func(context.Context, string, string) (uint64, error) {
+			return user.UserId, nil
+		}))
+	}
+	return energontrol.ResolveOperator(ctx, providers, p.Config.Name, user.Name)
+}
+
+// execute Run the command for all plants of cmd with UserId and log who triggered it
+func execute(ctx context.Context, p Park, user User, UserId uint64, requestId string, cmd Command) []PlantResult {
+	ctx = energontrol.WithLogAttrs(ctx, slog.String(energontrol.LogKeyRequestId, requestId))
+	ok, errList := energontrol.Do(ctx, p.Controller, UserId, cmd.energontrolAction(), cmd.Force, cmd.Plants...)
+	results := make([]PlantResult, len(cmd.Plants))
+	for i, plant := range cmd.Plants {
+		results[i].PlantNo = plant
+		if i < len(ok) {
+			results[i].Ok = ok[i]
+		}
+		if i < len(errList) && errList[i] != nil {
+			results[i].Error = errList[i].Error()
+		}
+		msg := fmt.Sprintf("%s by API user %s: ok=%t", cmd.Action, user.Name, results[i].Ok)
+		attrs := []slog.Attr{slog.Int(energontrol.LogKeyPlantNo, int(plant)), slog.String(energontrol.LogKeyAction, "API")}
+		if results[i].Error != "" {
+			energontrol.Logger(ctx).LogAttrs(ctx, slog.LevelWarn, msg+", "+results[i].Error, attrs...)
+		} else {
+			energontrol.Logger(ctx).LogAttrs(ctx, slog.LevelInfo, msg, attrs...)
+		}
+	}
+	return results
+}
+
+func userFrom(ctx context.Context) User {
+	u, _ := ctx.Value(userKey).(User)
+	return u
+}
+
+func requestIdFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdKey).(string)
+	return id
+}
+
+func newId() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, ctx context.Context, status int, err error) {
+	writeJSON(w, status, map[string]string{
+		"error":      err.Error(),
+		"request_id": requestIdFrom(ctx),
+	})
+}
@@ -0,0 +1,258 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dernate/energontrol"
+	"github.com/dernate/energontrol/energontroltest"
+)
+
+func newTestHandler(t *testing.T) (*Handler, *energontroltest.Scada) {
+	scada := energontroltest.NewScada(1234, 1, 2, 3)
+	h, err := NewHandler([]Park{{
+		Config:     energontrol.ParkConfig{Name: "north", Plants: []uint8{1, 2, 3}},
+		Controller: scada,
+	}}, []User{
+		{Name: "alice", Token: "secret", UserId: 4711},
+		{Name: "bob", Token: "bob-secret", UserId: 4712},
+		{Name: "ops", Token: "ops-secret", UserId: 4713, Admin: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h, scada
+}
+
+func do(h http.Handler, method string, path string, body string) *httptest.ResponseRecorder {
+	return doAs(h, "secret", method, path, body)
+}
+
+func doAs(h http.Handler, token string, method string, path string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer "+token)
+	r.Header.Set("X-Request-ID", "req-1")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestAuth(t *testing.T) {
+	h, _ := newTestHandler(t)
+	r := httptest.NewRequest("GET", "/parks", nil)
+	r.Header.Set("Authorization", "Bearer wrong")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized || w.Header().Get("X-Request-ID") == "" {
+		t.Errorf("Error: %d ; %q", w.Code, w.Header().Get("X-Request-ID"))
+	}
+}
+
+func TestPlantCommand(t *testing.T) {
+	h, scada := newTestHandler(t)
+	w := do(h, "POST", "/parks/north/plants/2/stop", `{"full": true}`)
+	if w.Code != http.StatusOK || w.Header().Get("X-Request-ID") != "req-1" {
+		t.Fatalf("Error: %d %s", w.Code, w.Body)
+	}
+	if scada.Plant(2).Ctrl != 2 {
+		t.Errorf("Error: plant 2 not stopped, Ctrl %d", scada.Plant(2).Ctrl)
+	}
+	if calls := scada.CallLog(); len(calls) != 1 || calls[0].UserId != 4711 {
+		t.Errorf("Error: %v", calls)
+	}
+	w = do(h, "GET", "/parks/north/plants/2/state", "")
+	var state PlantStateResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &state); err != nil || state.CtrlText != "Stop90" {
+		t.Errorf("Error: %v ; %s", err, w.Body)
+	}
+	if w = do(h, "POST", "/parks/north/plants/9/start", ""); w.Code != http.StatusForbidden {
+		t.Errorf("Error: plant 9 not rejected, %d", w.Code)
+	}
+	if w = do(h, "POST", "/parks/north/plants/1/rbh", `{"mode": "hot"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Error: rbh mode not rejected, %d", w.Code)
+	}
+}
+
+func TestJob(t *testing.T) {
+	h, scada := newTestHandler(t)
+	scada.SetPlant(3, energontroltest.Plant{SessionState: 108})
+	w := do(h, "POST", "/parks/north/jobs", `{"action": "rbh", "mode": "on", "plants": [1, 3]}`)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Error: %d %s", w.Code, w.Body)
+	}
+	var job Job
+	if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+		t.Fatal(err)
+	}
+	h.Wait()
+	deadline := time.Now().Add(time.Second)
+	for job.Finished == nil && time.Now().Before(deadline) {
+		w = do(h, "GET", "/jobs/"+job.Id, "")
+		if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if job.Status != JobFailed || len(job.Results) != 2 || !job.Results[0].Ok || job.Results[1].Ok {
+		t.Errorf("Error: %+v", job)
+	}
+	if scada.Plant(1).Rbh != energontroltest.RbhOnState {
+		t.Errorf("Error: Rbh of plant 1 is %d", scada.Plant(1).Rbh)
+	}
+}
+
+func TestJobOperator(t *testing.T) {
+	h, scada := newTestHandler(t)
+	scada.SetPlant(2, energontroltest.Plant{SessionState: 175})
+	w := do(h, "POST", "/parks/north/jobs", `{"action": "start", "plants": [2]}`)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Error: %d %s", w.Code, w.Body)
+	}
+	var job Job
+	if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+		t.Fatal(err)
+	}
+	h.Wait()
+	if err := json.Unmarshal(do(h, "GET", "/jobs/"+job.Id, "").Body.Bytes(), &job); err != nil {
+		t.Fatal(err)
+	}
+	// the CredentialError of the job names the API user as operator
+	if len(job.Results) != 1 || !strings.Contains(job.Results[0].Error, "operator alice") {
+		t.Errorf("Error: %+v", job)
+	}
+}
+
+func TestJobOwner(t *testing.T) {
+	h, _ := newTestHandler(t)
+	w := do(h, "POST", "/parks/north/jobs", `{"action": "start", "plants": [1]}`)
+	var job Job
+	if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+		t.Fatal(err)
+	}
+	h.Wait()
+	// only the owner and admins see the job
+	for token, want := range map[string]int{"secret": http.StatusOK, "bob-secret": http.StatusNotFound, "ops-secret": http.StatusOK} {
+		if w = doAs(h, token, "GET", "/jobs/"+job.Id, ""); w.Code != want {
+			t.Errorf("Error: %s got %d, want %d", token, w.Code, want)
+		}
+	}
+}
+
+func TestDuplicateToken(t *testing.T) {
+	_, err := NewHandler(nil, []User{{Name: "alice", Token: "secret", UserId: 1}, {Name: "bob", Token: "secret", UserId: 2}})
+	if err == nil || !strings.Contains(err.Error(), "same token") {
+		t.Errorf("Error: %v", err)
+	}
+}
+
+// ctxController records the context error seen by Stop
+type ctxController struct {
+	energontrol.Controller
+	err error
+}
+
+func (c *ctxController) Stop(ctx context.Context, UserId uint64, FullStop bool, ForceExplicitCommand bool, PlantNo ...uint8) ([]bool, []error) {
+	c.err = ctx.Err()
+	return c.Controller.Stop(ctx, UserId, FullStop, ForceExplicitCommand, PlantNo...)
+}
+
+func TestPlantCommandDisconnect(t *testing.T) {
+	c := &ctxController{Controller: energontroltest.NewScada(1234, 1)}
+	h, err := NewHandler([]Park{{Config: energontrol.ParkConfig{Name: "north"}, Controller: c}},
+		[]User{{Name: "alice", Token: "secret", UserId: 4711}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the client is gone, the session handshake is done anyway
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := httptest.NewRequest("POST", "/parks/north/plants/1/stop", nil).WithContext(ctx)
+	r.Header.Set("Authorization", "Bearer secret")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if c.err != nil {
+		t.Errorf("Error: command ran with %v", c.err)
+	}
+}
+
+func TestCredentials(t *testing.T) {
+	scada := energontroltest.NewScada(1234, 1, 2, 3)
+	h, err := NewHandler([]Park{{
+		Config:     energontrol.ParkConfig{Name: "north", Plants: []uint8{1, 2, 3}},
+		Controller: scada,
+	}}, []User{
+		{Name: "alice", Token: "secret", UserId: 4711},
+		{Name: "bob", Token: "bob-secret", UserId: 4712},
+		{Name: "carol", Token: "carol-secret"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Credentials = energontrol.CredentialFile{
+		Operators: map[string]energontrol.UserIdSource{"alice": {Value: 5000}},
+		Parks:     map[string]map[string]energontrol.UserIdSource{"north": {"alice": {Value: 5001}}},
+	}
+	var logs strings.Builder
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+	doLogged := func(token string, method string, path string, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r = r.WithContext(energontrol.WithLogger(r.Context(), logger))
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	// the park's UserId of the credentials wins over the user_id, bob isn't in the credentials
+	if w := doLogged("secret", "POST", "/parks/north/plants/1/start", ""); w.Code != http.StatusOK {
+		t.Errorf("Error: %d %s", w.Code, w.Body)
+	}
+	if w := doLogged("bob-secret", "POST", "/parks/north/plants/1/start", ""); w.Code != http.StatusOK {
+		t.Errorf("Error: %d %s", w.Code, w.Body)
+	}
+	if calls := scada.CallLog(); len(calls) != 2 || calls[0].UserId != 5001 || calls[1].UserId != 4712 {
+		t.Errorf("Error: %+v", calls)
+	}
+	for _, line := range []string{"operator alice uses UserId 5001", "operator bob uses UserId 4712"} {
+		if !strings.Contains(logs.String(), line) {
+			t.Errorf("Error: audit log misses %q: %s", line, logs.String())
+		}
+	}
+	// a user without any UserId can't send commands
+	if w := doLogged("carol-secret", "POST", "/parks/north/plants/1/start", ""); w.Code != http.StatusForbidden {
+		t.Errorf("Error: %d %s", w.Code, w.Body)
+	}
+	if w := doLogged("carol-secret", "POST", "/parks/north/jobs", `{"action": "start", "plants": [1]}`); w.Code != http.StatusForbidden {
+		t.Errorf("Error: %d %s", w.Code, w.Body)
+	}
+	if len(scada.CallLog()) != 2 {
+		t.Errorf("Error: command of carol sent")
+	}
+
+	// the SCADA rejecting the resolved UserId comes back as CredentialError
+	scada.SetPlant(2, energontroltest.Plant{SessionState: 175})
+	w := doLogged("secret", "POST", "/parks/north/plants/2/start", "")
+	var result PlantResult
+	if err = json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusBadGateway || !strings.Contains(result.Error, "UserId 5001 of operator alice has insufficient rights") {
+		t.Errorf("Error: %d %+v", w.Code, result)
+	}
+	scada.SetPlant(3, energontroltest.Plant{SessionState: 174})
+	w = doLogged("secret", "POST", "/parks/north/jobs", `{"action": "start", "plants": [3]}`)
+	var job Job
+	if err = json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+		t.Fatal(err)
+	}
+	h.Wait()
+	if err = json.Unmarshal(doLogged("secret", "GET", "/jobs/"+job.Id, "").Body.Bytes(), &job); err != nil {
+		t.Fatal(err)
+	}
+	if len(job.Results) != 1 || !strings.Contains(job.Results[0].Error, "UserId 5001 of operator alice is unknown to the SCADA") {
+		t.Errorf("Error: %+v", job)
+	}
+}
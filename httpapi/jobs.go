@@ -0,0 +1,96 @@
+package httpapi
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	JobPending  = "pending"
+	JobRunning  = "running"
+	JobDone     = "done"
+	JobFailed   = "failed" // at least one plant failed
+	jobsToStore = 1000
+)
+
+// Job an asynchronous command for several plants
+type Job struct {
+	Id        string        `json:"id"`
+	Park      string        `json:"park"`
+	User      string        `json:"user"`
+	RequestId string        `json:"request_id"`
+	Command   Command       `json:"command"`
+	Status    string        `json:"status"`
+	Results   []PlantResult `json:"results,omitempty"`
+	Created   time.Time     `json:"created"`
+	Finished  *time.Time    `json:"finished,omitempty"`
+}
+
+// jobStore keeps the latest jobs in memory
+type jobStore struct {
+	mu    sync.Mutex
+	jobs  map[string]*Job
+	order []string
+}
+
+func newJobStore() *jobStore {
+	return &jobStore{jobs: make(map[string]*Job)}
+}
+
+func (s *jobStore) create(park string, user string, requestId string, cmd Command) Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j := &Job{
+		Id:        newId(),
+		Park:      park,
+		User:      user,
+		RequestId: requestId,
+		Command:   cmd,
+		Status:    JobPending,
+		Created:   time.Now(),
+	}
+	s.jobs[j.Id] = j
+	s.order = append(s.order, j.Id)
+	// forget the oldest finished jobs
+	for len(s.order) > jobsToStore {
+		oldest := s.jobs[s.order[0]]
+		if oldest.Finished == nil {
+			break
+		}
+		delete(s.jobs, s.order[0])
+		s.order = s.order[1:]
+	}
+	return *j
+}
+
+func (s *jobStore) get(id string) (Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *j, true
+}
+
+func (s *jobStore) update(id string, f func(j *Job)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if j, ok := s.jobs[id]; ok {
+		f(j)
+	}
+}
+
+func (s *jobStore) finish(id string, results []PlantResult) {
+	s.update(id, func(j *Job) {
+		now := time.Now()
+		j.Results = results
+		j.Finished = &now
+		j.Status = JobDone
+		for _, r := range results {
+			if !r.Ok {
+				j.Status = JobFailed
+			}
+		}
+	})
+}
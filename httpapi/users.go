@@ -0,0 +1,36 @@
+package httpapi
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LoadUsers Read the API users from a YAML file with a "users" list. Tokens can be given directly or by token_env.
+func LoadUsers(path string) ([]User, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Users []User `yaml:"users"`
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err = dec.Decode(&file); err != nil {
+		return nil, fmt.Errorf("users: %w", err)
+	}
+	for i, u := range file.Users {
+		if u.TokenEnv == "" {
+			continue
+		}
+		token, ok := os.LookupEnv(u.TokenEnv)
+		if !ok || token == "" {
+			return nil, fmt.Errorf("user %q: environment variable %s is not set", u.Name, u.TokenEnv)
+		}
+		file.Users[i].Token = token
+	}
+	return file.Users, nil
+}
@@ -0,0 +1,229 @@
+// Package icing reacts to icing with declarative rules on the Ctrl state, the Rbh status bits and reported icing conditions,
+// e.g. switch the rotor blade heating on for plants stopped for ice and back to Standard after some hours,
+// or stop plants whose heater reports a fault during icing conditions.
+//
+// Every action goes through energontrol.Do and is logged and recorded as a Decision.
+// Pending reverts are kept in memory only.
+package icing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/dernate/energontrol"
+)
+
+// maxDecisions decisions kept by an Engine
+const maxDecisions = 1000
+
+// Decision an action taken by the engine
+type Decision struct {
+	Time    time.Time `json:"time"`
+	Rule    string    `json:"rule"`
+	PlantNo uint8     `json:"plant_no"`
+	Action  string    `json:"action"`
+	Reason  string    `json:"reason"`
+	Ok      bool      `json:"ok"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// Engine evaluates Rules for the plants of one park
+type Engine struct {
+	Park       energontrol.ParkConfig
+	Controller energontrol.Controller
+	UserId     uint64
+	Rules      []Rule
+	PlantNo    []uint8                                                // plants to watch, all allowed turbines with Rbh if empty
+	Icing      func(ctx context.Context, PlantNo uint8) (bool, error) // icing conditions, e.g. of an ice detector, needed by rules with icing
+	Interval   time.Duration                                          // evaluation interval of Run, 1m if 0
+
+	mu        sync.Mutex
+	since     map[ruleKey]time.Time // since when the state of a plant matches a rule
+	fired     map[ruleKey]bool      // the rule fired for the current match of the plant
+	reverts   []revert
+	decisions []Decision
+}
+
+type ruleKey struct {
+	rule    string
+	plantNo uint8
+}
+
+type revert struct {
+	at      time.Time
+	rule    string
+	plantNo uint8
+	action  string
+}
+
+// Run Evaluate the rules every Interval until ctx is done
+func (e *Engine) Run(ctx context.Context) error {
+	interval := e.Interval
+	if interval == 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := e.Evaluate(ctx, time.Now()); err != nil {
+			e.log(ctx, slog.LevelError, 0, err.Error())
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Evaluate Read the Ctrl and Rbh state of the plants, run due reverts and the actions of matching rules
+func (e *Engine) Evaluate(ctx context.Context, now time.Time) ([]Decision, error) {
+	if err := e.init(ctx); err != nil {
+		return nil, err
+	}
+	var decisions []Decision
+	e.mu.Lock()
+	var due []revert
+	pending := e.reverts[:0]
+	for _, r := range e.reverts {
+		if !r.at.After(now) {
+			due = append(due, r)
+		} else {
+			pending = append(pending, r)
+		}
+	}
+	e.reverts = pending
+	e.mu.Unlock()
+	for _, r := range due {
+		decisions = append(decisions, e.act(ctx, now, r.rule, r.plantNo, r.action, "revert after the rule's action"))
+	}
+
+	ctrl, err := e.Controller.State(e.Park.Context(ctx), "Ctrl", e.PlantNo)
+	if err != nil {
+		return decisions, fmt.Errorf("read Ctrl state: %w", err)
+	}
+	rbh, err := e.Controller.State(e.Park.Context(ctx), "Rbh", e.PlantNo)
+	if err != nil {
+		return decisions, fmt.Errorf("read Rbh state: %w", err)
+	}
+	for i, plant := range e.PlantNo {
+		if i >= len(ctrl) || i >= len(rbh) {
+			break
+		}
+		if err := errors.Join(ctrl[i].Err, rbh[i].Err); err != nil {
+			e.log(ctx, slog.LevelWarn, plant, fmt.Sprintf("rules skipped, state unknown: %s", err))
+			continue
+		}
+		icing, icingKnown := false, e.Icing != nil
+		if icingKnown {
+			var icingErr error
+			if icing, icingErr = e.Icing(ctx, plant); icingErr != nil {
+				icingKnown = false
+				e.log(ctx, slog.LevelWarn, plant, fmt.Sprintf("icing conditions unknown, rules with icing are skipped: %s", icingErr))
+			}
+		}
+		for _, rule := range e.Rules {
+			if rule.When.Icing != nil && !icingKnown {
+				continue
+			}
+			key := ruleKey{rule.Name, plant}
+			match, state := rule.When.matches(ctrl[i].CtrlState, rbh[i].CtrlState, icing)
+			e.mu.Lock()
+			if !match {
+				delete(e.since, key)
+				delete(e.fired, key)
+				e.mu.Unlock()
+				continue
+			}
+			since, ok := e.since[key]
+			if !ok {
+				since = now
+				e.since[key] = now
+			}
+			fire := !e.fired[key] && now.Sub(since) >= rule.When.For
+			if fire {
+				e.fired[key] = true
+			}
+			e.mu.Unlock()
+			if !fire {
+				continue
+			}
+			reason := state
+			if rule.When.For > 0 {
+				reason += fmt.Sprintf(" for %s", now.Sub(since).Round(time.Second))
+			}
+			d := e.act(ctx, now, rule.Name, plant, rule.Action, reason)
+			decisions = append(decisions, d)
+			if d.Ok && rule.Revert != "" {
+				e.mu.Lock()
+				e.reverts = append(e.reverts, revert{at: now.Add(rule.RevertAfter), rule: rule.Name, plantNo: plant, action: rule.Revert})
+				e.mu.Unlock()
+			}
+		}
+	}
+	return decisions, nil
+}
+
+// Decisions Get a copy of the recorded decisions, oldest first
+func (e *Engine) Decisions() []Decision {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]Decision(nil), e.decisions...)
+}
+
+// act Run Action for the plant, log and record the decision
+func (e *Engine) act(ctx context.Context, now time.Time, rule string, PlantNo uint8, Action string, reason string) Decision {
+	d := Decision{Time: now, Rule: rule, PlantNo: PlantNo, Action: Action, Reason: reason}
+	ok, errList := energontrol.Do(e.Park.Context(ctx), e.Controller, e.UserId, Action, false, PlantNo)
+	d.Ok = len(ok) == 1 && ok[0]
+	if len(errList) == 1 && errList[0] != nil {
+		d.Error = errList[0].Error()
+	}
+	msg := fmt.Sprintf("%s by icing rule %s (%s): ok=%t", Action, rule, reason, d.Ok)
+	if d.Error != "" {
+		e.log(ctx, slog.LevelWarn, PlantNo, msg+", "+d.Error)
+	} else {
+		e.log(ctx, slog.LevelInfo, PlantNo, msg)
+	}
+	e.mu.Lock()
+	e.decisions = append(e.decisions, d)
+	if len(e.decisions) > maxDecisions {
+		e.decisions = e.decisions[len(e.decisions)-maxDecisions:]
+	}
+	e.mu.Unlock()
+	return d
+}
+
+func (e *Engine) init(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.since == nil {
+		if err := ValidateRules(e.Rules); err != nil {
+			return err
+		}
+		e.since = make(map[ruleKey]time.Time)
+		e.fired = make(map[ruleKey]bool)
+	}
+	if len(e.PlantNo) > 0 {
+		return nil
+	}
+	T, err := e.Controller.Turbines(e.Park.Context(ctx))
+	if err != nil {
+		return err
+	}
+	for _, plant := range T.PlantNo {
+		if T.Rbh[plant] && e.Park.PlantAllowed(plant) {
+			e.PlantNo = append(e.PlantNo, plant)
+		}
+	}
+	return nil
+}
+
+func (e *Engine) log(ctx context.Context, level slog.Level, PlantNo uint8, msg string) {
+	ctx = e.Park.Context(ctx)
+	energontrol.Logger(ctx).LogAttrs(ctx, level, msg, slog.Int(energontrol.LogKeyPlantNo, int(PlantNo)), slog.String(energontrol.LogKeyAction, "Icing"))
+}
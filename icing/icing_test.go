@@ -0,0 +1,65 @@
+package icing
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dernate/energontrol"
+	"github.com/dernate/energontrol/energontroltest"
+)
+
+func TestEvaluate(t *testing.T) {
+	scada := energontroltest.NewScada(4711, 1, 2)
+	scada.SetPlant(1, energontroltest.Plant{Ctrl: energontrol.CtrlValues["StopEnercon"], Rbh: energontroltest.RbhStandardState})
+	scada.SetPlant(2, energontroltest.Plant{Rbh: energontroltest.RbhStandardState | energontrol.RbhFault})
+	icing := true
+	e := &Engine{
+		Park:       energontrol.ParkConfig{Name: "north"},
+		Controller: scada,
+		UserId:     1234,
+		PlantNo:    []uint8{1, 2},
+		Icing:      func(ctx context.Context, PlantNo uint8) (bool, error) { return icing, nil },
+		Rules: []Rule{
+			{Name: "heat", When: Condition{Ctrl: []string{"StopEnercon"}, RbhClear: []string{"Fault", "ManualOnSCADA"}, Icing: &icing, For: 10 * time.Minute},
+				Action: energontrol.ActionRbhOn, RevertAfter: 2 * time.Hour, Revert: energontrol.ActionRbhStandard},
+			{Name: "heater-fault", When: Condition{Ctrl: []string{"Start"}, RbhSet: []string{"Fault"}, Icing: &icing}, Action: energontrol.ActionStop60},
+		},
+	}
+	t0 := time.Date(2026, 1, 10, 6, 0, 0, 0, time.UTC)
+	steps := []struct {
+		at   time.Duration
+		want []string // rule:action of the decisions
+	}{
+		{0, []string{"heater-fault:Stop60"}},
+		{5 * time.Minute, nil},
+		{10 * time.Minute, []string{"heat:RbhOn"}},
+		{11 * time.Minute, nil},
+		{2*time.Hour + 10*time.Minute, []string{"heat:RbhStandard"}},
+	}
+	for _, step := range steps {
+		decisions, err := e.Evaluate(context.Background(), t0.Add(step.at))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, d := range decisions {
+			if !d.Ok {
+				t.Errorf("Error: %+v", d)
+			}
+			got = append(got, d.Rule+":"+d.Action)
+		}
+		if len(got) != len(step.want) || (len(got) > 0 && got[0] != step.want[0]) {
+			t.Errorf("Error at %s: got %v, want %v", step.at, got, step.want)
+		}
+	}
+	if p := scada.Plant(2); p.Ctrl != energontrol.CtrlValues["Stop60"] {
+		t.Errorf("Error: plant 2 Ctrl %d", p.Ctrl)
+	}
+	if p := scada.Plant(1); p.Rbh != energontroltest.RbhStandardState {
+		t.Errorf("Error: plant 1 Rbh %d", p.Rbh)
+	}
+	if err := ValidateRules([]Rule{{Name: "x", When: Condition{RbhSet: []string{"Hot"}}, Action: energontrol.ActionStart}}); err == nil {
+		t.Error("Error: invalid rule accepted")
+	}
+}
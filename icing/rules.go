@@ -0,0 +1,147 @@
+package icing
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dernate/energontrol"
+	"gopkg.in/yaml.v3"
+)
+
+// RbhFlags names of the Rbh status bits for rbh_set and rbh_clear
+var RbhFlags = map[string]uint64{
+	"AutoDeicingAllowed":        energontrol.RbhAutoDeicingAllowed,
+	"AutoOffWEA":                energontrol.RbhAutoOffWEA,
+	"ManualOnWEA":               energontrol.RbhManualOnWEA,
+	"ManualOnSCADA":             energontrol.RbhManualOnSCADA,
+	"AutoDeicingWhenStopped":    energontrol.RbhAutoDeicingWhenStopped,
+	"AutoDeicingInOperation":    energontrol.RbhAutoDeicingInOperation,
+	"HeatingPreventiveAuto":     energontrol.RbhHeatingPreventiveAuto,
+	"HeatingWhenStoppedSCADA":   energontrol.RbhHeatingWhenStoppedSCADA,
+	"HeatingInOperationSCADA":   energontrol.RbhHeatingInOperationSCADA,
+	"NoSupplyPowerAvailable":    energontrol.RbhNoSupplyPowerAvailable,
+	"Fault":                     energontrol.RbhFault,
+	"DeicingAllowedInOperation": energontrol.RbhDeicingAllowedInOperation,
+	"PreventiveHeaterAllowed":   energontrol.RbhPreventiveHeaterAllowed,
+	"Installed":                 energontrol.RbhInstalled,
+	"NotInstalled":              energontrol.RbhNotInstalled,
+}
+
+// Rule runs Action for a plant once its state matched When for When.For.
+// It fires once until the state stops matching. If RevertAfter is set, Revert runs that long after Action.
+type Rule struct {
+	Name        string        `yaml:"name"`
+	When        Condition     `yaml:"when"`
+	Action      string        `yaml:"action"`
+	RevertAfter time.Duration `yaml:"revert_after"`
+	Revert      string        `yaml:"revert"`
+}
+
+// Condition all set fields have to match
+type Condition struct {
+	Ctrl     []string      `yaml:"ctrl"`      // names of CtrlValues, one has to match
+	RbhSet   []string      `yaml:"rbh_set"`   // names of RbhFlags, all have to be set
+	RbhClear []string      `yaml:"rbh_clear"` // names of RbhFlags, all have to be clear
+	Icing    *bool         `yaml:"icing"`     // icing conditions reported by Engine.Icing
+	For      time.Duration `yaml:"for"`       // how long the state has to match
+}
+
+// LoadRules Read and validate rules from a YAML file with a list of rules
+func LoadRules(path string) ([]Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []Rule
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err = dec.Decode(&rules); err != nil {
+		return nil, fmt.Errorf("icing rules: %w", err)
+	}
+	return rules, ValidateRules(rules)
+}
+
+// ValidateRules Check names, actions, states and flags of the rules
+func ValidateRules(rules []Rule) error {
+	var errList []error
+	names := make(map[string]bool)
+	for _, r := range rules {
+		if r.Name == "" {
+			errList = append(errList, errors.New("rule without name"))
+		} else if names[r.Name] {
+			errList = append(errList, fmt.Errorf("rule %q is defined more than once", r.Name))
+		}
+		names[r.Name] = true
+		if !allowedAction(r.Action) {
+			errList = append(errList, fmt.Errorf("rule %q: action %q is not one of %s", r.Name, r.Action, strings.Join(allowedActions, ", ")))
+		}
+		if (r.RevertAfter > 0) != (r.Revert != "") {
+			errList = append(errList, fmt.Errorf("rule %q: revert and revert_after are needed together", r.Name))
+		} else if r.Revert != "" && !allowedAction(r.Revert) {
+			errList = append(errList, fmt.Errorf("rule %q: revert %q is not one of %s", r.Name, r.Revert, strings.Join(allowedActions, ", ")))
+		}
+		for _, ctrl := range r.When.Ctrl {
+			if _, ok := energontrol.CtrlValues[ctrl]; !ok {
+				errList = append(errList, fmt.Errorf("rule %q: unknown ctrl state %q", r.Name, ctrl))
+			}
+		}
+		for _, flag := range append(append([]string(nil), r.When.RbhSet...), r.When.RbhClear...) {
+			if _, ok := RbhFlags[flag]; !ok {
+				errList = append(errList, fmt.Errorf("rule %q: unknown rbh flag %q", r.Name, flag))
+			}
+		}
+		if len(r.When.Ctrl) == 0 && len(r.When.RbhSet) == 0 && len(r.When.RbhClear) == 0 && r.When.Icing == nil {
+			errList = append(errList, fmt.Errorf("rule %q: empty condition", r.Name))
+		}
+	}
+	return errors.Join(errList...)
+}
+
+// allowedActions icing rules may only control the heater or stop a plant
+var allowedActions = []string{
+	energontrol.ActionRbhOn, energontrol.ActionRbhStandard, energontrol.ActionRbhAutoOff,
+	energontrol.ActionStop60, energontrol.ActionStop90,
+}
+
+func allowedAction(Action string) bool {
+	for _, a := range allowedActions {
+		if a == Action {
+			return true
+		}
+	}
+	return false
+}
+
+// matches Check the condition for a plant and describe the matched state for the decision log
+func (C Condition) matches(ctrl uint64, rbh uint64, icing bool) (bool, string) {
+	if len(C.Ctrl) > 0 {
+		found := false
+		for _, name := range C.Ctrl {
+			if energontrol.CtrlValues[name] == ctrl {
+				found = true
+			}
+		}
+		if !found {
+			return false, ""
+		}
+	}
+	for _, name := range C.RbhSet {
+		if rbh&RbhFlags[name] == 0 {
+			return false, ""
+		}
+	}
+	for _, name := range C.RbhClear {
+		if rbh&RbhFlags[name] != 0 {
+			return false, ""
+		}
+	}
+	if C.Icing != nil && *C.Icing != icing {
+		return false, ""
+	}
+	flags := strings.Join(energontrol.RbhStateText(rbh), "; ")
+	return true, fmt.Sprintf("Ctrl %s, Rbh %d [%s], icing %t", energontrol.CtrlStateText(ctrl), rbh, flags, icing)
+}
@@ -1,43 +1,100 @@
 package energontrol
 
 import (
+	"context"
 	"fmt"
-	log "github.com/sirupsen/logrus"
+	"log/slog"
+	"sync"
 	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Attribute keys, which the library and the services built on it attach to log records
+const (
+	LogKeyPark      = "Park"
+	LogKeyParkNo    = "ParkNo"
+	LogKeyPlantNo   = "PlantNo"
+	LogKeyAction    = "Action"
+	LogKeyUserId    = "UserId"
+	LogKeyRequestId = "RequestId"
+	LogKeyOperator  = "Operator"
+)
+
+var (
+	loggerMu     sync.RWMutex
+	globalLogger = slog.New(logrusHandler{})
 )
 
+// LogLevel Set the level of the default logrus logger. A logger set by SetLogger or WithLogger has to be configured itself.
 func LogLevel(level uint32) {
 	log.SetLevel(log.Level(level))
 }
 
+// SetLogger Set the logger for all log messages of the library. nil restores the default, which writes to logrus.
+func SetLogger(l *slog.Logger) {
+	if l == nil {
+		l = slog.New(logrusHandler{})
+	}
+	loggerMu.Lock()
+	globalLogger = l
+	loggerMu.Unlock()
+}
+
+// WithLogger returns a context, which routes the log messages of the library's functions to l instead of the global logger,
+// e.g. to give every park its own log
+func WithLogger(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, l)
+}
+
+// WithLogAttrs returns a context, whose log messages carry attrs in addition to the ones already set,
+// e.g. the ParkNo or the id of the request, which triggered a command
+func WithLogAttrs(ctx context.Context, attrs ...slog.Attr) context.Context {
+	prev, _ := ctx.Value(logAttrsKey).([]slog.Attr)
+	all := make([]slog.Attr, 0, len(prev)+len(attrs))
+	all = append(append(all, prev...), attrs...)
+	return context.WithValue(ctx, logAttrsKey, all)
+}
+
+// Logger Return the logger for ctx: the one set by WithLogger or SetLogger, carrying the attributes set by WithLogAttrs
+func Logger(ctx context.Context) *slog.Logger {
+	l, _ := ctx.Value(loggerKey).(*slog.Logger)
+	if l == nil {
+		loggerMu.RLock()
+		l = globalLogger
+		loggerMu.RUnlock()
+	}
+	if attrs, _ := ctx.Value(logAttrsKey).([]slog.Attr); len(attrs) > 0 {
+		args := make([]any, len(attrs))
+		for i, a := range attrs {
+			args[i] = a
+		}
+		l = l.With(args...)
+	}
+	return l
+}
+
+func logPlant(ctx context.Context, level slog.Level, plantNo uint8, action string, msg string) {
+	Logger(ctx).LogAttrs(ctx, level, msg, slog.Int(LogKeyPlantNo, int(plantNo)), slog.String(LogKeyAction, action))
+}
+
 func LogInfo(plantNo uint8, action string, msg string) {
-	t := time.Now()
-	log.WithFields(log.Fields{
-		"PlantNo": plantNo,
-		"Action":  action,
-		"Time":    t.Format(time.RFC3339),
-	}).Info(msg)
+	logPlant(context.Background(), slog.LevelInfo, plantNo, action, msg)
 }
 
 func LogWarn(plantNo uint8, action string, msg string) {
-	t := time.Now()
-	log.WithFields(log.Fields{
-		"PlantNo": plantNo,
-		"Action":  action,
-		"Time":    t.Format(time.RFC3339),
-	}).Warn(msg)
+	logPlant(context.Background(), slog.LevelWarn, plantNo, action, msg)
 }
 
 func LogError(plantNo uint8, action string, msg string) {
-	t := time.Now()
-	log.WithFields(log.Fields{
-		"PlantNo": plantNo,
-		"Action":  action,
-		"Time":    t.Format(time.RFC3339),
-	}).Error(msg)
+	logPlant(context.Background(), slog.LevelError, plantNo, action, msg)
 }
 
 func LogIfStateChangePermitted(state PlantState, PlantNo uint8, desiredState uint64) {
+	logIfStateChangePermitted(context.Background(), state, PlantNo, desiredState)
+}
+
+func logIfStateChangePermitted(ctx context.Context, state PlantState, PlantNo uint8, desiredState uint64) {
 	if state.CtrlState > 128 {
 		var MSG string
 		var action string
@@ -49,7 +106,82 @@ func LogIfStateChangePermitted(state PlantState, PlantNo uint8, desiredState uin
 				action = _msg
 			}
 		}
-		LogWarn(PlantNo, action,
+		logPlant(ctx, slog.LevelWarn, PlantNo, action,
 			fmt.Sprintf("%s is not allowed, because plant is in state %d (%s)", action, state.CtrlState, MSG))
 	}
 }
+
+// logrusHandler is the default slog.Handler. It writes the records with their attributes as fields to the global logrus logger.
+type logrusHandler struct {
+	attrs  []slog.Attr
+	prefix string
+}
+
+func (h logrusHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return log.IsLevelEnabled(logrusLevel(level))
+}
+
+func (h logrusHandler) Handle(_ context.Context, r slog.Record) error {
+	fields := log.Fields{}
+	for _, a := range h.attrs {
+		addField(fields, "", a)
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		addField(fields, h.prefix, a)
+		return true
+	})
+	t := r.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	fields["Time"] = t.Format(time.RFC3339)
+	log.WithFields(fields).Log(logrusLevel(r.Level), r.Message)
+	return nil
+}
+
+func (h logrusHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	all := make([]slog.Attr, 0, len(h.attrs)+len(attrs))
+	all = append(all, h.attrs...)
+	for _, a := range attrs {
+		a.Key = h.prefix + a.Key
+		all = append(all, a)
+	}
+	return logrusHandler{attrs: all, prefix: h.prefix}
+}
+
+func (h logrusHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return logrusHandler{attrs: h.attrs, prefix: h.prefix + name + "."}
+}
+
+func addField(fields log.Fields, prefix string, a slog.Attr) {
+	v := a.Value.Resolve()
+	if v.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, g := range v.Group() {
+			addField(fields, prefix, g)
+		}
+		return
+	}
+	if a.Key == "" {
+		return
+	}
+	fields[prefix+a.Key] = v.Any()
+}
+
+func logrusLevel(level slog.Level) log.Level {
+	switch {
+	case level >= slog.LevelError:
+		return log.ErrorLevel
+	case level >= slog.LevelWarn:
+		return log.WarnLevel
+	case level >= slog.LevelInfo:
+		return log.InfoLevel
+	default:
+		return log.DebugLevel
+	}
+}
@@ -0,0 +1,156 @@
+// Package metrics exports the measurements of energontrol as Prometheus metrics.
+//
+//	m := metrics.New()
+//	energontrol.SetObserver(m.Park("north"))
+//	http.Handle("/metrics", m)
+//
+// Exported metrics, all with a "park" label:
+//
+//	energontrol_commands_total{action,outcome}            commands per plant, outcome is ok or failed
+//	energontrol_command_duration_seconds{action}          duration of a command call
+//	energontrol_opc_requests_total{method,outcome}        OPC requests, outcome is ok or error
+//	energontrol_opc_request_duration_seconds{method}      OPC request latency
+//	energontrol_session_phase_duration_seconds{phase}     time waiting for a session state
+//	energontrol_session_errors_total{code,state}          plants failing because of their session state, e.g. 108, 109, 174, 175
+//	energontrol_server_available                          1 if the last ServerAvailable check found the server running
+//	energontrol_server_available_checks_total{result}     ServerAvailable results: available, not_running or error
+//	energontrol_plant_ctrl_state{plant}                   last read Ctrl state
+//	energontrol_plant_rbh_state{plant}                    last read Rbh state
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dernate/energontrol"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// DefaultBuckets histogram buckets in seconds, covering single OPC requests up to full session handshakes
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// Metrics collects the measurements of any number of parks
+type Metrics struct {
+	registry *prometheus.Registry
+	handler  http.Handler
+
+	commands        *prometheus.CounterVec
+	commandDuration *prometheus.HistogramVec
+	opcRequests     *prometheus.CounterVec
+	opcDuration     *prometheus.HistogramVec
+	phaseDuration   *prometheus.HistogramVec
+	sessionErrors   *prometheus.CounterVec
+	available       *prometheus.GaugeVec
+	availableChecks *prometheus.CounterVec
+	ctrlState       *prometheus.GaugeVec
+	rbhState        *prometheus.GaugeVec
+}
+
+// New Create the metrics in their own registry
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		commands: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "energontrol_commands_total", Help: "Commands per plant by action and outcome.",
+		}, []string{"park", "action", "outcome"}),
+		commandDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "energontrol_command_duration_seconds", Help: "Duration of command calls by action.", Buckets: DefaultBuckets,
+		}, []string{"park", "action"}),
+		opcRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "energontrol_opc_requests_total", Help: "OPC requests by method and outcome.",
+		}, []string{"park", "method", "outcome"}),
+		opcDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "energontrol_opc_request_duration_seconds", Help: "OPC request latency by method.", Buckets: DefaultBuckets,
+		}, []string{"park", "method"}),
+		phaseDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "energontrol_session_phase_duration_seconds", Help: "Time waiting for a session state.", Buckets: DefaultBuckets,
+		}, []string{"park", "phase"}),
+		sessionErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "energontrol_session_errors_total", Help: "Plants failing because of their session state by session state code.",
+		}, []string{"park", "code", "state"}),
+		available: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "energontrol_server_available", Help: "1 if the last ServerAvailable check found the server running.",
+		}, []string{"park"}),
+		availableChecks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "energontrol_server_available_checks_total", Help: "ServerAvailable results.",
+		}, []string{"park", "result"}),
+		ctrlState: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "energontrol_plant_ctrl_state", Help: "Last read Ctrl state per plant.",
+		}, []string{"park", "plant"}),
+		rbhState: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "energontrol_plant_rbh_state", Help: "Last read Rbh state per plant.",
+		}, []string{"park", "plant"}),
+	}
+	m.registry.MustRegister(m.commands, m.commandDuration, m.opcRequests, m.opcDuration, m.phaseDuration,
+		m.sessionErrors, m.available, m.availableChecks, m.ctrlState, m.rbhState)
+	m.handler = promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+	return m
+}
+
+// Registry Get the registry of the metrics, e.g. to add the Go and process collectors
+func (m *Metrics) Registry() *prometheus.Registry {
+	return m.registry
+}
+
+// Park Get an energontrol.Observer, which records all measurements with the label park="name"
+func (m *Metrics) Park(name string) energontrol.Observer {
+	return &parkObserver{m: m, park: name}
+}
+
+// ServeHTTP Serve all metrics with promhttp
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.handler.ServeHTTP(w, r)
+}
+
+type parkObserver struct {
+	m    *Metrics
+	park string
+}
+
+func outcome(ok bool, okText string, failedText string) string {
+	if ok {
+		return okText
+	}
+	return failedText
+}
+
+func (o *parkObserver) OpcRequest(Method string, Duration time.Duration, err error) {
+	o.m.opcRequests.WithLabelValues(o.park, Method, outcome(err == nil, "ok", "error")).Inc()
+	o.m.opcDuration.WithLabelValues(o.park, Method).Observe(Duration.Seconds())
+}
+
+func (o *parkObserver) ServerAvailable(Available bool, err error) {
+	result := outcome(Available, "available", "not_running")
+	if err != nil {
+		result = "error"
+	}
+	o.m.availableChecks.WithLabelValues(o.park, result).Inc()
+	var v float64
+	if Available {
+		v = 1
+	}
+	o.m.available.WithLabelValues(o.park).Set(v)
+}
+
+func (o *parkObserver) Command(Action string, PlantNo uint8, Ok bool, err error, Duration time.Duration) {
+	o.m.commands.WithLabelValues(o.park, Action, outcome(Ok && err == nil, "ok", "failed")).Inc()
+	o.m.commandDuration.WithLabelValues(o.park, Action).Observe(Duration.Seconds())
+}
+
+func (o *parkObserver) SessionPhase(Phase string, Duration time.Duration) {
+	o.m.phaseDuration.WithLabelValues(o.park, Phase).Observe(Duration.Seconds())
+}
+
+func (o *parkObserver) SessionError(PlantNo uint8, State uint16) {
+	o.m.sessionErrors.WithLabelValues(o.park, strconv.Itoa(int(State)), energontrol.SessionStateText(State)).Inc()
+}
+
+func (o *parkObserver) PlantState(PlantNo uint8, CtrlOrRbh string, State uint64) {
+	gauge := o.m.ctrlState
+	if CtrlOrRbh == "Rbh" {
+		gauge = o.m.rbhState
+	}
+	gauge.WithLabelValues(o.park, strconv.Itoa(int(PlantNo))).Set(float64(State))
+}
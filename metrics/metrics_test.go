@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	m := New()
+	o := m.Park("north")
+	o.Command("Stop90", 2, true, nil, 3*time.Second)
+	o.Command("Stop90", 4, false, errors.New("Session error"), 3*time.Second)
+	o.OpcRequest("Read", 20*time.Millisecond, nil)
+	o.SessionError(4, 108)
+	o.ServerAvailable(true, nil)
+	o.PlantState(2, "Ctrl", 2)
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+	expected := []string{
+		"# TYPE energontrol_commands_total counter",
+		`energontrol_commands_total{action="Stop90",outcome="ok",park="north"} 1`,
+		`energontrol_commands_total{action="Stop90",outcome="failed",park="north"} 1`,
+		`energontrol_command_duration_seconds_bucket{action="Stop90",park="north",le="5"} 2`,
+		`energontrol_command_duration_seconds_count{action="Stop90",park="north"} 2`,
+		`energontrol_opc_request_duration_seconds_bucket{method="Read",park="north",le="0.01"} 0`,
+		`energontrol_opc_request_duration_seconds_bucket{method="Read",park="north",le="0.025"} 1`,
+		`energontrol_session_errors_total{code="108",park="north",state="Occupied"} 1`,
+		`energontrol_server_available{park="north"} 1`,
+		`energontrol_plant_ctrl_state{park="north",plant="2"} 2`,
+	}
+	for _, e := range expected {
+		if !strings.Contains(body, e+"\n") {
+			t.Errorf("Error: %q missing in\n%s", e, body)
+		}
+	}
+}
@@ -0,0 +1,391 @@
+// Package modbusgw is a Modbus TCP server, which maps holding registers and coils to energontrol state and commands,
+// so PLCs that only speak Modbus TCP can control Enercon turbines.
+//
+// Supported function codes are 01 (read coils), 03 (read holding registers), 05 (write single coil),
+// 06 (write single register) and 16 (write multiple registers). Writes are acknowledged right away
+// and executed in the background, because a session handshake takes longer than usual Modbus timeouts.
+// Unmapped addresses and ranges beyond address 65535 are answered with exception 02, invalid values with 03
+// and failed state reads with 04.
+//
+// Modbus TCP has no authentication, so only the clients in RegisterMap.WriteClients may write, e.g. "10.20.0.5" or
+// "0.0.0.0/0" for all IPv4 clients. Without WriteClients, or with RegisterMap.ReadOnly, the gateway is read-only;
+// rejected writes are answered with exception 01 and logged.
+package modbusgw
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/dernate/energontrol"
+)
+
+const (
+	fcReadCoils              = 0x01
+	fcReadHoldingRegisters   = 0x03
+	fcWriteSingleCoil        = 0x05
+	fcWriteSingleRegister    = 0x06
+	fcWriteMultipleRegisters = 0x10
+
+	exIllegalFunction    = 0x01
+	exIllegalDataAddress = 0x02
+	exIllegalDataValue   = 0x03
+	exDeviceFailure      = 0x04
+)
+
+type command struct {
+	PlantNo uint8
+	Action  string
+}
+
+// Gateway serves the register map of one park
+type Gateway struct {
+	Park       energontrol.ParkConfig
+	Controller energontrol.Controller
+	UserId     uint64
+	Map        RegisterMap
+	CacheTTL   time.Duration // how long read states are reused, 5s if 0
+
+	mu       sync.Mutex
+	ctrl     map[uint8]uint64
+	rbh      map[uint8]uint64
+	readAt   time.Time
+	commands chan command
+	running  sync.Mutex // held while a command is executed, so commands of several Serve calls run one after another
+}
+
+// ListenAndServe Listen on the TCP address and serve until ctx is done
+func (g *Gateway) ListenAndServe(ctx context.Context, addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return g.Serve(ctx, l)
+}
+
+// Serve Accept Modbus TCP connections on l until ctx is done. Written commands are executed while Serve runs,
+// commands still pending when it returns are executed by the next Serve.
+func (g *Gateway) Serve(ctx context.Context, l net.Listener) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	// a failing listener ends the connections and the command loop as well
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		_ = l.Close()
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		g.runCommands(ctx)
+	}()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g.serveConn(ctx, conn)
+		}()
+	}
+}
+
+func (g *Gateway) serveConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+	canWrite := g.Map.writeAllowed(conn.RemoteAddr())
+	header := make([]byte, 7)
+	for {
+		// MBAP header: transaction id, protocol id, length, unit id
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		length := binary.BigEndian.Uint16(header[4:6])
+		if binary.BigEndian.Uint16(header[2:4]) != 0 || length < 2 || length > 254 {
+			return
+		}
+		pdu := make([]byte, length-1)
+		if _, err := io.ReadFull(conn, pdu); err != nil {
+			return
+		}
+		if g.Map.UnitId != 0 && header[6] != g.Map.UnitId {
+			continue
+		}
+		var resp []byte
+		if isWrite(pdu[0]) && !canWrite {
+			g.log(ctx, slog.LevelWarn, 0, fmt.Sprintf("write from %s rejected, the client is not allowed to write", conn.RemoteAddr()))
+			resp = exception(pdu[0], exIllegalFunction)
+		} else {
+			resp = g.handle(ctx, pdu)
+		}
+		out := make([]byte, 7+len(resp))
+		copy(out, header[:4])
+		binary.BigEndian.PutUint16(out[4:6], uint16(len(resp)+1))
+		out[6] = header[6]
+		copy(out[7:], resp)
+		if _, err := conn.Write(out); err != nil {
+			return
+		}
+	}
+}
+
+// handle Process one request PDU and return the response PDU
+func (g *Gateway) handle(ctx context.Context, pdu []byte) []byte {
+	fc := pdu[0]
+	data := pdu[1:]
+	switch fc {
+	case fcReadHoldingRegisters, fcReadCoils:
+		if len(data) != 4 {
+			return exception(fc, exIllegalDataValue)
+		}
+		start := binary.BigEndian.Uint16(data[0:2])
+		count := binary.BigEndian.Uint16(data[2:4])
+		if fc == fcReadCoils {
+			return g.readCoils(start, count)
+		}
+		return g.readRegisters(ctx, start, count)
+	case fcWriteSingleCoil:
+		if len(data) != 4 {
+			return exception(fc, exIllegalDataValue)
+		}
+		c, ok := g.Map.coil(binary.BigEndian.Uint16(data[0:2]))
+		if !ok {
+			return exception(fc, exIllegalDataAddress)
+		}
+		switch binary.BigEndian.Uint16(data[2:4]) {
+		case 0xFF00:
+			g.enqueue(c.PlantNo, c.Action)
+		case 0x0000:
+		default:
+			return exception(fc, exIllegalDataValue)
+		}
+		return pdu
+	case fcWriteSingleRegister:
+		if len(data) != 4 {
+			return exception(fc, exIllegalDataValue)
+		}
+		if ex := g.writeRegisters(binary.BigEndian.Uint16(data[0:2]), data[2:4]); ex != 0 {
+			return exception(fc, ex)
+		}
+		return pdu
+	case fcWriteMultipleRegisters:
+		if len(data) < 5 {
+			return exception(fc, exIllegalDataValue)
+		}
+		start := binary.BigEndian.Uint16(data[0:2])
+		count := binary.BigEndian.Uint16(data[2:4])
+		if count == 0 || count > 123 || int(data[4]) != 2*int(count) || len(data) != 5+2*int(count) {
+			return exception(fc, exIllegalDataValue)
+		}
+		if ex := g.writeRegisters(start, data[5:]); ex != 0 {
+			return exception(fc, ex)
+		}
+		return pdu[:5]
+	}
+	return exception(fc, exIllegalFunction)
+}
+
+func isWrite(fc byte) bool {
+	return fc == fcWriteSingleCoil || fc == fcWriteSingleRegister || fc == fcWriteMultipleRegisters
+}
+
+func exception(fc byte, code byte) []byte {
+	return []byte{fc | 0x80, code}
+}
+
+func (g *Gateway) readCoils(start uint16, count uint16) []byte {
+	if count == 0 || count > 2000 {
+		return exception(fcReadCoils, exIllegalDataValue)
+	}
+	if !inRange(start, int(count)) {
+		return exception(fcReadCoils, exIllegalDataAddress)
+	}
+	for a := uint32(start); a < uint32(start)+uint32(count); a++ {
+		if _, ok := g.Map.coil(uint16(a)); !ok {
+			return exception(fcReadCoils, exIllegalDataAddress)
+		}
+	}
+	n := (count + 7) / 8
+	return append([]byte{fcReadCoils, byte(n)}, make([]byte, n)...)
+}
+
+func (g *Gateway) readRegisters(ctx context.Context, start uint16, count uint16) []byte {
+	if count == 0 || count > 125 {
+		return exception(fcReadHoldingRegisters, exIllegalDataValue)
+	}
+	if !inRange(start, int(count)) {
+		return exception(fcReadHoldingRegisters, exIllegalDataAddress)
+	}
+	var registers []Register
+	for a := uint32(start); a < uint32(start)+uint32(count); a++ {
+		r, ok := g.Map.register(uint16(a))
+		if !ok {
+			return exception(fcReadHoldingRegisters, exIllegalDataAddress)
+		}
+		registers = append(registers, r)
+	}
+	ctrl, rbh, err := g.states(ctx)
+	if err != nil {
+		g.log(ctx, slog.LevelError, 0, err.Error())
+		return exception(fcReadHoldingRegisters, exDeviceFailure)
+	}
+	resp := []byte{fcReadHoldingRegisters, byte(2 * count)}
+	for _, r := range registers {
+		states := rbh
+		if r.Kind == KindCtrl {
+			states = ctrl
+		}
+		if _, ok := states[r.PlantNo]; !ok {
+			g.log(ctx, slog.LevelError, r.PlantNo, fmt.Sprintf("%s state of plant not read", r.Kind))
+			return exception(fcReadHoldingRegisters, exDeviceFailure)
+		}
+		var value uint16
+		switch r.Kind {
+		case KindCtrl:
+			value = uint16(ctrl[r.PlantNo])
+		case KindRbh:
+			value = uint16(rbh[r.PlantNo])
+		case KindRbhHigh:
+			value = uint16(rbh[r.PlantNo] >> 16)
+		}
+		resp = binary.BigEndian.AppendUint16(resp, value)
+	}
+	return resp
+}
+
+// inRange Check that count addresses from start end at address 65535 at the latest instead of wrapping around to 0
+func inRange(start uint16, count int) bool {
+	return int(start)+count <= 1<<16
+}
+
+// writeRegisters Validate all written values first and enqueue the commands only if all are valid
+func (g *Gateway) writeRegisters(start uint16, values []byte) byte {
+	if !inRange(start, len(values)/2) {
+		return exIllegalDataAddress
+	}
+	var commands []command
+	for i := 0; i+1 < len(values); i += 2 {
+		r, ok := g.Map.register(start + uint16(i/2))
+		if !ok {
+			return exIllegalDataAddress
+		}
+		Action, ok := r.writeAction(binary.BigEndian.Uint16(values[i : i+2]))
+		if !ok {
+			return exIllegalDataValue
+		}
+		commands = append(commands, command{PlantNo: r.PlantNo, Action: Action})
+	}
+	for _, c := range commands {
+		g.enqueue(c.PlantNo, c.Action)
+	}
+	return 0
+}
+
+// queue Get the channel of the written commands
+func (g *Gateway) queue() chan command {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.commands == nil {
+		g.commands = make(chan command, 64)
+	}
+	return g.commands
+}
+
+func (g *Gateway) enqueue(PlantNo uint8, Action string) {
+	select {
+	case g.queue() <- command{PlantNo: PlantNo, Action: Action}:
+	default:
+		g.log(context.Background(), slog.LevelWarn, PlantNo, fmt.Sprintf("%s dropped, too many pending commands", Action))
+	}
+}
+
+// runCommands Execute the written commands one after another until ctx is done
+func (g *Gateway) runCommands(ctx context.Context) {
+	commands := g.queue()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case c := <-commands:
+			g.running.Lock()
+			ok, errList := energontrol.Do(g.Park.Context(ctx), g.Controller, g.UserId, c.Action, false, c.PlantNo)
+			if len(errList) == 1 && errList[0] != nil {
+				g.log(ctx, slog.LevelWarn, c.PlantNo, fmt.Sprintf("%s failed: %s", c.Action, errList[0]))
+			} else {
+				g.log(ctx, slog.LevelInfo, c.PlantNo, fmt.Sprintf("%s done: ok=%t", c.Action, len(ok) == 1 && ok[0]))
+			}
+			// read the new state with the next request
+			g.mu.Lock()
+			g.readAt = time.Time{}
+			g.mu.Unlock()
+			g.running.Unlock()
+		}
+	}
+}
+
+// states Get Ctrl and Rbh of all mapped plants, read again if older than CacheTTL
+func (g *Gateway) states(ctx context.Context) (map[uint8]uint64, map[uint8]uint64, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	ttl := g.CacheTTL
+	if ttl == 0 {
+		ttl = 5 * time.Second
+	}
+	if g.ctrl != nil && time.Since(g.readAt) < ttl {
+		return g.ctrl, g.rbh, nil
+	}
+	seen := make(map[uint8]bool)
+	var PlantNo []uint8
+	for _, r := range g.Map.Registers {
+		if !seen[r.PlantNo] {
+			seen[r.PlantNo] = true
+			PlantNo = append(PlantNo, r.PlantNo)
+		}
+	}
+	ctx = g.Park.Context(ctx)
+	CtrlState, err := g.Controller.State(ctx, "Ctrl", PlantNo)
+	if err != nil {
+		return nil, nil, err
+	}
+	RbhState, err := g.Controller.State(ctx, "Rbh", PlantNo)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(CtrlState) != len(PlantNo) || len(RbhState) != len(PlantNo) {
+		return nil, nil, errors.New("state count does not match PlantNo")
+	}
+	g.ctrl = make(map[uint8]uint64)
+	g.rbh = make(map[uint8]uint64)
+	for i, plant := range PlantNo {
+		// plants with a failed read are left out, reading their registers fails
+		if CtrlState[i].Err == nil {
+			g.ctrl[plant] = CtrlState[i].CtrlState
+		}
+		if RbhState[i].Err == nil {
+			g.rbh[plant] = RbhState[i].CtrlState
+		}
+	}
+	g.readAt = time.Now()
+	return g.ctrl, g.rbh, nil
+}
+
+// log Write msg with the park's log attributes to the energontrol logger
+func (g *Gateway) log(ctx context.Context, level slog.Level, PlantNo uint8, msg string) {
+	ctx = g.Park.Context(ctx)
+	energontrol.Logger(ctx).LogAttrs(ctx, level, msg, slog.Int(energontrol.LogKeyPlantNo, int(PlantNo)), slog.String(energontrol.LogKeyAction, "Modbus"))
+}
@@ -0,0 +1,211 @@
+package modbusgw
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/dernate/energontrol"
+	"github.com/dernate/energontrol/energontroltest"
+)
+
+func request(t *testing.T, conn net.Conn, pdu []byte) []byte {
+	t.Helper()
+	frame := []byte{0, 1, 0, 0}
+	frame = binary.BigEndian.AppendUint16(frame, uint16(len(pdu)+1))
+	frame = append(frame, 1)
+	frame = append(frame, pdu...)
+	if _, err := conn.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+	header := make([]byte, 7)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		t.Fatal(err)
+	}
+	resp := make([]byte, binary.BigEndian.Uint16(header[4:6])-1)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestGateway(t *testing.T) {
+	scada := energontroltest.NewScada(1234, 1, 2)
+	scada.SetPlant(2, energontroltest.Plant{Ctrl: 130, Rbh: energontroltest.RbhOnState | energontrol.RbhNotInstalled})
+	Park := energontrol.ParkConfig{Name: "north"}
+	M := RegisterMap{
+		Registers: []Register{
+			{Address: 100, PlantNo: 1, Kind: KindCtrl},
+			{Address: 101, PlantNo: 2, Kind: KindCtrl},
+			{Address: 102, PlantNo: 2, Kind: KindRbh},
+			{Address: 103, PlantNo: 2, Kind: KindRbhHigh},
+		},
+		Coils:        []Coil{{Address: 10, PlantNo: 1, Action: energontrol.ActionReset}},
+		WriteClients: []string{"127.0.0.1"},
+	}
+	if err := M.Validate(Park); err != nil {
+		t.Fatal(err)
+	}
+	g := &Gateway{Park: Park, Controller: scada, UserId: 4711, Map: M, CacheTTL: time.Millisecond}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = g.Serve(ctx, l) }()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	resp := request(t, conn, []byte{fcReadHoldingRegisters, 0, 100, 0, 4})
+	Rbh := energontroltest.RbhOnState | energontrol.RbhNotInstalled
+	expected := []byte{fcReadHoldingRegisters, 8, 0, 0, 0, 130, byte(Rbh >> 8), byte(Rbh), 0, byte(Rbh >> 16)}
+	if !bytes.Equal(resp, expected) {
+		t.Errorf("Error: %v ; %v", resp, expected)
+	}
+	if resp = request(t, conn, []byte{fcReadHoldingRegisters, 0, 99, 0, 2}); !bytes.Equal(resp, []byte{0x83, exIllegalDataAddress}) {
+		t.Errorf("Error: %v", resp)
+	}
+	if resp = request(t, conn, []byte{fcWriteSingleRegister, 0, 100, 0, 7}); !bytes.Equal(resp, []byte{0x86, exIllegalDataValue}) {
+		t.Errorf("Error: %v", resp)
+	}
+	if resp = request(t, conn, []byte{fcWriteSingleRegister, 0, 100, 0, 2}); !bytes.Equal(resp, []byte{fcWriteSingleRegister, 0, 100, 0, 2}) {
+		t.Errorf("Error: %v", resp)
+	}
+	if resp = request(t, conn, []byte{fcWriteSingleCoil, 0, 10, 0xFF, 0}); !bytes.Equal(resp, []byte{fcWriteSingleCoil, 0, 10, 0xFF, 0}) {
+		t.Errorf("Error: %v", resp)
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(scada.CallLog()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	calls := scada.CallLog()
+	if len(calls) != 2 || calls[0].Action != "Stop" || calls[1].Action != "Reset" || scada.Plant(1).Ctrl != 2 {
+		t.Errorf("Error: %v ; Ctrl %d", calls, scada.Plant(1).Ctrl)
+	}
+}
+
+func TestWriteClients(t *testing.T) {
+	scada := energontroltest.NewScada(1234, 1)
+	Park := energontrol.ParkConfig{Name: "north"}
+	M := RegisterMap{Registers: []Register{{Address: 100, PlantNo: 1, Kind: KindCtrl}}, WriteClients: []string{"10.0.0.0/8", "192.168.1.5"}}
+	if err := M.Validate(Park); err != nil {
+		t.Fatal(err)
+	}
+	g := &Gateway{Park: Park, Controller: scada, UserId: 4711, Map: M}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = g.Serve(ctx, l) }()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// 127.0.0.1 may read, but not write
+	if resp := request(t, conn, []byte{fcWriteSingleRegister, 0, 100, 0, 2}); !bytes.Equal(resp, []byte{0x86, exIllegalFunction}) {
+		t.Errorf("Error: %v", resp)
+	}
+	if resp := request(t, conn, []byte{fcReadHoldingRegisters, 0, 100, 0, 1}); !bytes.Equal(resp, []byte{fcReadHoldingRegisters, 2, 0, 0}) {
+		t.Errorf("Error: %v", resp)
+	}
+	if calls := scada.CallLog(); len(calls) != 0 {
+		t.Errorf("Error: %v", calls)
+	}
+
+	for addr, want := range map[string]bool{"10.1.2.3": true, "::ffff:192.168.1.5": true, "192.168.1.6": false} {
+		if got := M.writeAllowed(&net.TCPAddr{IP: net.ParseIP(addr), Port: 5020}); got != want {
+			t.Errorf("Error: %s allowed %t", addr, got)
+		}
+	}
+	// without WriteClients, nobody may write
+	if (RegisterMap{}).writeAllowed(&net.TCPAddr{IP: net.ParseIP("127.0.0.1")}) {
+		t.Errorf("Error: map without write clients allows writes")
+	}
+	M.ReadOnly = true
+	if M.writeAllowed(&net.TCPAddr{IP: net.ParseIP("10.1.2.3")}) {
+		t.Errorf("Error: read-only map allows writes")
+	}
+	M.WriteClients = []string{"10.0.0.300"}
+	if err = M.Validate(Park); err == nil {
+		t.Errorf("Error: invalid write client accepted")
+	}
+}
+
+func TestAddressRange(t *testing.T) {
+	scada := energontroltest.NewScada(1234, 1)
+	M := RegisterMap{
+		Registers:    []Register{{Address: 65535, PlantNo: 1, Kind: KindCtrl}, {Address: 0, PlantNo: 1, Kind: KindCtrl}},
+		Coils:        []Coil{{Address: 65535, PlantNo: 1, Action: energontrol.ActionReset}, {Address: 0, PlantNo: 1, Action: energontrol.ActionReset}},
+		WriteClients: []string{"127.0.0.1"},
+	}
+	g := &Gateway{Park: energontrol.ParkConfig{Name: "north"}, Controller: scada, UserId: 4711, Map: M}
+	// ranges past 65535 don't wrap around to address 0
+	cases := map[string][]byte{
+		"write": {fcWriteMultipleRegisters, 0xFF, 0xFF, 0, 2, 4, 0, 1, 0, 1},
+		"read":  {fcReadHoldingRegisters, 0xFF, 0xFF, 0, 2},
+		"coils": {fcReadCoils, 0xFF, 0xFF, 0, 2},
+	}
+	for name, pdu := range cases {
+		if resp := g.handle(context.Background(), pdu); !bytes.Equal(resp, []byte{pdu[0] | 0x80, exIllegalDataAddress}) {
+			t.Errorf("Error: %s: %v", name, resp)
+		}
+	}
+	if resp := g.handle(context.Background(), []byte{fcReadHoldingRegisters, 0xFF, 0xFF, 0, 1}); !bytes.Equal(resp, []byte{fcReadHoldingRegisters, 2, 0, 0}) {
+		t.Errorf("Error: %v", resp)
+	}
+	if len(g.queue()) != 0 {
+		t.Errorf("Error: command of the wrapping write enqueued")
+	}
+}
+
+func TestServeAgain(t *testing.T) {
+	scada := energontroltest.NewScada(1234, 1)
+	M := RegisterMap{Registers: []Register{{Address: 100, PlantNo: 1, Kind: KindCtrl}}, WriteClients: []string{"127.0.0.1"}}
+	g := &Gateway{Park: energontrol.ParkConfig{Name: "north"}, Controller: scada, UserId: 4711, Map: M}
+	serve := func() (context.CancelFunc, chan error, net.Conn) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() { done <- g.Serve(ctx, l) }()
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cancel, done, conn
+	}
+	// the first Serve ends, the writes to the second are still executed
+	cancel, done, conn := serve()
+	conn.Close()
+	cancel()
+	<-done
+	cancel, done, conn = serve()
+	defer func() {
+		conn.Close()
+		cancel()
+		<-done
+	}()
+	if resp := request(t, conn, []byte{fcWriteSingleRegister, 0, 100, 0, 2}); !bytes.Equal(resp, []byte{fcWriteSingleRegister, 0, 100, 0, 2}) {
+		t.Errorf("Error: %v", resp)
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(scada.CallLog()) < 1 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if scada.Plant(1).Ctrl != 2 {
+		t.Errorf("Error: write to the second Serve not executed: %v", scada.CallLog())
+	}
+}
@@ -0,0 +1,169 @@
+package modbusgw
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+
+	"github.com/dernate/energontrol"
+	"gopkg.in/yaml.v3"
+)
+
+// Register kinds
+const (
+	KindCtrl    = "ctrl"     // read: Ctrl state, write: 0 Start, 1 Stop60, 2 Stop90
+	KindRbh     = "rbh"      // read: low word of the Rbh state, write: 0 Standard, 2 AutoOff, 10 ManualOn
+	KindRbhHigh = "rbh_high" // read: high word of the Rbh state, read only
+)
+
+// RegisterMap maps holding registers and coils of one park
+type RegisterMap struct {
+	UnitId       uint8      `yaml:"unit_id"`       // answer only requests for this unit id, 0 answers all
+	ReadOnly     bool       `yaml:"read_only"`     // reject all writes
+	WriteClients []string   `yaml:"write_clients"` // IP addresses or CIDR networks of the clients allowed to write, none if empty
+	Registers    []Register `yaml:"registers"`
+	Coils        []Coil     `yaml:"coils"`
+}
+
+// Register a holding register, mapped to Ctrl or Rbh of a plant
+type Register struct {
+	Address uint16 `yaml:"address"`
+	PlantNo uint8  `yaml:"plant"`
+	Kind    string `yaml:"kind"`
+}
+
+// Coil a coil, which runs Action (an energontrol Action* constant) for the plant when set to ON. It always reads OFF.
+type Coil struct {
+	Address uint16 `yaml:"address"`
+	PlantNo uint8  `yaml:"plant"`
+	Action  string `yaml:"action"`
+}
+
+// LoadRegisterMap Read and validate a YAML register map
+func LoadRegisterMap(path string, Park energontrol.ParkConfig) (RegisterMap, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return RegisterMap{}, err
+	}
+	var M RegisterMap
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err = dec.Decode(&M); err != nil {
+		return RegisterMap{}, fmt.Errorf("register map: %w", err)
+	}
+	return M, M.Validate(Park)
+}
+
+// Validate Check for duplicate addresses, unknown kinds and actions, plants not allowed in the park and invalid write clients
+func (M RegisterMap) Validate(Park energontrol.ParkConfig) error {
+	var errList []error
+	for _, client := range M.WriteClients {
+		if _, err := parseClient(client); err != nil {
+			errList = append(errList, fmt.Errorf("write client: %w", err))
+		}
+	}
+	registers := make(map[uint16]bool)
+	for _, r := range M.Registers {
+		if registers[r.Address] {
+			errList = append(errList, fmt.Errorf("register %d is mapped more than once", r.Address))
+		}
+		registers[r.Address] = true
+		if r.Kind != KindCtrl && r.Kind != KindRbh && r.Kind != KindRbhHigh {
+			errList = append(errList, fmt.Errorf("register %d: unknown kind %q", r.Address, r.Kind))
+		}
+		if !Park.PlantAllowed(r.PlantNo) {
+			errList = append(errList, fmt.Errorf("register %d: plant %d is not allowed", r.Address, r.PlantNo))
+		}
+	}
+	coils := make(map[uint16]bool)
+	for _, c := range M.Coils {
+		if coils[c.Address] {
+			errList = append(errList, fmt.Errorf("coil %d is mapped more than once", c.Address))
+		}
+		coils[c.Address] = true
+		if !energontrol.ValidAction(c.Action) {
+			errList = append(errList, fmt.Errorf("coil %d: unknown action %q", c.Address, c.Action))
+		}
+		if !Park.PlantAllowed(c.PlantNo) {
+			errList = append(errList, fmt.Errorf("coil %d: plant %d is not allowed", c.Address, c.PlantNo))
+		}
+	}
+	return errors.Join(errList...)
+}
+
+// parseClient Parse an IP address or a CIDR network of WriteClients
+func parseClient(client string) (netip.Prefix, error) {
+	if strings.Contains(client, "/") {
+		return netip.ParsePrefix(client)
+	}
+	addr, err := netip.ParseAddr(client)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// writeAllowed Check if the client at remote may write registers and coils
+func (M RegisterMap) writeAllowed(remote net.Addr) bool {
+	if M.ReadOnly {
+		return false
+	}
+	tcp, ok := remote.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	addr := tcp.AddrPort().Addr().Unmap()
+	for _, client := range M.WriteClients {
+		if prefix, err := parseClient(client); err == nil && prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (M RegisterMap) register(Address uint16) (Register, bool) {
+	for _, r := range M.Registers {
+		if r.Address == Address {
+			return r, true
+		}
+	}
+	return Register{}, false
+}
+
+func (M RegisterMap) coil(Address uint16) (Coil, bool) {
+	for _, c := range M.Coils {
+		if c.Address == Address {
+			return c, true
+		}
+	}
+	return Coil{}, false
+}
+
+// writeAction Get the energontrol action for a value written to a register
+func (r Register) writeAction(value uint16) (string, bool) {
+	switch r.Kind {
+	case KindCtrl:
+		switch value {
+		case 0:
+			return energontrol.ActionStart, true
+		case 1:
+			return energontrol.ActionStop60, true
+		case 2:
+			return energontrol.ActionStop90, true
+		}
+	case KindRbh:
+		switch value {
+		case 0:
+			return energontrol.ActionRbhStandard, true
+		case 2:
+			return energontrol.ActionRbhAutoOff, true
+		case 10:
+			return energontrol.ActionRbhOn, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,322 @@
+// Package mqttbridge publishes plant Ctrl and Rbh state to MQTT and executes command messages with energontrol.
+//
+// Topics, with <prefix> "park" by default:
+//
+//	<prefix>/<ParkNo>/plant/<n>/ctrl     retained, {"value": 1, "text": "Stop60"}
+//	<prefix>/<ParkNo>/plant/<n>/rbh      retained, {"value": 32770, "flags": ["Automatic operation of the heater suppressed", ...]}
+//	<prefix>/<ParkNo>/plant/<n>/command  subscribed, {"id": "abc", "action": "Stop90", "force": false}
+//	<prefix>/<ParkNo>/plant/<n>/result   {"id": "abc", "action": "Stop90", "ok": true, "error": "", "time": "..."}
+//
+// Actions are the energontrol Action* constants, e.g. Start, Stop60, Stop90, Reset, RbhOn, RbhAutoOff and RbhStandard.
+// Retained command messages are dropped, and a command with the Id of a recent command of the plant is executed only once,
+// so neither a retained message nor a redelivery after a reconnect repeats a command.
+package mqttbridge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dernate/energontrol"
+)
+
+// Client the part of an MQTT client used by the bridge, Paho adapts paho.mqtt.golang.
+// Subscribe passes the retained flag of every message to handler.
+type Client interface {
+	Publish(topic string, payload []byte, retained bool) error
+	Subscribe(topic string, handler func(topic string, payload []byte, retained bool)) error
+}
+
+// recentCommands the number of command Ids remembered to skip repeated commands
+const recentCommands = 1000
+
+// CtrlMessage payload of the ctrl topic
+type CtrlMessage struct {
+	Value uint64 `json:"value"`
+	Text  string `json:"text"`
+}
+
+// RbhMessage payload of the rbh topic
+type RbhMessage struct {
+	Value uint64   `json:"value"`
+	Flags []string `json:"flags"`
+}
+
+// CommandMessage payload of the command topic
+type CommandMessage struct {
+	Id     string `json:"id"`
+	Action string `json:"action"`
+	Force  bool   `json:"force"`
+}
+
+// ResultMessage payload of the result topic
+type ResultMessage struct {
+	Id     string    `json:"id"`
+	Action string    `json:"action"`
+	Ok     bool      `json:"ok"`
+	Error  string    `json:"error,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// Bridge connects one park to MQTT
+type Bridge struct {
+	Client     Client
+	Park       energontrol.ParkConfig
+	Controller energontrol.Controller
+	UserId     uint64
+	Prefix     string        // topic prefix, "park" if empty
+	Interval   time.Duration // state publish interval, 30s if 0
+	PlantNo    []uint8       // plants to publish, all allowed turbines of the park if empty
+
+	mu     sync.Mutex
+	parkNo uint64
+	last   map[string]string // last published payload per topic
+	seen   map[string]bool   // recent command Ids by "<plant>/<id>"
+	recent []string          // the keys of seen, oldest first
+}
+
+// Run Subscribe to the command topics and publish the plant state every Interval until ctx is done
+func (b *Bridge) Run(ctx context.Context) error {
+	if err := b.init(ctx); err != nil {
+		return err
+	}
+	commands := make(chan [2]string, 16)
+	err := b.Client.Subscribe(b.topic("+", "command"), func(topic string, payload []byte, retained bool) {
+		if retained {
+			// a retained command was sent earlier, maybe before the last restart
+			b.log(ctx, slog.LevelWarn, 0, fmt.Sprintf("retained command on %s dropped", topic))
+			return
+		}
+		select {
+		case commands <- [2]string{topic, string(payload)}:
+		default:
+			b.log(ctx, slog.LevelWarn, 0, fmt.Sprintf("command on %s dropped, too many pending commands", topic))
+		}
+	})
+	if err != nil {
+		return err
+	}
+	interval := b.Interval
+	if interval == 0 {
+		interval = 30 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	if err = b.PublishState(ctx); err != nil {
+		b.log(ctx, slog.LevelError, 0, err.Error())
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if err = b.PublishState(ctx); err != nil {
+				b.log(ctx, slog.LevelError, 0, err.Error())
+			}
+		case c := <-commands:
+			b.HandleCommand(ctx, c[0], []byte(c[1]))
+		}
+	}
+}
+
+// init Resolve ParkNo and plants of the bridge
+func (b *Bridge) init(ctx context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.last == nil {
+		b.last = make(map[string]string)
+	}
+	if b.parkNo != 0 {
+		return nil
+	}
+	b.parkNo = b.Park.ParkNo
+	if b.parkNo != 0 && len(b.PlantNo) > 0 {
+		return nil
+	}
+	T, err := b.Controller.Turbines(b.Park.Context(ctx))
+	if err != nil {
+		return err
+	}
+	if b.parkNo == 0 {
+		b.parkNo = T.ParkNo
+	}
+	if len(b.PlantNo) == 0 {
+		for _, plant := range T.PlantNo {
+			if b.Park.PlantAllowed(plant) {
+				b.PlantNo = append(b.PlantNo, plant)
+			}
+		}
+	}
+	return nil
+}
+
+func (b *Bridge) topic(plant string, leaf string) string {
+	prefix := b.Prefix
+	if prefix == "" {
+		prefix = "park"
+	}
+	return fmt.Sprintf("%s/%d/plant/%s/%s", prefix, b.parkNo, plant, leaf)
+}
+
+// PublishState Read Ctrl and Rbh of all plants and publish them retained. Unchanged states are not published again.
+func (b *Bridge) PublishState(ctx context.Context) error {
+	if err := b.init(ctx); err != nil {
+		return err
+	}
+	return b.publishState(ctx, b.PlantNo)
+}
+
+func (b *Bridge) publishState(ctx context.Context, PlantNo []uint8) error {
+	ctx = b.Park.Context(ctx)
+	CtrlState, err := b.Controller.State(ctx, "Ctrl", PlantNo)
+	if err != nil {
+		return err
+	}
+	RbhState, err := b.Controller.State(ctx, "Rbh", PlantNo)
+	if err != nil {
+		return err
+	}
+	var errList []error
+	for i, plant := range PlantNo {
+		n := strconv.Itoa(int(plant))
+		// a failed read keeps the last retained message
+		if CtrlState[i].Err != nil {
+			errList = append(errList, fmt.Errorf("plant %d: %w", plant, CtrlState[i].Err))
+		} else {
+			errList = append(errList, b.publish(b.topic(n, "ctrl"), CtrlMessage{
+				Value: CtrlState[i].CtrlState,
+				Text:  energontrol.CtrlStateText(CtrlState[i].CtrlState),
+			}, true))
+		}
+		if RbhState[i].Err != nil {
+			errList = append(errList, fmt.Errorf("plant %d: %w", plant, RbhState[i].Err))
+		} else {
+			errList = append(errList, b.publish(b.topic(n, "rbh"), RbhMessage{
+				Value: RbhState[i].CtrlState,
+				Flags: energontrol.RbhStateText(RbhState[i].CtrlState),
+			}, true))
+		}
+	}
+	return errors.Join(errList...)
+}
+
+func (b *Bridge) publish(topic string, v any, retained bool) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if retained {
+		b.mu.Lock()
+		unchanged := b.last[topic] == string(payload)
+		b.mu.Unlock()
+		if unchanged {
+			return nil
+		}
+	}
+	if err = b.Client.Publish(topic, payload, retained); err != nil {
+		return err
+	}
+	if retained {
+		// only a delivered payload counts as published, a failed one is sent again with the next state
+		b.mu.Lock()
+		b.last[topic] = string(payload)
+		b.mu.Unlock()
+	}
+	return nil
+}
+
+// HandleCommand Execute a message of a command topic and publish the result
+func (b *Bridge) HandleCommand(ctx context.Context, topic string, payload []byte) {
+	if err := b.init(ctx); err != nil {
+		b.log(ctx, slog.LevelError, 0, err.Error())
+		return
+	}
+	plant, err := b.plantFromTopic(topic)
+	if err != nil {
+		b.log(ctx, slog.LevelWarn, 0, err.Error())
+		return
+	}
+	var cmd CommandMessage
+	result := ResultMessage{Time: time.Now()}
+	if err = json.Unmarshal(payload, &cmd); err != nil {
+		result.Error = fmt.Sprintf("invalid command: %s", err)
+	} else if !b.Park.PlantAllowed(plant) {
+		result.Error = fmt.Sprintf("plant %d is not allowed", plant)
+	}
+	result.Id = cmd.Id
+	result.Action = cmd.Action
+	if result.Error == "" && !b.firstSeen(plant, cmd.Id) {
+		b.log(ctx, slog.LevelWarn, plant, fmt.Sprintf("MQTT command %q (id %s) repeated, skipped", cmd.Action, cmd.Id))
+		return
+	}
+	if result.Error == "" {
+		ok, errList := energontrol.Do(b.Park.Context(ctx), b.Controller, b.UserId, cmd.Action, cmd.Force, plant)
+		result.Ok = len(ok) == 1 && ok[0]
+		if len(errList) == 1 && errList[0] != nil {
+			result.Error = errList[0].Error()
+		}
+		result.Time = time.Now()
+	}
+	msg := fmt.Sprintf("MQTT command %q (id %s): ok=%t", cmd.Action, cmd.Id, result.Ok)
+	if result.Error != "" {
+		b.log(ctx, slog.LevelWarn, plant, msg+", "+result.Error)
+	} else {
+		b.log(ctx, slog.LevelInfo, plant, msg)
+	}
+	if err = b.publish(b.topic(strconv.Itoa(int(plant)), "result"), result, false); err != nil {
+		b.log(ctx, slog.LevelError, plant, err.Error())
+	}
+	// publish the new state right away instead of waiting for the next interval
+	if result.Ok {
+		if err = b.publishState(ctx, []uint8{plant}); err != nil {
+			b.log(ctx, slog.LevelError, plant, err.Error())
+		}
+	}
+}
+
+// firstSeen Remember the command Id of a plant, false if it is one of the recent Ids. Commands without Id are always executed.
+func (b *Bridge) firstSeen(PlantNo uint8, Id string) bool {
+	if Id == "" {
+		return true
+	}
+	key := fmt.Sprintf("%d/%s", PlantNo, Id)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.seen == nil {
+		b.seen = make(map[string]bool)
+	}
+	if b.seen[key] {
+		return false
+	}
+	if len(b.recent) >= recentCommands {
+		delete(b.seen, b.recent[0])
+		b.recent = b.recent[1:]
+	}
+	b.seen[key] = true
+	b.recent = append(b.recent, key)
+	return true
+}
+
+func (b *Bridge) plantFromTopic(topic string) (uint8, error) {
+	parts := strings.Split(topic, "/")
+	if len(parts) < 3 || parts[len(parts)-1] != "command" || parts[len(parts)-3] != "plant" {
+		return 0, fmt.Errorf("unexpected command topic %q", topic)
+	}
+	n, err := strconv.ParseUint(parts[len(parts)-2], 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected command topic %q", topic)
+	}
+	return uint8(n), nil
+}
+
+// log Write msg with the park's log attributes to the energontrol logger
+func (b *Bridge) log(ctx context.Context, level slog.Level, PlantNo uint8, msg string) {
+	ctx = b.Park.Context(ctx)
+	energontrol.Logger(ctx).LogAttrs(ctx, level, msg, slog.Int(energontrol.LogKeyPlantNo, int(PlantNo)), slog.String(energontrol.LogKeyAction, "MQTT"))
+}
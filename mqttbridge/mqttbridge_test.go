@@ -0,0 +1,247 @@
+package mqttbridge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dernate/energontrol"
+	"github.com/dernate/energontrol/energontroltest"
+)
+
+type message struct {
+	topic    string
+	payload  []byte
+	retained bool
+}
+
+// broker is an in-memory MQTT broker for one client. Like an MQTT broker, it delivers the retained messages
+// with the retained flag to a new subscription and live messages without it.
+type broker struct {
+	mu       sync.Mutex
+	messages []message
+	retained map[string][]byte
+	subs     map[string]func(topic string, payload []byte, retained bool)
+}
+
+func newBroker() *broker {
+	return &broker{retained: make(map[string][]byte), subs: make(map[string]func(string, []byte, bool))}
+}
+
+func (b *broker) Publish(topic string, payload []byte, retained bool) error {
+	b.mu.Lock()
+	b.messages = append(b.messages, message{topic, payload, retained})
+	if retained {
+		b.retained[topic] = payload
+	}
+	var handlers []func(string, []byte, bool)
+	for filter, h := range b.subs {
+		if match(filter, topic) {
+			handlers = append(handlers, h)
+		}
+	}
+	b.mu.Unlock()
+	for _, h := range handlers {
+		h(topic, payload, false)
+	}
+	return nil
+}
+
+func (b *broker) Subscribe(topic string, handler func(topic string, payload []byte, retained bool)) error {
+	b.mu.Lock()
+	b.subs[topic] = handler
+	retained := make(map[string][]byte)
+	for t, payload := range b.retained {
+		if match(topic, t) {
+			retained[t] = payload
+		}
+	}
+	b.mu.Unlock()
+	for t, payload := range retained {
+		handler(t, payload, true)
+	}
+	return nil
+}
+
+func (b *broker) get(topic string) ([]byte, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i := len(b.messages) - 1; i >= 0; i-- {
+		if b.messages[i].topic == topic {
+			return b.messages[i].payload, true
+		}
+	}
+	return nil, false
+}
+
+func match(filter string, topic string) bool {
+	f := strings.Split(filter, "/")
+	t := strings.Split(topic, "/")
+	if len(f) != len(t) {
+		return false
+	}
+	for i := range f {
+		if f[i] != "+" && f[i] != t[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestBridge(t *testing.T) {
+	scada := energontroltest.NewScada(1234, 1, 2)
+	mqtt := newBroker()
+	b := &Bridge{
+		Client:     mqtt,
+		Park:       energontrol.ParkConfig{Name: "north"},
+		Controller: scada,
+		UserId:     4711,
+		Interval:   time.Hour,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- b.Run(ctx) }()
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, ok := mqtt.get("park/1234/plant/2/rbh"); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Error: state not published")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := mqtt.Publish("park/1234/plant/2/command", []byte(`{"id": "c1", "action": "Stop90"}`), false); err != nil {
+		t.Fatal(err)
+	}
+	var result ResultMessage
+	for {
+		if payload, ok := mqtt.get("park/1234/plant/2/result"); ok {
+			if err := json.Unmarshal(payload, &result); err != nil {
+				t.Fatal(err)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Error: no result published")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+	if !result.Ok || result.Id != "c1" {
+		t.Errorf("Error: %+v", result)
+	}
+	var ctrl CtrlMessage
+	payload, _ := mqtt.get("park/1234/plant/2/ctrl")
+	if err := json.Unmarshal(payload, &ctrl); err != nil || ctrl.Value != 2 || ctrl.Text != "Stop90" {
+		t.Errorf("Error: %v ; %s", err, payload)
+	}
+	if calls := scada.CallLog(); len(calls) != 1 || calls[0].UserId != 4711 {
+		t.Errorf("Error: %v", calls)
+	}
+}
+
+// failingBroker fails every Publish while down is set
+type failingBroker struct {
+	*broker
+	down bool
+}
+
+func (b *failingBroker) Publish(topic string, payload []byte, retained bool) error {
+	if b.down {
+		return errors.New("not connected")
+	}
+	return b.broker.Publish(topic, payload, retained)
+}
+
+func TestPublishRetry(t *testing.T) {
+	scada := energontroltest.NewScada(1234, 1)
+	mqtt := &failingBroker{broker: newBroker(), down: true}
+	b := &Bridge{Client: mqtt, Park: energontrol.ParkConfig{Name: "north"}, Controller: scada}
+	ctx := context.Background()
+	if err := b.PublishState(ctx); err == nil {
+		t.Errorf("Error: failed publish not reported")
+	}
+	// the unchanged state is published, once the broker is back
+	mqtt.down = false
+	if err := b.PublishState(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := mqtt.get("park/1234/plant/1/ctrl"); !ok {
+		t.Errorf("Error: state not published after the failure")
+	}
+}
+
+func TestRepeatedCommand(t *testing.T) {
+	scada := energontroltest.NewScada(1234, 1, 2)
+	mqtt := newBroker()
+	// a retained command from before the start of the bridge
+	if err := mqtt.Publish("park/1234/plant/1/command", []byte(`{"id": "old", "action": "Stop90"}`), true); err != nil {
+		t.Fatal(err)
+	}
+	b := &Bridge{Client: mqtt, Park: energontrol.ParkConfig{Name: "north"}, Controller: scada, Interval: time.Hour}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go b.Run(ctx)
+	// the bridge subscribed before publishing the state
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, ok := mqtt.get("park/1234/plant/2/rbh"); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Error: state not published")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	// a command redelivered after a reconnect
+	for range 2 {
+		if err := mqtt.Publish("park/1234/plant/2/command", []byte(`{"id": "c1", "action": "Stop90"}`), false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for {
+		if _, ok := mqtt.get("park/1234/plant/2/result"); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Error: no result")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	// the same Id for another plant is another command
+	b.HandleCommand(ctx, "park/1234/plant/1/command", []byte(`{"id": "c1", "action": "Stop60"}`))
+	b.HandleCommand(ctx, "park/1234/plant/2/command", []byte(`{"id": "c1", "action": "Stop90"}`))
+	calls := scada.CallLog()
+	if len(calls) != 2 || calls[0].PlantNo[0] != 2 || calls[1].PlantNo[0] != 1 {
+		t.Errorf("Error: %+v", calls)
+	}
+	if p := scada.Plant(1); p.Ctrl != 1 {
+		t.Errorf("Error: retained command executed, Ctrl %d", p.Ctrl)
+	}
+}
+
+func TestFirstSeenBounded(t *testing.T) {
+	b := &Bridge{}
+	for i := range recentCommands + 1 {
+		if !b.firstSeen(1, strconv.Itoa(i)) {
+			t.Fatalf("Error: new Id %d skipped", i)
+		}
+	}
+	if len(b.seen) != recentCommands || len(b.recent) != recentCommands {
+		t.Errorf("Error: %d Ids remembered", len(b.seen))
+	}
+	// the oldest Id was forgotten, the newest not
+	if !b.firstSeen(1, "0") || b.firstSeen(1, strconv.Itoa(recentCommands)) {
+		t.Errorf("Error: wrong Ids remembered")
+	}
+	if !b.firstSeen(1, "") || !b.firstSeen(1, "") {
+		t.Errorf("Error: command without Id skipped")
+	}
+}
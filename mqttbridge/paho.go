@@ -0,0 +1,31 @@
+package mqttbridge
+
+import (
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Paho adapts a connected paho.mqtt.golang client to the Client of the bridge
+//
+//	c := mqtt.NewClient(mqtt.NewClientOptions().AddBroker("tcp://localhost:1883"))
+//	if t := c.Connect(); t.Wait() && t.Error() != nil { ... }
+//	b := &mqttbridge.Bridge{Client: mqttbridge.Paho{Client: c, QoS: 1}, ...}
+type Paho struct {
+	Client mqtt.Client
+	QoS    byte
+}
+
+func (p Paho) Publish(topic string, payload []byte, retained bool) error {
+	t := p.Client.Publish(topic, p.QoS, retained, payload)
+	t.Wait()
+	return t.Error()
+}
+
+func (p Paho) Subscribe(topic string, handler func(topic string, payload []byte, retained bool)) error {
+	t := p.Client.Subscribe(topic, p.QoS, func(_ mqtt.Client, m mqtt.Message) {
+		handler(m.Topic(), m.Payload(), m.Retained())
+	})
+	t.Wait()
+	return t.Error()
+}
+
+var _ Client = Paho{}
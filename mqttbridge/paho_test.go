@@ -0,0 +1,83 @@
+package mqttbridge
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dernate/energontrol"
+	"github.com/dernate/energontrol/energontroltest"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// TestPaho runs the bridge against the broker in MQTT_BROKER, e.g. tcp://localhost:1883
+func TestPaho(t *testing.T) {
+	broker := os.Getenv("MQTT_BROKER")
+	if broker == "" {
+		t.Skip("MQTT_BROKER not set")
+	}
+	c := mqtt.NewClient(mqtt.NewClientOptions().AddBroker(broker).SetClientID(fmt.Sprintf("energontroltest-%d", time.Now().UnixNano())))
+	if tok := c.Connect(); tok.Wait() && tok.Error() != nil {
+		t.Fatal(tok.Error())
+	}
+	defer c.Disconnect(100)
+	client := Paho{Client: c, QoS: 1}
+	prefix := fmt.Sprintf("energontroltest%d", time.Now().UnixNano())
+	results := make(chan string, 4)
+	started := make(chan struct{}, 4)
+	err := client.Subscribe(prefix+"/1234/plant/+/+", func(topic string, payload []byte, retained bool) {
+		switch {
+		case strings.HasSuffix(topic, "/result"):
+			results <- topic
+		case strings.HasSuffix(topic, "/ctrl"):
+			select {
+			case started <- struct{}{}:
+			default:
+			}
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a retained command from before the start of the bridge, removed at the end
+	if err = client.Publish(prefix+"/1234/plant/1/command", []byte(`{"id": "old", "action": "Stop90"}`), true); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Publish(prefix+"/1234/plant/1/command", nil, true)
+
+	scada := energontroltest.NewScada(1234, 1, 2)
+	b := &Bridge{Client: client, Park: energontrol.ParkConfig{Name: "north"}, Controller: scada, Prefix: prefix, Interval: time.Hour}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go b.Run(ctx)
+	// the bridge subscribed before publishing the state
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Error: bridge not started")
+	}
+	for range 2 {
+		if err = client.Publish(prefix+"/1234/plant/2/command", []byte(`{"id": "c1", "action": "Stop90"}`), false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case topic := <-results:
+		if topic != prefix+"/1234/plant/2/result" {
+			t.Errorf("Error: result on %s", topic)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Error: no result")
+	}
+	select {
+	case topic := <-results:
+		t.Errorf("Error: second result on %s", topic)
+	case <-time.After(500 * time.Millisecond):
+	}
+	if calls := scada.CallLog(); len(calls) != 1 || calls[0].PlantNo[0] != 2 {
+		t.Errorf("Error: %+v", calls)
+	}
+}
@@ -0,0 +1,83 @@
+package energontrol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Observer receives measurements of the package, e.g. to export metrics. Set it globally with SetObserver or per call with WithObserver.
+// Implementations must be safe for concurrent use and should return quickly.
+type Observer interface {
+	// OpcRequest is called after every OPC request. Method is GetStatus, Read, Write or Browse.
+	OpcRequest(Method string, Duration time.Duration, err error)
+	// ServerAvailable is called with the result of ServerAvailable
+	ServerAvailable(Available bool, err error)
+	// Command is called per plant after Start, Stop, Reset, RbhOn, RbhAutoOff, RbhStandard and ControlAndRbh
+	Command(Action string, PlantNo uint8, Ok bool, err error, Duration time.Duration)
+	// SessionPhase is called after waiting for a session state. Phase is the name of the desired state, e.g. "Session reserved".
+	SessionPhase(Phase string, Duration time.Duration)
+	// SessionError is called once per command for a plant, which failed because of its session state, e.g. with 108 (Occupied)
+	// or 175 (Insufficient rights)
+	SessionError(PlantNo uint8, State uint16)
+	// PlantState is called for every read Ctrl or Rbh state
+	PlantState(PlantNo uint8, CtrlOrRbh string, State uint64)
+}
+
+var (
+	observerMu     sync.RWMutex
+	globalObserver Observer
+)
+
+// SetObserver Set the Observer used for all calls without WithObserver. nil disables it.
+func SetObserver(o Observer) {
+	observerMu.Lock()
+	defer observerMu.Unlock()
+	globalObserver = o
+}
+
+// WithObserver returns a context, which reports the measurements of calls with it to o instead of the global Observer
+func WithObserver(ctx context.Context, o Observer) context.Context {
+	return context.WithValue(ctx, observerKey, o)
+}
+
+func observer(ctx context.Context) Observer {
+	if o, ok := ctx.Value(observerKey).(Observer); ok {
+		return o
+	}
+	observerMu.RLock()
+	defer observerMu.RUnlock()
+	return globalObserver
+}
+
+// observeCommand Run a public command in its own span and report its per-plant outcome
+func observeCommand(ctx context.Context, Action string, UserId uint64, PlantNo []uint8, f func(ctx context.Context) ([]bool, []error)) ([]bool, []error) {
+	start := time.Now()
+	ctx = WithLogAttrs(ctx, slog.Uint64(LogKeyUserId, UserId))
+	ctx, span := startSpan(ctx, Action, plantsAttr(PlantNo))
+	ok, errList := f(ctx)
+	errList = credentialErrors(ctx, UserId, errList)
+	var failed []error
+	for i := range PlantNo {
+		if i < len(errList) && errList[i] != nil {
+			failed = append(failed, fmt.Errorf("plant %d: %w", PlantNo[i], errList[i]))
+		} else if i >= len(ok) || !ok[i] {
+			failed = append(failed, fmt.Errorf("plant %d: not done", PlantNo[i]))
+		}
+	}
+	endSpan(span, errors.Join(failed...))
+	if o := observer(ctx); o != nil {
+		Duration := time.Since(start)
+		for i, plant := range PlantNo {
+			var err error
+			if i < len(errList) {
+				err = errList[i]
+			}
+			o.Command(Action, plant, i < len(ok) && ok[i], err, Duration)
+		}
+	}
+	return ok, errList
+}
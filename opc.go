@@ -0,0 +1,104 @@
+package energontrol
+
+import (
+	"context"
+	"github.com/dernate/gopcxmlda"
+	"go.opentelemetry.io/otel/attribute"
+	"time"
+)
+
+// OpcClient the requests energontrol sends to an OPC XML DA server, with the signatures of gopcxmlda.Server.
+// WithOpcClient sends them to another implementation, e.g. energontroltest.OpcServer.
+type OpcClient interface {
+	GetStatus(ctx context.Context, ClientRequestHandle *string, ClientItemHandle string) (gopcxmlda.TServerStatus, error)
+	Read(ctx context.Context, items []gopcxmlda.TItem, ClientRequestHandle *string, ClientItemHandles *[]string, ItemPath string, options map[string]interface{}) (gopcxmlda.TRead, error)
+	Write(ctx context.Context, items []gopcxmlda.TItem, ClientRequestHandle *string, ClientItemHandles *[]string, ItemPath string, options map[string]interface{}) (gopcxmlda.TWrite, error)
+	Browse(ctx context.Context, ItemName string, ClientRequestHandle *string, ItemPath string, options gopcxmlda.TBrowseOptions) (gopcxmlda.TBrowse, error)
+}
+
+// The opc* functions wrap the requests to the Server, so every request is measured and traced at one place.
+// GetStatus, Read and Browse are repeated after transient failures according to the RetryPolicy, Write never.
+
+func opcGetStatus(ctx context.Context, Server gopcxmlda.Server, ClientRequestHandle *string, ClientItemHandle string) (gopcxmlda.TServerStatus, error) {
+	ctx, span := startSpan(ctx, "OPC GetStatus")
+	var status gopcxmlda.TServerStatus
+	err := retry(ctx, "GetStatus", func() error {
+		start := time.Now()
+		var err error
+		if c := opcClient(ctx); c != nil {
+			status, err = c.GetStatus(ctx, ClientRequestHandle, ClientItemHandle)
+		} else {
+			status, err = Server.GetStatus(ctx, ClientRequestHandle, ClientItemHandle)
+		}
+		observeOpc(ctx, "GetStatus", start, err)
+		return err
+	})
+	span.SetAttributes(attribute.String("opc.server_state", status.Response.Result.ServerState))
+	endSpan(span, err)
+	return status, err
+}
+
+func opcRead(ctx context.Context, Server gopcxmlda.Server, items []gopcxmlda.TItem, ClientRequestHandle *string, ClientItemHandles *[]string, ItemPath string, options map[string]interface{}) (gopcxmlda.TRead, error) {
+	ctx, span := startSpan(ctx, "OPC Read", itemsAttr(items))
+	var value gopcxmlda.TRead
+	err := retry(ctx, "Read", func() error {
+		start := time.Now()
+		var err error
+		if c := opcClient(ctx); c != nil {
+			value, err = c.Read(ctx, items, ClientRequestHandle, ClientItemHandles, ItemPath, options)
+		} else {
+			value, err = Server.Read(ctx, items, ClientRequestHandle, ClientItemHandles, ItemPath, options)
+		}
+		observeOpc(ctx, "Read", start, err)
+		return err
+	})
+	endSpan(span, err)
+	return value, err
+}
+
+func opcWrite(ctx context.Context, Server gopcxmlda.Server, items []gopcxmlda.TItem, ClientRequestHandle *string, ClientItemHandles *[]string, ItemPath string, options map[string]interface{}) (gopcxmlda.TWrite, error) {
+	ctx, span := startSpan(ctx, "OPC Write", itemsAttr(items))
+	start := time.Now()
+	var value gopcxmlda.TWrite
+	var err error
+	if c := opcClient(ctx); c != nil {
+		value, err = c.Write(ctx, items, ClientRequestHandle, ClientItemHandles, ItemPath, options)
+	} else {
+		value, err = Server.Write(ctx, items, ClientRequestHandle, ClientItemHandles, ItemPath, options)
+	}
+	observeOpc(ctx, "Write", start, err)
+	endSpan(span, err)
+	return value, err
+}
+
+func opcBrowse(ctx context.Context, Server gopcxmlda.Server, ItemName string, ClientRequestHandle *string, ItemPath string, options gopcxmlda.TBrowseOptions) (gopcxmlda.TBrowse, error) {
+	ctx, span := startSpan(ctx, "OPC Browse", attribute.String("opc.item_name", ItemName))
+	var b gopcxmlda.TBrowse
+	err := retry(ctx, "Browse", func() error {
+		start := time.Now()
+		var err error
+		if c := opcClient(ctx); c != nil {
+			b, err = c.Browse(ctx, ItemName, ClientRequestHandle, ItemPath, options)
+		} else {
+			b, err = Server.Browse(ctx, ItemName, ClientRequestHandle, ItemPath, options)
+		}
+		observeOpc(ctx, "Browse", start, err)
+		return err
+	})
+	endSpan(span, err)
+	return b, err
+}
+
+func observeOpc(ctx context.Context, Method string, start time.Time, err error) {
+	if o := observer(ctx); o != nil {
+		o.OpcRequest(Method, time.Since(start), err)
+	}
+}
+
+func itemsAttr(items []gopcxmlda.TItem) attribute.KeyValue {
+	names := make([]string, len(items))
+	for i, item := range items {
+		names[i] = item.ItemName
+	}
+	return attribute.StringSlice("opc.item_names", names)
+}
@@ -0,0 +1,102 @@
+package energontrol
+
+import (
+	"context"
+	"crypto/rand"
+	"io"
+	"time"
+)
+
+type ctxKey int
+
+const (
+	sessionWaitKey ctxKey = iota
+	observerKey
+	tracerProviderKey
+	loggerKey
+	logAttrsKey
+	discoveryConcurrencyKey
+	retryPolicyKey
+	sessionRandomKey
+	operatorKey
+	opcClientKey
+)
+
+// defaultDiscoveryConcurrency plants browsed at the same time by Turbines
+const defaultDiscoveryConcurrency = 4
+
+var defaultSessionWait = WaitForState{
+	Sleep:   100 * time.Millisecond,
+	Retries: 10,
+}
+
+// WithSessionWait returns a context, which lets the session handshake poll the SessionState with the given Sleep and Retries
+// instead of the default of 10 retries every 100ms.
+func WithSessionWait(ctx context.Context, Sleep time.Duration, Retries uint) context.Context {
+	return context.WithValue(ctx, sessionWaitKey, WaitForState{Sleep: Sleep, Retries: Retries})
+}
+
+// sessionWait returns the WaitForState for the Desired session state, considering a timing set by WithSessionWait
+func sessionWait(ctx context.Context, Desired uint16) WaitForState {
+	WaitFor := defaultSessionWait
+	if w, ok := ctx.Value(sessionWaitKey).(WaitForState); ok {
+		WaitFor = w
+	}
+	WaitFor.Desired = Desired
+	return WaitFor
+}
+
+// WithDiscoveryConcurrency returns a context, which lets Turbines browse n plants at the same time instead of 4.
+// n below 1 browses one plant at a time.
+func WithDiscoveryConcurrency(ctx context.Context, n int) context.Context {
+	return context.WithValue(ctx, discoveryConcurrencyKey, n)
+}
+
+// discoveryConcurrency returns the number of plants browsed at the same time, considering WithDiscoveryConcurrency
+func discoveryConcurrency(ctx context.Context) int {
+	n, ok := ctx.Value(discoveryConcurrencyKey).(int)
+	if !ok {
+		return defaultDiscoveryConcurrency
+	}
+	return max(n, 1)
+}
+
+// WithRetryPolicy returns a context, which repeats the idempotent OPC requests after transient failures with Policy
+// instead of DefaultRetryPolicy
+func WithRetryPolicy(ctx context.Context, Policy RetryPolicy) context.Context {
+	return context.WithValue(ctx, retryPolicyKey, Policy)
+}
+
+// retryPolicy returns the RetryPolicy of ctx, considering WithRetryPolicy
+func retryPolicy(ctx context.Context) RetryPolicy {
+	if p, ok := ctx.Value(retryPolicyKey).(RetryPolicy); ok {
+		return p
+	}
+	return DefaultRetryPolicy
+}
+
+// WithSessionRandom returns a context, which generates the SessionId and PrivateKey of session requests from r
+// instead of crypto/rand, e.g. a deterministic source in tests
+func WithSessionRandom(ctx context.Context, r io.Reader) context.Context {
+	return context.WithValue(ctx, sessionRandomKey, r)
+}
+
+// sessionRandom returns the random source for session requests, considering WithSessionRandom
+func sessionRandom(ctx context.Context) io.Reader {
+	if r, ok := ctx.Value(sessionRandomKey).(io.Reader); ok {
+		return r
+	}
+	return rand.Reader
+}
+
+// WithOpcClient returns a context, which sends the OPC requests to c instead of the Server passed to the functions,
+// e.g. an energontroltest.OpcServer in tests
+func WithOpcClient(ctx context.Context, c OpcClient) context.Context {
+	return context.WithValue(ctx, opcClientKey, c)
+}
+
+// opcClient returns the OpcClient set by WithOpcClient, or nil to use the Server
+func opcClient(ctx context.Context) OpcClient {
+	c, _ := ctx.Value(opcClientKey).(OpcClient)
+	return c
+}
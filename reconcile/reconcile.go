@@ -0,0 +1,353 @@
+// Package reconcile keeps plants in a declared state: a Reconciler periodically reads Ctrl and Rbh of the plants and calls
+// ControlAndRbh only for plants deviating from their desired state.
+//
+// Ctrl states that can't be overridden (above 128) are left alone, plants with a communication error (255) are skipped.
+// A change between Stop60 and Stop90 needs an explicit stop, which Stop with ForceExplicitCommand does.
+// Plants whose session is occupied by someone else are retried with an exponential backoff.
+package reconcile
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dernate/energontrol"
+	"gopkg.in/yaml.v3"
+)
+
+// Desired state of a plant, empty fields are not reconciled
+type Desired struct {
+	Ctrl string `yaml:"ctrl" json:"ctrl,omitempty"` // Start, Stop60 or Stop90
+	Rbh  string `yaml:"rbh" json:"rbh,omitempty"`   // Standard, AutoOff or ManualOn
+}
+
+// Result a reconciliation of a deviating plant
+type Result struct {
+	Time    time.Time `json:"time"`
+	PlantNo uint8     `json:"plant_no"`
+	Desired Desired   `json:"desired"`
+	Ctrl    uint64    `json:"ctrl"`
+	Rbh     uint64    `json:"rbh"`
+	Actions []string  `json:"actions,omitempty"`
+	Ok      bool      `json:"ok"`
+	Error   string    `json:"error,omitempty"`
+	Skipped string    `json:"skipped,omitempty"` // why a deviation was not corrected
+
+	sessionErr bool // a session was occupied
+}
+
+// Reconciler reconciles the plants of one park
+type Reconciler struct {
+	Park       energontrol.ParkConfig
+	Controller energontrol.Controller
+	UserId     uint64
+	Interval   time.Duration // reconciliation interval of Run, 1m if 0
+	Backoff    time.Duration // first wait after an occupied session, doubled on every further one, 1m if 0
+	MaxBackoff time.Duration // 30m if 0
+
+	mu      sync.Mutex
+	desired map[uint8]Desired
+	backoff map[uint8]backoff
+}
+
+type backoff struct {
+	until time.Time
+	delay time.Duration
+}
+
+// LoadDesired Read the desired states from a YAML file, which maps plant numbers or aliases of the park to a Desired
+func LoadDesired(path string, Park energontrol.ParkConfig) (map[uint8]Desired, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var byName map[string]Desired
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err = dec.Decode(&byName); err != nil {
+		return nil, fmt.Errorf("desired state: %w", err)
+	}
+	desired := make(map[uint8]Desired)
+	var errList []error
+	for name, d := range byName {
+		PlantNo, err := Park.ResolvePlants(name)
+		if err != nil {
+			errList = append(errList, err)
+			continue
+		}
+		if _, exists := desired[PlantNo[0]]; exists {
+			errList = append(errList, fmt.Errorf("plant %d is declared more than once", PlantNo[0]))
+		}
+		desired[PlantNo[0]] = d
+	}
+	if err = errors.Join(errList...); err != nil {
+		return nil, err
+	}
+	return desired, ValidateDesired(desired)
+}
+
+// ValidateDesired Check the Ctrl and Rbh names
+func ValidateDesired(desired map[uint8]Desired) error {
+	var errList []error
+	for plant, d := range desired {
+		switch d.Ctrl {
+		case "", "Start", "Stop60", "Stop90":
+		default:
+			errList = append(errList, fmt.Errorf("plant %d: ctrl %q is not Start, Stop60 or Stop90", plant, d.Ctrl))
+		}
+		switch d.Rbh {
+		case "", "Standard", "AutoOff", "ManualOn":
+		default:
+			errList = append(errList, fmt.Errorf("plant %d: rbh %q is not Standard, AutoOff or ManualOn", plant, d.Rbh))
+		}
+	}
+	return errors.Join(errList...)
+}
+
+// SetDesired Replace the desired states
+func (r *Reconciler) SetDesired(desired map[uint8]Desired) error {
+	if err := ValidateDesired(desired); err != nil {
+		return err
+	}
+	copied := make(map[uint8]Desired, len(desired))
+	for plant, d := range desired {
+		if !r.Park.PlantAllowed(plant) {
+			return fmt.Errorf("plant %d is not allowed in park %q", plant, r.Park.Name)
+		}
+		copied[plant] = d
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.desired = copied
+	return nil
+}
+
+// Run Reconcile every Interval until ctx is done
+func (r *Reconciler) Run(ctx context.Context) error {
+	interval := r.Interval
+	if interval == 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := r.Reconcile(ctx, time.Now()); err != nil {
+			r.log(ctx, slog.LevelError, 0, err.Error())
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// deviation what has to be done for a plant
+type deviation struct {
+	result  *Result
+	ctrl    bool // ControlAndRbh with the Ctrl value
+	stop    bool // Stop with ForceExplicitCommand, between Stop60 and Stop90
+	rbh     bool
+	ctrlVal uint64
+	rbhVal  uint64
+}
+
+// Reconcile Compare the plants not backing off with their desired state at now and correct the deviations
+func (r *Reconciler) Reconcile(ctx context.Context, now time.Time) ([]Result, error) {
+	r.mu.Lock()
+	if r.backoff == nil {
+		r.backoff = make(map[uint8]backoff)
+	}
+	var PlantNo []uint8
+	desired := make(map[uint8]Desired)
+	for plant, d := range r.desired {
+		if r.backoff[plant].until.After(now) {
+			continue
+		}
+		PlantNo = append(PlantNo, plant)
+		desired[plant] = d
+	}
+	r.mu.Unlock()
+	if len(PlantNo) == 0 {
+		return nil, nil
+	}
+	sort.Slice(PlantNo, func(i, j int) bool { return PlantNo[i] < PlantNo[j] })
+	pctx := r.Park.Context(ctx)
+	ctrl, err := r.Controller.State(pctx, "Ctrl", PlantNo)
+	if err != nil {
+		return nil, fmt.Errorf("read Ctrl state: %w", err)
+	}
+	rbh, err := r.Controller.State(pctx, "Rbh", PlantNo)
+	if err != nil {
+		return nil, fmt.Errorf("read Rbh state: %w", err)
+	}
+
+	var deviations []*deviation
+	for i, plant := range PlantNo {
+		if i >= len(ctrl) || i >= len(rbh) {
+			break
+		}
+		d := desired[plant]
+		dev := &deviation{result: &Result{Time: now, PlantNo: plant, Desired: d, Ctrl: ctrl[i].CtrlState, Rbh: rbh[i].CtrlState}}
+		if err := errors.Join(ctrl[i].Err, rbh[i].Err); err != nil {
+			dev.result.Error = fmt.Sprintf("read state: %s", err)
+			deviations = append(deviations, dev)
+			continue
+		}
+		if ctrl[i].CtrlState == energontrol.CtrlValues["CommunicationError"] {
+			dev.result.Skipped = "communication error"
+			deviations = append(deviations, dev)
+			continue
+		}
+		if d.Ctrl != "" && ctrl[i].CtrlState != energontrol.CtrlValues[d.Ctrl] {
+			dev.ctrlVal = energontrol.CtrlValues[d.Ctrl]
+			switch {
+			case ctrl[i].CtrlState > 128:
+				dev.result.Skipped = fmt.Sprintf("Ctrl state %s can't be overridden", energontrol.CtrlStateText(ctrl[i].CtrlState))
+			case ctrl[i].CtrlState != 0 && dev.ctrlVal != 0:
+				dev.stop = true
+			default:
+				dev.ctrl = true
+			}
+		}
+		if d.Rbh != "" && !energontrol.RbhMatches(rbh[i].CtrlState, energontrol.RbhValues[d.Rbh]) {
+			dev.rbh = true
+			dev.rbhVal = energontrol.RbhValues[d.Rbh]
+		}
+		if dev.ctrl || dev.stop || dev.rbh || dev.result.Skipped != "" {
+			deviations = append(deviations, dev)
+		}
+	}
+
+	// one ControlAndRbh per combination of values, one Stop per stop value
+	type group struct {
+		ctrl, rbh       bool
+		ctrlVal, rbhVal uint64
+	}
+	groups := make(map[group][]*deviation)
+	var order []group
+	stops := make(map[uint64][]*deviation)
+	for _, dev := range deviations {
+		if dev.ctrl || dev.rbh {
+			g := group{ctrl: dev.ctrl, rbh: dev.rbh}
+			if dev.ctrl {
+				g.ctrlVal = dev.ctrlVal
+			}
+			if dev.rbh {
+				g.rbhVal = dev.rbhVal
+			}
+			if _, ok := groups[g]; !ok {
+				order = append(order, g)
+			}
+			groups[g] = append(groups[g], dev)
+		}
+		if dev.stop {
+			stops[dev.ctrlVal] = append(stops[dev.ctrlVal], dev)
+		}
+	}
+	for _, g := range order {
+		Values := energontrol.ControlAndRbhValue{SetCtrlValue: g.ctrl, CtrlValue: g.ctrlVal, SetRbhValue: g.rbh, RbhValue: g.rbhVal}
+		action := "ControlAndRbh"
+		if g.ctrl {
+			action += " Ctrl " + energontrol.CtrlStateText(g.ctrlVal)
+		}
+		if g.rbh {
+			action += fmt.Sprintf(" Rbh %d", g.rbhVal)
+		}
+		devs := groups[g]
+		ok, errList := r.Controller.ControlAndRbh(pctx, r.UserId, Values, plantsOf(devs)...)
+		apply(devs, action, ok, errList)
+	}
+	for _, val := range []uint64{energontrol.CtrlValues["Stop60"], energontrol.CtrlValues["Stop90"]} {
+		if devs := stops[val]; len(devs) > 0 {
+			ok, errList := r.Controller.Stop(pctx, r.UserId, val == energontrol.CtrlValues["Stop90"], true, plantsOf(devs)...)
+			apply(devs, "Stop "+energontrol.CtrlStateText(val)+" (forced)", ok, errList)
+		}
+	}
+
+	var results []Result
+	for _, dev := range deviations {
+		res := dev.result
+		if len(res.Actions) > 0 && res.Error == "" {
+			res.Ok = true
+		}
+		r.updateBackoff(res.PlantNo, now, res.sessionErr)
+		msg := fmt.Sprintf("desired %+v, Ctrl %s, Rbh %d, actions %v: ok=%t", res.Desired, energontrol.CtrlStateText(res.Ctrl), res.Rbh, res.Actions, res.Ok)
+		switch {
+		case res.Error != "":
+			r.log(ctx, slog.LevelWarn, res.PlantNo, msg+", "+res.Error)
+		case res.Skipped != "":
+			r.log(ctx, slog.LevelWarn, res.PlantNo, msg+", skipped: "+res.Skipped)
+		default:
+			r.log(ctx, slog.LevelInfo, res.PlantNo, msg)
+		}
+		results = append(results, *res)
+	}
+	return results, nil
+}
+
+func plantsOf(devs []*deviation) []uint8 {
+	PlantNo := make([]uint8, len(devs))
+	for i, dev := range devs {
+		PlantNo[i] = dev.result.PlantNo
+	}
+	return PlantNo
+}
+
+// apply Record the outcome of a command for the deviations it was sent for
+func apply(devs []*deviation, action string, ok []bool, errList []error) {
+	for i, dev := range devs {
+		dev.result.Actions = append(dev.result.Actions, action)
+		var err error
+		if i < len(errList) {
+			err = errList[i]
+		}
+		if err == nil && (i >= len(ok) || !ok[i]) {
+			err = errors.New(action + " not done")
+		}
+		if err != nil {
+			if dev.result.Error != "" {
+				dev.result.Error += "; "
+			}
+			dev.result.Error += err.Error()
+			var se *energontrol.SessionError
+			if errors.As(err, &se) && se.Occupied() {
+				dev.result.sessionErr = true
+			}
+		}
+	}
+}
+
+// updateBackoff Back off a plant with an occupied session, reset the backoff otherwise
+func (r *Reconciler) updateBackoff(PlantNo uint8, now time.Time, occupied bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !occupied {
+		delete(r.backoff, PlantNo)
+		return
+	}
+	delay := r.backoff[PlantNo].delay * 2
+	if delay == 0 {
+		delay = r.Backoff
+		if delay == 0 {
+			delay = time.Minute
+		}
+	}
+	maxBackoff := r.MaxBackoff
+	if maxBackoff == 0 {
+		maxBackoff = 30 * time.Minute
+	}
+	delay = min(delay, maxBackoff)
+	r.backoff[PlantNo] = backoff{until: now.Add(delay), delay: delay}
+}
+
+func (r *Reconciler) log(ctx context.Context, level slog.Level, PlantNo uint8, msg string) {
+	ctx = r.Park.Context(ctx)
+	energontrol.Logger(ctx).LogAttrs(ctx, level, msg, slog.Int(energontrol.LogKeyPlantNo, int(PlantNo)), slog.String(energontrol.LogKeyAction, "Reconcile"))
+}
@@ -0,0 +1,115 @@
+package reconcile
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dernate/energontrol"
+	"github.com/dernate/energontrol/energontroltest"
+)
+
+func TestReconcile(t *testing.T) {
+	scada := energontroltest.NewScada(4711, 1, 2, 3, 4, 5)
+	scada.SetPlant(2, energontroltest.Plant{Ctrl: energontrol.CtrlValues["Stop60"], Rbh: energontroltest.RbhOnState})
+	scada.SetPlant(3, energontroltest.Plant{Ctrl: energontrol.CtrlValues["Stop60"], Rbh: energontroltest.RbhStandardState})
+	scada.SetPlant(4, energontroltest.Plant{Ctrl: energontrol.CtrlValues["StopEnercon"], Rbh: energontroltest.RbhStandardState})
+	scada.SetPlant(5, energontroltest.Plant{Ctrl: energontrol.CtrlValues["Stop60"], Rbh: energontroltest.RbhStandardState, SessionState: 108})
+	r := &Reconciler{Park: energontrol.ParkConfig{Name: "north"}, Controller: scada, UserId: 1234}
+	err := r.SetDesired(map[uint8]Desired{
+		1: {Ctrl: "Start", Rbh: "Standard"},
+		2: {Ctrl: "Start", Rbh: "Standard"},
+		3: {Ctrl: "Stop90", Rbh: "AutoOff"},
+		4: {Ctrl: "Start"},
+		5: {Ctrl: "Start"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t0 := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	results, err := r.Reconcile(context.Background(), t0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[uint8]Result)
+	for _, res := range results {
+		got[res.PlantNo] = res
+	}
+	if _, ok := got[1]; ok || len(results) != 4 {
+		t.Errorf("Error: results %+v", results)
+	}
+	if !got[2].Ok || !got[3].Ok || len(got[3].Actions) != 2 {
+		t.Errorf("Error: plant 2 %+v, plant 3 %+v", got[2], got[3])
+	}
+	if got[4].Skipped == "" || len(got[4].Actions) != 0 {
+		t.Errorf("Error: plant 4 %+v", got[4])
+	}
+	if got[5].Ok || got[5].Error == "" {
+		t.Errorf("Error: plant 5 %+v", got[5])
+	}
+	if p := scada.Plant(2); p.Ctrl != 0 || p.Rbh != energontroltest.RbhStandardState {
+		t.Errorf("Error: plant 2 %+v", p)
+	}
+	if p := scada.Plant(3); p.Ctrl != energontrol.CtrlValues["Stop90"] || p.Rbh != energontroltest.RbhAutoOffState {
+		t.Errorf("Error: plant 3 %+v", p)
+	}
+
+	// plant 5 backs off for a minute, then for two
+	steps := []struct {
+		at     time.Duration
+		plant5 bool
+	}{
+		{30 * time.Second, false},
+		{time.Minute, true},
+		{2 * time.Minute, false},
+		{3 * time.Minute, true},
+	}
+	for _, step := range steps {
+		results, err = r.Reconcile(context.Background(), t0.Add(step.at))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tried := false
+		for _, res := range results {
+			if res.PlantNo == 5 {
+				tried = len(res.Actions) > 0
+			} else if res.PlantNo != 4 {
+				t.Errorf("Error at %s: plant %d reconciled again: %+v", step.at, res.PlantNo, res)
+			}
+		}
+		if tried != step.plant5 {
+			t.Errorf("Error at %s: plant 5 tried %t, want %t", step.at, tried, step.plant5)
+		}
+	}
+	scada.SetPlant(5, energontroltest.Plant{Ctrl: energontrol.CtrlValues["Stop60"], Rbh: energontroltest.RbhStandardState})
+	if _, err = r.Reconcile(context.Background(), t0.Add(10*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if p := scada.Plant(5); p.Ctrl != 0 {
+		t.Errorf("Error: plant 5 %+v", p)
+	}
+}
+
+func TestLoadDesired(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "desired.yaml")
+	data := "2: {ctrl: Start, rbh: Standard}\nhill: {ctrl: Stop90, rbh: AutoOff}\n"
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	park := energontrol.ParkConfig{Name: "north", Aliases: map[string]uint8{"hill": 4}}
+	desired, err := LoadDesired(path, park)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desired[2] != (Desired{Ctrl: "Start", Rbh: "Standard"}) || desired[4] != (Desired{Ctrl: "Stop90", Rbh: "AutoOff"}) {
+		t.Errorf("Error: %+v", desired)
+	}
+	if err = os.WriteFile(path, []byte("2: {ctrl: Stop45}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = LoadDesired(path, park); err == nil {
+		t.Errorf("Error: invalid ctrl accepted")
+	}
+}
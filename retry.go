@@ -0,0 +1,88 @@
+package energontrol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy how idempotent OPC requests (GetStatus, Read and Browse) are repeated after a transient transport failure.
+// Writes (SessionRequest, SetCtrl, SetRbh, SetReset, SessionSubmit) are never repeated, the session state is read instead
+// to find out, if they took effect.
+type RetryPolicy struct {
+	Attempts   int                  // tries of a request including the first, 1 or less disables retries
+	Backoff    time.Duration        // wait before the first retry, doubled with every further retry
+	MaxBackoff time.Duration        // upper limit of the wait, none if 0
+	Jitter     float64              // random fraction of the wait added or removed, e.g. 0.2 for ±20%
+	Retryable  func(err error) bool // decides if a failure is transient, TransientError if nil
+}
+
+// DefaultRetryPolicy used without WithRetryPolicy
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts:   3,
+	Backoff:    200 * time.Millisecond,
+	MaxBackoff: 2 * time.Second,
+	Jitter:     0.2,
+}
+
+// TransientError Check if err is a transport failure, which may pass when repeated: a timeout, a refused, reset or aborted
+// connection or an unexpected EOF. Errors of items and a cancelled context are not transient.
+func TransientError(err error) bool {
+	var itemErr *ItemError
+	if err == nil || errors.Is(err, context.Canceled) || errors.As(err, &itemErr) {
+		return false
+	}
+	var netErr net.Error
+	var opErr *net.OpError
+	return errors.As(err, &netErr) && netErr.Timeout() ||
+		errors.As(err, &opErr) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, syscall.EPIPE)
+}
+
+// wait Get the jittered wait before retry n, starting at 1
+func (p RetryPolicy) wait(n int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < n && (p.MaxBackoff == 0 || d < p.MaxBackoff); i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if p.Jitter > 0 {
+		d += time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(d))
+	}
+	return max(d, 0)
+}
+
+// retry Call f until it succeeds, fails permanently, the attempts of the policy are used up or ctx is done
+func retry(ctx context.Context, Method string, f func() error) error {
+	p := retryPolicy(ctx)
+	retryable := p.Retryable
+	if retryable == nil {
+		retryable = TransientError
+	}
+	for n := 1; ; n++ {
+		err := f()
+		if err == nil || n >= p.Attempts || !retryable(err) || ctx.Err() != nil {
+			return err
+		}
+		wait := p.wait(n)
+		Logger(ctx).LogAttrs(ctx, slog.LevelDebug, fmt.Sprintf("OPC %s failed, retry %d of %d in %s: %s", Method, n, p.Attempts-1, wait, err))
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package energontrol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRetry(t *testing.T) {
+	ctx := WithRetryPolicy(context.Background(), RetryPolicy{Attempts: 3, Backoff: time.Millisecond, Jitter: 0.5})
+	calls := 0
+	err := retry(ctx, "Read", func() error {
+		if calls++; calls < 3 {
+			return fmt.Errorf("post: %w", syscall.ECONNRESET)
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("Error: %d calls, %v", calls, err)
+	}
+
+	// the attempts are limited
+	calls = 0
+	if err = retry(ctx, "Read", func() error { calls++; return io.ErrUnexpectedEOF }); err == nil || calls != 3 {
+		t.Errorf("Error: %d calls, %v", calls, err)
+	}
+
+	// item errors and other permanent failures aren't repeated
+	for _, permanent := range []error{&ItemError{ItemName: "x", ResultID: "E_UNKNOWNITEMNAME"}, errors.New("SOAP fault"), context.Canceled} {
+		calls = 0
+		if err = retry(ctx, "Read", func() error { calls++; return permanent }); err == nil || calls != 1 {
+			t.Errorf("Error: %v repeated %d times", permanent, calls)
+		}
+	}
+
+	// no retries with a single attempt
+	calls = 0
+	ctx = WithRetryPolicy(context.Background(), RetryPolicy{Attempts: 1})
+	if err = retry(ctx, "Read", func() error { calls++; return io.EOF }); err == nil || calls != 1 {
+		t.Errorf("Error: %d calls, %v", calls, err)
+	}
+}
+
+func TestRetryWait(t *testing.T) {
+	p := RetryPolicy{Backoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond}
+	for n, want := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 3: 300 * time.Millisecond, 10: 300 * time.Millisecond} {
+		if got := p.wait(n); got != want {
+			t.Errorf("Error: wait %d is %s, want %s", n, got, want)
+		}
+	}
+	p.Jitter = 0.2
+	for range 20 {
+		if got := p.wait(1); got < 80*time.Millisecond || got > 120*time.Millisecond {
+			t.Errorf("Error: jittered wait %s", got)
+		}
+	}
+}
+
+func TestWriteUncertain(t *testing.T) {
+	if writeUncertain(nil) || writeUncertain(fmt.Errorf("write: %w", &ItemError{ItemName: "x", ResultID: "E_BADTYPE"})) {
+		t.Errorf("Error: answered write is uncertain")
+	}
+	if !writeUncertain(io.ErrUnexpectedEOF) {
+		t.Errorf("Error: failed transport is certain")
+	}
+}
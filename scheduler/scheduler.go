@@ -0,0 +1,449 @@
+// Package scheduler runs energontrol actions at scheduled times, e.g. noise reduction stops every night
+// or a Stop90 for a service window.
+//
+// A Rule is either recurring with a cron expression ("0 22 * * *") or one-shot at a time ("2026-10-20 08:00"),
+// both in the rule's time zone or the scheduler's Location. Rules, their last run and the results of the executions
+// are saved to StatePath, so pending jobs survive a restart. Jobs missed by more than MaxDelay, e.g. while the process
+// was down, are not executed but recorded as skipped.
+package scheduler
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dernate/energontrol"
+	"gopkg.in/yaml.v3"
+)
+
+// maxResults results kept in the state
+const maxResults = 1000
+
+// Rule a scheduled action for plants of a park. Exactly one of Cron and At has to be set.
+type Rule struct {
+	Id       string   `yaml:"id" json:"id"`
+	Park     string   `yaml:"park" json:"park"`
+	Action   string   `yaml:"action" json:"action"` // an energontrol Action* constant
+	Plants   []string `yaml:"plants" json:"plants"` // plant numbers or aliases of the park
+	Force    bool     `yaml:"force" json:"force,omitempty"`
+	Cron     string   `yaml:"cron" json:"cron,omitempty"`
+	At       string   `yaml:"at" json:"at,omitempty"`               // RFC 3339 or "2006-01-02 15:04"
+	TimeZone string   `yaml:"time_zone" json:"time_zone,omitempty"` // IANA name, e.g. "Europe/Berlin"
+
+	Created time.Time `yaml:"-" json:"created"`
+	LastRun time.Time `yaml:"-" json:"last_run"`
+}
+
+// Result the outcome of one execution of a Rule
+type Result struct {
+	RuleId    string    `json:"rule_id"`
+	Park      string    `json:"park"`
+	Action    string    `json:"action"`
+	Plants    []uint8   `json:"plants"`
+	Scheduled time.Time `json:"scheduled"`
+	Executed  time.Time `json:"executed"`
+	Ok        []bool    `json:"ok"`
+	Errors    []string  `json:"errors,omitempty"`  // per plant, "" without error
+	Skipped   string    `json:"skipped,omitempty"` // why the rule was not executed
+}
+
+// Park a park the scheduler can control
+type Park struct {
+	Config     energontrol.ParkConfig
+	Controller energontrol.Controller
+}
+
+// Scheduler executes Rules for Parks
+type Scheduler struct {
+	Parks     []Park
+	StatePath string         // rules and results are saved here, nothing is saved if empty
+	Location  *time.Location // time zone of rules without TimeZone, time.Local if nil
+	MaxDelay  time.Duration  // jobs later than this are skipped, 1h if 0
+	Interval  time.Duration  // how often Run checks for due jobs, 30s if 0
+
+	mu      sync.Mutex
+	rules   []Rule
+	results []Result
+	now     func() time.Time // time.Now, replaced by tests
+}
+
+type state struct {
+	Rules   []Rule   `json:"rules"`
+	Results []Result `json:"results"`
+}
+
+// LoadRules Read rules from a YAML file with a list of rules
+func LoadRules(path string) ([]Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []Rule
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err = dec.Decode(&rules); err != nil {
+		return nil, fmt.Errorf("rules: %w", err)
+	}
+	return rules, nil
+}
+
+// MaintenanceWindow Create two one-shot rules, which run Action for the plants at From and EndAction at To,
+// e.g. Stop90 and Start for a service
+func MaintenanceWindow(Id string, Park string, Plants []string, Action string, EndAction string, From time.Time, To time.Time) []Rule {
+	return []Rule{
+		{Id: Id + "-begin", Park: Park, Action: Action, Plants: Plants, Force: true, At: From.Format(time.RFC3339)},
+		{Id: Id + "-end", Park: Park, Action: EndAction, Plants: Plants, At: To.Format(time.RFC3339)},
+	}
+}
+
+// Load Read rules and results from StatePath. A missing file is no error.
+func (s *Scheduler) Load() error {
+	if s.StatePath == "" {
+		return nil
+	}
+	data, err := os.ReadFile(s.StatePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var st state
+	if err = json.Unmarshal(data, &st); err != nil {
+		return fmt.Errorf("scheduler state %s: %w", s.StatePath, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rules = st.Rules
+	s.results = st.Results
+	return nil
+}
+
+// Add Validate and add the rule, or replace the rule with the same Id keeping its last run. Rules without Id get a random one.
+func (s *Scheduler) Add(r Rule) (Rule, error) {
+	if r.Id == "" {
+		r.Id = newId()
+	}
+	if err := s.validate(r); err != nil {
+		return Rule{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r = s.keepRun(r)
+	replaced := false
+	for i := range s.rules {
+		if s.rules[i].Id == r.Id {
+			s.rules[i] = r
+			replaced = true
+		}
+	}
+	if !replaced {
+		s.rules = append(s.rules, r)
+	}
+	return r, s.save()
+}
+
+// SetRules Replace all rules, e.g. with the rules of a config file. Rules with an already known Id keep their last run,
+// so a one-shot rule is not executed again.
+func (s *Scheduler) SetRules(rules []Rule) error {
+	var errList []error
+	ids := make(map[string]bool)
+	for _, r := range rules {
+		if ids[r.Id] {
+			errList = append(errList, fmt.Errorf("rule %q is defined more than once", r.Id))
+		}
+		ids[r.Id] = true
+		if r.Id == "" {
+			errList = append(errList, errors.New("rule without id"))
+		} else if err := s.validate(r); err != nil {
+			errList = append(errList, err)
+		}
+	}
+	if err := errors.Join(errList...); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	newRules := make([]Rule, len(rules))
+	for i, r := range rules {
+		newRules[i] = s.keepRun(r)
+	}
+	s.rules = newRules
+	return s.save()
+}
+
+// Remove Delete the rule with Id
+func (s *Scheduler) Remove(Id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.rules {
+		if s.rules[i].Id == Id {
+			s.rules = append(s.rules[:i], s.rules[i+1:]...)
+			return s.save()
+		}
+	}
+	return fmt.Errorf("rule %q not found", Id)
+}
+
+// Rules Get a copy of all rules
+func (s *Scheduler) Rules() []Rule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Rule(nil), s.rules...)
+}
+
+// Results Get a copy of the recorded results, oldest first
+func (s *Scheduler) Results() []Result {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Result(nil), s.results...)
+}
+
+// Next Get the next time the rule is due, or zero if a one-shot rule already ran
+func (s *Scheduler) Next(r Rule) (time.Time, error) {
+	loc, err := s.location(r)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if r.Cron != "" {
+		c, err := parseCron(r.Cron)
+		if err != nil {
+			return time.Time{}, err
+		}
+		after := r.LastRun
+		if after.IsZero() {
+			after = r.Created
+		}
+		return c.next(after, loc), nil
+	}
+	if !r.LastRun.IsZero() {
+		return time.Time{}, nil
+	}
+	return parseAt(r.At, loc)
+}
+
+// Run Execute due rules every Interval until ctx is done. Call Load first to continue with the saved rules.
+func (s *Scheduler) Run(ctx context.Context) error {
+	interval := s.Interval
+	if interval == 0 {
+		interval = 30 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		s.RunDue(ctx, s.clock())
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunDue Execute all rules due at now in the order of their scheduled time and record the results
+func (s *Scheduler) RunDue(ctx context.Context, now time.Time) []Result {
+	type dueRule struct {
+		rule      Rule
+		scheduled time.Time
+	}
+	var due []dueRule
+	s.mu.Lock()
+	for _, r := range s.rules {
+		next, err := s.Next(r)
+		if err == nil && !next.IsZero() && !next.After(now) {
+			due = append(due, dueRule{r, next})
+		}
+	}
+	s.mu.Unlock()
+	sort.SliceStable(due, func(i, j int) bool { return due[i].scheduled.Before(due[j].scheduled) })
+
+	maxDelay := s.MaxDelay
+	if maxDelay == 0 {
+		maxDelay = time.Hour
+	}
+	var results []Result
+	for _, d := range due {
+		var result Result
+		if delay := now.Sub(d.scheduled); delay > maxDelay {
+			result = Result{RuleId: d.rule.Id, Park: d.rule.Park, Action: d.rule.Action, Scheduled: d.scheduled, Executed: now,
+				Skipped: fmt.Sprintf("missed by %s", delay.Round(time.Second))}
+		} else {
+			result = s.execute(ctx, d.rule, d.scheduled)
+		}
+		results = append(results, result)
+		s.mu.Lock()
+		for i := range s.rules {
+			if s.rules[i].Id == d.rule.Id {
+				s.rules[i].LastRun = now
+			}
+		}
+		s.results = append(s.results, result)
+		if len(s.results) > maxResults {
+			s.results = s.results[len(s.results)-maxResults:]
+		}
+		err := s.save()
+		s.mu.Unlock()
+		if err != nil {
+			s.log(ctx, slog.LevelError, d.rule, 0, err.Error())
+		}
+	}
+	return results
+}
+
+// execute Run the action of the rule and log the outcome per plant
+func (s *Scheduler) execute(ctx context.Context, r Rule, scheduled time.Time) Result {
+	result := Result{RuleId: r.Id, Park: r.Park, Action: r.Action, Scheduled: scheduled}
+	p, err := s.park(r.Park)
+	var UserId uint64
+	if err == nil {
+		result.Plants, err = p.Config.ResolvePlants(r.Plants...)
+	}
+	if err == nil {
+		UserId, err = p.Config.ResolveUserId()
+	}
+	if err != nil {
+		result.Executed = s.clock()
+		result.Skipped = err.Error()
+		s.log(ctx, slog.LevelError, r, 0, err.Error())
+		return result
+	}
+	ok, errList := energontrol.Do(p.Config.Context(ctx), p.Controller, UserId, r.Action, r.Force, result.Plants...)
+	result.Executed = s.clock()
+	result.Ok = make([]bool, len(result.Plants))
+	for i, plant := range result.Plants {
+		result.Ok[i] = i < len(ok) && ok[i]
+		msg := fmt.Sprintf("%s by rule %s: ok=%t", r.Action, r.Id, result.Ok[i])
+		if i < len(errList) && errList[i] != nil {
+			if result.Errors == nil {
+				result.Errors = make([]string, len(result.Plants))
+			}
+			result.Errors[i] = errList[i].Error()
+			s.log(ctx, slog.LevelWarn, r, plant, msg+", "+result.Errors[i])
+		} else {
+			s.log(ctx, slog.LevelInfo, r, plant, msg)
+		}
+	}
+	return result
+}
+
+func (s *Scheduler) validate(r Rule) error {
+	p, err := s.park(r.Park)
+	if err != nil {
+		return fmt.Errorf("rule %q: %w", r.Id, err)
+	}
+	if !energontrol.ValidAction(r.Action) {
+		return fmt.Errorf("rule %q: unknown action %q", r.Id, r.Action)
+	}
+	if len(r.Plants) == 0 {
+		return fmt.Errorf("rule %q: no plants", r.Id)
+	}
+	if _, err = p.Config.ResolvePlants(r.Plants...); err != nil {
+		return fmt.Errorf("rule %q: %w", r.Id, err)
+	}
+	if (r.Cron == "") == (r.At == "") {
+		return fmt.Errorf("rule %q: exactly one of cron and at is needed", r.Id)
+	}
+	loc, err := s.location(r)
+	if err != nil {
+		return fmt.Errorf("rule %q: %w", r.Id, err)
+	}
+	if r.Cron != "" {
+		_, err = parseCron(r.Cron)
+	} else {
+		_, err = parseAt(r.At, loc)
+	}
+	if err != nil {
+		return fmt.Errorf("rule %q: %w", r.Id, err)
+	}
+	return nil
+}
+
+// keepRun Take over Created and LastRun of a known rule with the same Id, or set Created for a new one
+func (s *Scheduler) keepRun(r Rule) Rule {
+	for _, known := range s.rules {
+		if known.Id == r.Id {
+			r.Created = known.Created
+			r.LastRun = known.LastRun
+			return r
+		}
+	}
+	r.Created = s.clock()
+	r.LastRun = time.Time{}
+	return r
+}
+
+func (s *Scheduler) clock() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
+
+func (s *Scheduler) park(Name string) (Park, error) {
+	for _, p := range s.Parks {
+		if p.Config.Name == Name {
+			return p, nil
+		}
+	}
+	return Park{}, fmt.Errorf("park %q not found", Name)
+}
+
+func (s *Scheduler) location(r Rule) (*time.Location, error) {
+	if r.TimeZone != "" {
+		return time.LoadLocation(r.TimeZone)
+	}
+	if s.Location != nil {
+		return s.Location, nil
+	}
+	return time.Local, nil
+}
+
+// save Write the state atomically to StatePath. s.mu has to be held.
+func (s *Scheduler) save() error {
+	if s.StatePath == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(state{Rules: s.rules, Results: s.results}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.StatePath + ".tmp"
+	if err = os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.StatePath)
+}
+
+func (s *Scheduler) log(ctx context.Context, level slog.Level, r Rule, PlantNo uint8, msg string) {
+	if p, err := s.park(r.Park); err == nil {
+		ctx = p.Config.Context(ctx)
+	}
+	energontrol.Logger(ctx).LogAttrs(ctx, level, msg, slog.Int(energontrol.LogKeyPlantNo, int(PlantNo)), slog.String(energontrol.LogKeyAction, "Schedule"))
+}
+
+// parseAt Parse the time of a one-shot rule. Times without offset are in loc.
+func parseAt(At string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, At); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04", "2006-01-02T15:04", "2006-01-02 15:04:05"} {
+		if t, err := time.ParseInLocation(layout, At, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, expected RFC 3339 or \"2006-01-02 15:04\"", At)
+}
+
+func newId() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
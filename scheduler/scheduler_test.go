@@ -0,0 +1,104 @@
+package scheduler
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dernate/energontrol"
+	"github.com/dernate/energontrol/energontroltest"
+)
+
+func TestCronNext(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	tests := []struct {
+		expr  string
+		after string
+		want  string
+	}{
+		{"0 22 * * *", "2026-10-19T21:59:00+02:00", "2026-10-19T22:00:00+02:00"},
+		{"0 22 * * *", "2026-10-19T22:00:00+02:00", "2026-10-20T22:00:00+02:00"},
+		{"*/15 6-7 * * 1-5", "2026-10-23T07:50:00+02:00", "2026-10-26T06:00:00+01:00"}, // Friday, DST ends on Sunday
+		{"0 8 * * 2", "2026-10-19T12:00:00+02:00", "2026-10-20T08:00:00+02:00"},
+		{"0 0 1,15 * 0", "2026-10-19T12:00:00+02:00", "2026-10-25T00:00:00+02:00"}, // day of month OR Sunday
+		{"@monthly", "2026-12-31T23:59:00+01:00", "2027-01-01T00:00:00+01:00"},
+	}
+	for _, tt := range tests {
+		c, err := parseCron(tt.expr)
+		if err != nil {
+			t.Fatalf("Error: %s: %v", tt.expr, err)
+		}
+		after, _ := time.Parse(time.RFC3339, tt.after)
+		want, _ := time.Parse(time.RFC3339, tt.want)
+		if got := c.next(after, berlin); !got.Equal(want) {
+			t.Errorf("Error: %s after %s: got %s, want %s", tt.expr, tt.after, got, tt.want)
+		}
+	}
+	for _, expr := range []string{"0 22 * *", "60 * * * *", "0 22 * * 8", "5-1 * * * *", "*/0 * * * *"} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("Error: %q should be invalid", expr)
+		}
+	}
+}
+
+func TestRunDue(t *testing.T) {
+	ctx := context.Background()
+	scada := energontroltest.NewScada(4711, 3, 5, 7)
+	park := Park{
+		Config:     energontrol.ParkConfig{Name: "north", UserId: energontrol.UserIdSource{Value: 1234}, Aliases: map[string]uint8{"WEA7": 7}},
+		Controller: scada,
+	}
+	statePath := filepath.Join(t.TempDir(), "schedule.json")
+	created := func() time.Time { return time.Date(2026, 10, 20, 7, 0, 0, 0, time.UTC) }
+	s := &Scheduler{Parks: []Park{park}, StatePath: statePath, Location: time.UTC, now: created}
+	window := MaintenanceWindow("service", "north", []string{"WEA7"}, energontrol.ActionStop90, energontrol.ActionStart,
+		time.Date(2026, 10, 20, 8, 0, 0, 0, time.UTC), time.Date(2026, 10, 20, 16, 0, 0, 0, time.UTC))
+	rules := append(window, Rule{Id: "noise", Park: "north", Action: energontrol.ActionStop60, Plants: []string{"3", "5"}, Cron: "0 22 * * *"})
+	if err := s.SetRules(rules); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Add(Rule{Park: "north", Action: "Explode", Plants: []string{"3"}, At: "2026-10-20 08:00"}); err == nil {
+		t.Error("Error: unknown action accepted")
+	}
+
+	if results := s.RunDue(ctx, time.Date(2026, 10, 20, 8, 0, 30, 0, time.UTC)); len(results) != 1 || results[0].RuleId != "service-begin" {
+		t.Fatalf("Error: %+v", results)
+	}
+	if p := scada.Plant(7); p.Ctrl != energontrol.CtrlValues["Stop90"] {
+		t.Errorf("Error: plant 7 Ctrl %d", p.Ctrl)
+	}
+	if results := s.RunDue(ctx, time.Date(2026, 10, 20, 8, 1, 0, 0, time.UTC)); len(results) != 0 {
+		t.Errorf("Error: one-shot rule ran again: %+v", results)
+	}
+
+	// a restarted scheduler loads the pending jobs and skips the ones missed too long ago
+	s2 := &Scheduler{Parks: []Park{park}, StatePath: statePath, Location: time.UTC, now: created}
+	if err := s2.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s2.SetRules(rules); err != nil {
+		t.Fatal(err)
+	}
+	results := s2.RunDue(ctx, time.Date(2026, 10, 20, 16, 0, 10, 0, time.UTC))
+	if len(results) != 1 || results[0].RuleId != "service-end" || !results[0].Ok[0] {
+		t.Fatalf("Error: %+v", results)
+	}
+	results = s2.RunDue(ctx, time.Date(2026, 10, 21, 1, 0, 0, 0, time.UTC))
+	if len(results) != 1 || results[0].RuleId != "noise" || results[0].Skipped == "" {
+		t.Errorf("Error: missed job not skipped: %+v", results)
+	}
+	if p := scada.Plant(3); p.Ctrl != 0 {
+		t.Errorf("Error: plant 3 Ctrl %d", p.Ctrl)
+	}
+	results = s2.RunDue(ctx, time.Date(2026, 10, 21, 22, 0, 5, 0, time.UTC))
+	if len(results) != 1 || len(results[0].Ok) != 2 || !results[0].Ok[0] || !results[0].Ok[1] {
+		t.Errorf("Error: %+v", results)
+	}
+	if n := len(s2.Results()); n != 4 {
+		t.Errorf("Error: %d results recorded", n)
+	}
+}
@@ -0,0 +1,46 @@
+package energontrol_test
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dernate/energontrol"
+	"github.com/dernate/energontrol/energontroltest"
+	"github.com/dernate/gopcxmlda"
+)
+
+func TestStopManyPlants(t *testing.T) {
+	// more plants than SessionIds, on two parks at the same time
+	var PlantNo []uint8
+	for plant := uint8(1); plant <= 25; plant++ {
+		PlantNo = append(PlantNo, plant)
+	}
+	var wg sync.WaitGroup
+	for _, park := range []string{"http://north:8080", "http://south:8080"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			scada := energontroltest.NewScada(4711, PlantNo...)
+			ctx := energontrol.WithOpcClient(context.Background(), energontroltest.NewOpcServer(scada))
+			ctx = energontrol.WithSessionWait(ctx, time.Millisecond, 50)
+			u, _ := url.Parse(park)
+			stopped, errList := energontrol.Stop(ctx, gopcxmlda.Server{Url: u}, 1234, false, false, PlantNo...)
+			if len(stopped) != len(PlantNo) {
+				t.Errorf("Error: %s: %v, %v", park, stopped, errList)
+				return
+			}
+			for i, plant := range PlantNo {
+				if !stopped[i] || errList[i] != nil {
+					t.Errorf("Error: %s plant %d: %v", park, plant, errList[i])
+				}
+				if p := scada.Plant(plant); p.Ctrl != 1 {
+					t.Errorf("Error: %s plant %d has Ctrl %d", park, plant, p.Ctrl)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
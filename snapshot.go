@@ -0,0 +1,128 @@
+package energontrol
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// DefaultSnapshotItems item paths of a common Enercon SCADA. They differ between SCADA versions,
+// check them with BrowseItems and override them per park with ParkConfig.Snapshot.
+var DefaultSnapshotItems = SnapshotItems{
+	MainStatus:   "Status/MainStatus",
+	SubStatus:    "Status/SubStatus",
+	ActivePower:  "Data/ActivePower",
+	WindSpeed:    "Data/WindSpeed",
+	Availability: "Data/Availability",
+}
+
+// PlantSnapshot the operational data of a plant. Values, which couldn't be read, are nil and their reason is in Missing.
+type PlantSnapshot struct {
+	PlantNo      uint8             `json:"plant_no"`
+	Time         time.Time         `json:"time"`
+	Ctrl         *uint64           `json:"ctrl,omitempty"`
+	Rbh          *uint64           `json:"rbh,omitempty"`
+	MainStatus   *uint64           `json:"main_status,omitempty"`
+	SubStatus    *uint64           `json:"sub_status,omitempty"`
+	ActivePower  *float64          `json:"active_power,omitempty"` // kW
+	WindSpeed    *float64          `json:"wind_speed,omitempty"`   // m/s
+	Availability *float64          `json:"availability,omitempty"` // %
+	Missing      map[string]string `json:"missing,omitempty"`      // value name -> error
+}
+
+// withDefaults Fill empty paths from DefaultSnapshotItems
+func (S SnapshotItems) withDefaults() SnapshotItems {
+	def := DefaultSnapshotItems
+	for _, f := range []struct{ path, def *string }{
+		{&S.MainStatus, &def.MainStatus},
+		{&S.SubStatus, &def.SubStatus},
+		{&S.ActivePower, &def.ActivePower},
+		{&S.WindSpeed, &def.WindSpeed},
+		{&S.Availability, &def.Availability},
+	} {
+		if *f.path == "" {
+			*f.path = *f.def
+		}
+	}
+	return S
+}
+
+// PlantSnapshots Read Ctrl, Rbh and the Items of all plants in a single batched read. A failed read fails all plants,
+// a missing or mistyped item only its value.
+func PlantSnapshots(ctx context.Context, r ItemReader, Items SnapshotItems, PlantNo ...uint8) ([]PlantSnapshot, error) {
+	ctx, span := startSpan(ctx, "PlantSnapshots", plantsAttr(PlantNo))
+	defer span.End()
+	if len(PlantNo) == 0 {
+		return nil, fmt.Errorf("no PlantNo provided")
+	}
+	Items = Items.withDefaults()
+	type field struct {
+		name     string
+		path     string
+		unsigned **uint64
+		float    **float64
+	}
+	snapshots := make([]PlantSnapshot, len(PlantNo))
+	var names []string
+	var fields [][]field
+	for i, plant := range PlantNo {
+		s := &snapshots[i]
+		s.PlantNo = plant
+		plantFields := []field{
+			{"ctrl", "Ctrl/Ctrl", &s.Ctrl, nil},
+			{"rbh", "Ctrl/Rbh", &s.Rbh, nil},
+			{"main_status", Items.MainStatus, &s.MainStatus, nil},
+			{"sub_status", Items.SubStatus, &s.SubStatus, nil},
+			{"active_power", Items.ActivePower, nil, &s.ActivePower},
+			{"wind_speed", Items.WindSpeed, nil, &s.WindSpeed},
+			{"availability", Items.Availability, nil, &s.Availability},
+		}
+		var used []field
+		for _, f := range plantFields {
+			if f.path == "-" {
+				continue
+			}
+			names = append(names, PlantItem(plant, f.path))
+			used = append(used, f)
+		}
+		fields = append(fields, used)
+	}
+	values, err := r.ReadItems(ctx, names...)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	k := 0
+	for i := range snapshots {
+		s := &snapshots[i]
+		s.Time = now
+		for _, f := range fields[i] {
+			var v ItemValue
+			if k < len(values) {
+				v = values[k]
+			} else {
+				v = ItemValue{ItemName: names[k], Err: fmt.Errorf("item %s not returned by the server", names[k])}
+			}
+			k++
+			var err error
+			if f.unsigned != nil {
+				var u uint64
+				if u, err = v.Uint64(); err == nil {
+					*f.unsigned = &u
+				}
+			} else {
+				var x float64
+				if x, err = v.Float64(); err == nil {
+					*f.float = &x
+				}
+			}
+			if err != nil {
+				if s.Missing == nil {
+					s.Missing = make(map[string]string)
+				}
+				s.Missing[f.name] = err.Error()
+			}
+		}
+	}
+	return snapshots, nil
+}
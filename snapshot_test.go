@@ -0,0 +1,55 @@
+package energontrol_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dernate/energontrol"
+	"github.com/dernate/energontrol/energontroltest"
+)
+
+func TestPlantSnapshots(t *testing.T) {
+	scada := energontroltest.NewScada(4711, 1, 2)
+	scada.Items = map[string]any{
+		"Loc/Wec/Plant1/Ctrl/Ctrl":         uint64(0),
+		"Loc/Wec/Plant1/Ctrl/Rbh":          uint64(energontroltest.RbhStandardState),
+		"Loc/Wec/Plant1/Status/MainStatus": int32(2),
+		"Loc/Wec/Plant1/Status/SubStatus":  uint16(0),
+		"Loc/Wec/Plant1/Data/ActivePower":  float32(1520),
+		"Loc/Wec/Plant1/Data/WindSpeed":    float64(9.5),
+		"Loc/Wec/Plant1/Data/Power10min":   float32(1400),
+		"Loc/Wec/Plant2/Ctrl/Ctrl":         uint64(1),
+		"Loc/Wec/Plant2/Status/MainStatus": "unknown",
+		"Loc/Wec/Plant2/Data/ActivePower":  float32(0),
+		"Loc/Wec/Plant2/Data/WindSpeed":    float64(3.25),
+		"Loc/Wec/Plant2/Data/Availability": float64(99.5),
+		"Loc/Wec/Plant2/Status/SubStatus":  uint16(12),
+		"Loc/Wec/Plant1/Data/Availability": float64(100),
+		"Loc/Wec/Plant2/Ctrl/Rbh":          uint64(energontroltest.RbhAutoOffState),
+	}
+	Items := energontrol.SnapshotItems{ActivePower: "Data/Power10min"}
+	snapshots, err := energontrol.PlantSnapshots(context.Background(), scada, Items, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := snapshots[0]
+	if len(s.Missing) != 0 || *s.MainStatus != 2 || *s.ActivePower != 1400 || *s.WindSpeed != 9.5 || *s.Availability != 100 {
+		t.Errorf("Error: %+v, %v", s, s.Missing)
+	}
+	s = snapshots[1]
+	if s.MainStatus != nil || s.ActivePower != nil || len(s.Missing) != 2 || s.Missing["main_status"] == "" || s.Missing["active_power"] == "" {
+		t.Errorf("Error: %+v, %v", s, s.Missing)
+	}
+	if *s.Ctrl != 1 || *s.SubStatus != 12 || *s.WindSpeed != 3.25 {
+		t.Errorf("Error: %+v", s)
+	}
+
+	// skipped values are neither read nor missing
+	Items = energontrol.SnapshotItems{MainStatus: "-", SubStatus: "-", ActivePower: "-", Availability: "-"}
+	if snapshots, err = energontrol.PlantSnapshots(context.Background(), scada, Items, 2); err != nil {
+		t.Fatal(err)
+	}
+	if s = snapshots[0]; len(s.Missing) != 0 || s.MainStatus != nil || *s.WindSpeed != 3.25 {
+		t.Errorf("Error: %+v, %v", s, s.Missing)
+	}
+}
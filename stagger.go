@@ -0,0 +1,130 @@
+package energontrol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/dernate/gopcxmlda"
+	"log/slog"
+	"time"
+)
+
+// StaggeredStart Start the plants in groups of Opts.GroupSize with Opts.Delay between the groups, e.g. to respect the ramp rate of a grid code.
+// Plants of groups not started because of an abort or a canceled ctx get an error.
+func StaggeredStart(ctx context.Context, Server gopcxmlda.Server, UserId uint64, Opts StaggerOptions, PlantNo ...uint8) ([]bool, []error) {
+	return DoStaggeredStart(ctx, ServerController{Server: Server}, UserId, Opts, PlantNo...)
+}
+
+// DoStaggeredStart StaggeredStart with Controller c
+func DoStaggeredStart(ctx context.Context, c Controller, UserId uint64, Opts StaggerOptions, PlantNo ...uint8) ([]bool, []error) {
+	ctx, span := startSpan(ctx, "StaggeredStart", plantsAttr(PlantNo))
+	started := make([]bool, len(PlantNo))
+	errList := make([]error, len(PlantNo))
+	groupSize := Opts.GroupSize
+	if groupSize < 1 {
+		groupSize = 1
+	}
+	var abort error
+	for first := 0; first < len(PlantNo); first += groupSize {
+		last := min(first+groupSize, len(PlantNo))
+		group := PlantNo[first:last]
+		if abort == nil && first > 0 && Opts.Delay > 0 {
+			select {
+			case <-ctx.Done():
+				abort = ctx.Err()
+			case <-time.After(Opts.Delay):
+			}
+		}
+		if abort == nil {
+			abort = ctx.Err()
+		}
+		if abort != nil {
+			for i := first; i < last; i++ {
+				errList[i] = fmt.Errorf("not started: %w", abort)
+			}
+			continue
+		}
+		ok, errs := c.Start(ctx, UserId, group...)
+		for i := range group {
+			started[first+i] = i < len(ok) && ok[i]
+			if i < len(errs) {
+				errList[first+i] = errs[i]
+			}
+		}
+		if Opts.Verify {
+			verifyStarted(ctx, c, Opts, group, started[first:last], errList[first:last])
+		}
+		for i := first; i < last; i++ {
+			if !started[i] && Opts.AbortOnFailure {
+				abort = fmt.Errorf("plant %d of the previous group failed", PlantNo[i])
+				logPlant(ctx, slog.LevelWarn, PlantNo[i], "StaggeredStart", "Aborting the staggered start")
+				break
+			}
+		}
+	}
+	var failed []error
+	for i := range PlantNo {
+		if errList[i] != nil {
+			failed = append(failed, fmt.Errorf("plant %d: %w", PlantNo[i], errList[i]))
+		}
+	}
+	endSpan(span, errors.Join(failed...))
+	return started, errList
+}
+
+// verifyStarted Poll the Ctrl state of the started plants of group until it is 0 for all or Opts.VerifyTimeout is over
+func verifyStarted(ctx context.Context, c Controller, Opts StaggerOptions, group []uint8, started []bool, errList []error) {
+	timeout := Opts.VerifyTimeout
+	if timeout == 0 {
+		timeout = 60 * time.Second
+	}
+	interval := Opts.VerifyInterval
+	if interval == 0 {
+		interval = 2 * time.Second
+	}
+	deadline := time.Now().Add(timeout)
+	last := make(map[uint8]uint64)
+	for {
+		var pending []uint8
+		for i, plant := range group {
+			if started[i] {
+				if ctrl, ok := last[plant]; !ok || ctrl != 0 {
+					pending = append(pending, plant)
+				}
+			}
+		}
+		if len(pending) == 0 {
+			return
+		}
+		states, err := c.State(ctx, "Ctrl", pending)
+		if err == nil {
+			for _, s := range states {
+				if s.Err == nil {
+					last[s.PlantNo] = s.CtrlState
+				}
+			}
+		}
+		if time.Now().After(deadline) || ctx.Err() != nil {
+			for i, plant := range group {
+				ctrl, ok := last[plant]
+				if !started[i] || (ok && ctrl == 0) {
+					continue
+				}
+				started[i] = false
+				if !ok {
+					if err == nil {
+						err = errors.New("no Ctrl state read")
+					}
+					errList[i] = fmt.Errorf("start not verified: %w", err)
+				} else {
+					errList[i] = fmt.Errorf("start not verified: Ctrl state is %s after %s", CtrlStateText(ctrl), timeout)
+				}
+			}
+			return
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package energontrol_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dernate/energontrol"
+	"github.com/dernate/energontrol/energontroltest"
+)
+
+func TestStaggeredStart(t *testing.T) {
+	scada := energontroltest.NewScada(4711, 1, 2, 3, 4, 5)
+	for _, plant := range []uint8{1, 2, 3, 4, 5} {
+		scada.SetPlant(plant, energontroltest.Plant{Ctrl: energontrol.CtrlValues["Stop60"], Rbh: energontroltest.RbhStandardState})
+	}
+	scada.SetPlant(3, energontroltest.Plant{Ctrl: energontrol.CtrlValues["Stop60"], SessionState: 108})
+	Opts := energontrol.StaggerOptions{GroupSize: 2, Delay: 20 * time.Millisecond, Verify: true, VerifyInterval: time.Millisecond, AbortOnFailure: true}
+	begin := time.Now()
+	started, errList := energontrol.DoStaggeredStart(context.Background(), scada, 1234, Opts, 1, 2, 3, 4, 5)
+	want := []bool{true, true, false, true, false}
+	for i := range want {
+		if started[i] != want[i] {
+			t.Errorf("Error: plant %d started %t, want %t (%v)", i+1, started[i], want[i], errList[i])
+		}
+	}
+	if errList[2] == nil || errList[4] == nil {
+		t.Errorf("Error: %v", errList)
+	}
+	calls := scada.CallLog()
+	if len(calls) != 2 || len(calls[0].PlantNo) != 2 || calls[1].PlantNo[0] != 3 {
+		t.Errorf("Error: %+v", calls)
+	}
+	if d := time.Since(begin); d < Opts.Delay {
+		t.Errorf("Error: no delay between the groups: %s", d)
+	}
+	if p := scada.Plant(5); p.Ctrl != energontrol.CtrlValues["Stop60"] {
+		t.Errorf("Error: plant 5 of the aborted group was started")
+	}
+}
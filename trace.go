@@ -0,0 +1,81 @@
+package energontrol
+
+import (
+	"context"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/dernate/energontrol"
+
+// WithTracerProvider returns a context, which creates the spans of calls with it by tp instead of the global TracerProvider of otel
+func WithTracerProvider(ctx context.Context, tp trace.TracerProvider) context.Context {
+	return context.WithValue(ctx, tracerProviderKey, tp)
+}
+
+// startSpan Start a span as child of the span in ctx. Without a configured TracerProvider, otel returns a no-op span.
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	tp, ok := ctx.Value(tracerProviderKey).(trace.TracerProvider)
+	if !ok {
+		tp = otel.GetTracerProvider()
+	}
+	return tp.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan End the span and record err, if any
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func plantAttr(PlantNo uint8) attribute.KeyValue {
+	return attribute.Int("energontrol.plant", int(PlantNo))
+}
+
+func plantsAttr(PlantNo []uint8) attribute.KeyValue {
+	plants := make([]int, len(PlantNo))
+	for i, plant := range PlantNo {
+		plants[i] = int(plant)
+	}
+	return attribute.IntSlice("energontrol.plants", plants)
+}
+
+func sessionStateAttr(State uint16) attribute.KeyValue {
+	return attribute.Int("energontrol.session.state", int(State))
+}
+
+// plantSessions the spans of the sessions of several plants, each the parent of the session steps of its plant
+type plantSessions struct {
+	ctx   []context.Context
+	spans []trace.Span
+}
+
+// startPlantSessions Start a "Session <SessionType>" span per plant as child of the span in ctx
+func startPlantSessions(ctx context.Context, SessionType string, PlantNo []uint8) plantSessions {
+	s := plantSessions{ctx: make([]context.Context, len(PlantNo)), spans: make([]trace.Span, len(PlantNo))}
+	for i, plant := range PlantNo {
+		s.ctx[i], s.spans[i] = startSpan(ctx, "Session "+SessionType, plantAttr(plant), attribute.String("energontrol.session.type", SessionType))
+	}
+	return s
+}
+
+// state Record the session state read for each plant, which didn't fail yet
+func (s plantSessions) state(SesState []uint16, SesErr []error, errList []error) {
+	for i, span := range s.spans {
+		if SesErr[i] == nil && errList[i] == nil {
+			span.SetAttributes(sessionStateAttr(SesState[i]))
+		}
+	}
+}
+
+// end End the spans with the errors of the plants
+func (s plantSessions) end(errList []error) {
+	for i, span := range s.spans {
+		endSpan(span, errList[i])
+	}
+}
@@ -0,0 +1,44 @@
+// Package tracing sets up an OpenTelemetry TracerProvider for the spans of energontrol.
+//
+// energontrol creates a span per public operation with child spans for the session phases and the session of each plant,
+// which is the parent of the plant's session steps, and a span for every OPC request. Without a TracerProvider these spans are no-ops.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// Setup Install a global TracerProvider exporting to Exporter, which is "stdout" or "otlp".
+// The otlp exporter sends to a collector via HTTP and is configured by the OTEL_EXPORTER_OTLP_* environment variables,
+// by default localhost:4318. Call the returned function on shutdown to flush the remaining spans.
+func Setup(ctx context.Context, Exporter string, ServiceName string) (func(context.Context) error, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch Exporter {
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout), stdouttrace.WithPrettyPrint())
+	case "otlp":
+		exporter, err = otlptracehttp.New(ctx)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q, use stdout or otlp", Exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(ServiceName)))
+	if err != nil {
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
@@ -0,0 +1,100 @@
+package tracing
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/dernate/energontrol"
+	"github.com/dernate/energontrol/energontroltest"
+	"github.com/dernate/gopcxmlda"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// attr Get the value of an attribute of the span
+func attr(s sdktrace.ReadOnlySpan, key attribute.Key) (attribute.Value, bool) {
+	for _, kv := range s.Attributes() {
+		if kv.Key == key {
+			return kv.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func TestStopSpans(t *testing.T) {
+	scada := energontroltest.NewScada(4711, 1, 2)
+	scada.Plants[2].SessionState = 108
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+	ctx := energontrol.WithTracerProvider(context.Background(), tp)
+	opc := energontroltest.NewOpcServer(scada)
+	opc.SessionEnd = time.Minute // plant 1 stays in state 4, while the waits retry for the occupied plant 2
+	ctx = energontrol.WithOpcClient(ctx, opc)
+	ctx = energontrol.WithSessionWait(ctx, time.Millisecond, 3)
+	ok, errList := energontrol.Stop(ctx, gopcxmlda.Server{}, 1234, false, false, 1, 2)
+	if !ok[0] || errList[0] != nil || ok[1] || errList[1] == nil {
+		t.Fatalf("Error: %v %v", ok, errList)
+	}
+
+	spans := sr.Ended()
+	var root sdktrace.ReadOnlySpan
+	sessions := make(map[int64]sdktrace.ReadOnlySpan) // plant -> session span
+	for _, s := range spans {
+		switch s.Name() {
+		case "Stop60":
+			root = s
+		case "Session Ctrl":
+			plant, _ := attr(s, "energontrol.plant")
+			sessions[plant.AsInt64()] = s
+		}
+	}
+	if root == nil || len(sessions) != 2 {
+		t.Fatalf("Error: root %v, sessions %v", root, sessions)
+	}
+	for plant, s := range sessions {
+		if s.Parent().SpanID() != root.SpanContext().SpanID() {
+			t.Errorf("Error: session of plant %d isn't a child of Stop60", plant)
+		}
+	}
+	// the last session state read of each plant, the occupied plant fails
+	if state, _ := attr(sessions[1], "energontrol.session.state"); state.AsInt64() != 4 || sessions[1].Status().Code == codes.Error {
+		t.Errorf("Error: plant 1 session state %v, status %v", state, sessions[1].Status())
+	}
+	if state, _ := attr(sessions[2], "energontrol.session.state"); state.AsInt64() != 108 || sessions[2].Status().Code != codes.Error {
+		t.Errorf("Error: plant 2 session state %v, status %v", state, sessions[2].Status())
+	}
+
+	// the session steps of a plant are children of its session span, the waits for a state of Stop60
+	var steps []string
+	var waits []int64
+	for _, s := range spans {
+		switch s.Name() {
+		case "SessionRequest", "SessionPubKey", "SetCtrl", "SetRbh", "SessionSubmit":
+			plant, _ := attr(s, "energontrol.plant")
+			if parent := sessions[plant.AsInt64()]; parent == nil || s.Parent().SpanID() != parent.SpanContext().SpanID() {
+				t.Errorf("Error: %s of plant %d isn't a child of its session", s.Name(), plant.AsInt64())
+			}
+			steps = append(steps, s.Name())
+		}
+		if desired, ok := attr(s, "energontrol.session.desired_state"); ok {
+			if s.Parent().SpanID() != root.SpanContext().SpanID() {
+				t.Errorf("Error: %s isn't a child of Stop60", s.Name())
+			}
+			states, _ := attr(s, "energontrol.session.states")
+			if got := states.AsInt64Slice(); len(got) != 2 || got[1] != 108 || desired.AsInt64() > 0 && got[0] != desired.AsInt64() {
+				t.Errorf("Error: %s states %v", s.Name(), got)
+			}
+			waits = append(waits, desired.AsInt64())
+		}
+	}
+	if !slices.Equal(steps, []string{"SessionRequest", "SessionPubKey", "SetCtrl", "SessionSubmit"}) {
+		t.Errorf("Error: steps %v", steps)
+	}
+	if !slices.Equal(waits, []int64{0, 1, 2, 4}) {
+		t.Errorf("Error: waits for %v", waits)
+	}
+}
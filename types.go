@@ -6,6 +6,7 @@ type PlantState struct {
 	PlantNo   uint8
 	CtrlState uint64
 	Action    bool
+	Err       error // the state couldn't be read, CtrlState is then 255 (CommunicationError) for Ctrl and 0 (no access) for Rbh
 }
 
 type SessionRequest struct {
@@ -29,6 +30,16 @@ type ControlAndRbhValue struct {
 	RbhAction    []bool
 }
 
+// ServerStatus the result of GetStatus. State is "running", if the server works.
+type ServerStatus struct {
+	State          string    `json:"state"`
+	ReplyTime      time.Time `json:"reply_time"`
+	StartTime      time.Time `json:"start_time"`
+	VendorInfo     string    `json:"vendor_info"`
+	ProductVersion string    `json:"product_version"`
+	StatusInfo     string    `json:"status_info,omitempty"`
+}
+
 type TurbineInfo struct {
 	ParkNo  uint64
 	PlantNo []uint8
@@ -38,3 +49,48 @@ type TurbineInfo struct {
 	Para    map[uint8]bool
 	IceDet  map[uint8]bool
 }
+
+type FleetConfig struct {
+	Parks []ParkConfig `yaml:"parks"`
+}
+
+type ParkConfig struct {
+	Name     string           `yaml:"name"`
+	Url      string           `yaml:"url"`
+	LocaleID string           `yaml:"locale"`
+	Timeout  time.Duration    `yaml:"timeout"`
+	ParkNo   uint64           `yaml:"park_no"`
+	Plants   []uint8          `yaml:"plants"`
+	Aliases  map[string]uint8 `yaml:"aliases"`
+	UserId   UserIdSource     `yaml:"user_id"`
+	Session  SessionTiming    `yaml:"session"`
+	Snapshot SnapshotItems    `yaml:"snapshot"`
+}
+
+type UserIdSource struct {
+	Value uint64 `yaml:"value"`
+	Env   string `yaml:"env"`
+}
+
+type SessionTiming struct {
+	Sleep   time.Duration `yaml:"sleep"`
+	Retries uint          `yaml:"retries"`
+}
+
+// SnapshotItems item paths below Loc/Wec/Plant<N> read by PlantSnapshots. Empty paths use DefaultSnapshotItems, "-" skips the value.
+type SnapshotItems struct {
+	MainStatus   string `yaml:"main_status"`
+	SubStatus    string `yaml:"sub_status"`
+	ActivePower  string `yaml:"active_power"` // kW
+	WindSpeed    string `yaml:"wind_speed"`   // m/s
+	Availability string `yaml:"availability"` // %
+}
+
+type StaggerOptions struct {
+	GroupSize      int           // plants started together, 1 if 0
+	Delay          time.Duration // pause between two groups
+	Verify         bool          // wait until the Ctrl state of every plant of a group is 0 before the next group
+	VerifyTimeout  time.Duration // 60s if 0
+	VerifyInterval time.Duration // 2s if 0
+	AbortOnFailure bool          // don't start the remaining groups, if a plant of a group failed
+}
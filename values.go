@@ -0,0 +1,200 @@
+package energontrol
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/dernate/gopcxmlda"
+)
+
+// ItemError an item of a request failed on the server, e.g. with E_UNKNOWNITEMNAME
+type ItemError struct {
+	ItemName string
+	ResultID string
+	Text     string // error text of the server, if it sent one
+}
+
+func (e *ItemError) Error() string {
+	if e.Text != "" {
+		return fmt.Sprintf("item %s: %s (%s)", e.ItemName, e.ResultID, e.Text)
+	}
+	return fmt.Sprintf("item %s: %s", e.ItemName, e.ResultID)
+}
+
+// itemError Get the error of an item, if its ResultID is an OPC error code (E_...), with the text from the response's errors
+func itemError(item gopcxmlda.TItem, errs []gopcxmlda.TOPCError) error {
+	code := item.ResultID[strings.LastIndex(item.ResultID, ":")+1:]
+	if !strings.HasPrefix(code, "E_") {
+		return nil
+	}
+	e := &ItemError{ItemName: item.ItemName, ResultID: item.ResultID}
+	for _, opcErr := range errs {
+		if opcErr.ID == item.ResultID {
+			e.Text = opcErr.Text
+			break
+		}
+	}
+	return e
+}
+
+// itemValues Decode the values of the first n items of a read response with conv. It fails, if items are missing,
+// have an OPC error or a value which can't be converted.
+func itemValues[T any](value gopcxmlda.TRead, n int, conv func(any) (T, error)) ([]T, error) {
+	items := value.Response.ItemList.Items
+	if len(items) < n {
+		return nil, fmt.Errorf("server returned %d of %d items", len(items), n)
+	}
+	values := make([]T, n)
+	for i, item := range items[:n] {
+		if err := itemError(item, value.Response.Errors); err != nil {
+			return nil, err
+		}
+		v, err := conv(item.Value.Value)
+		if err != nil {
+			return nil, fmt.Errorf("item %s: %w", item.ItemName, err)
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// itemsByName Decode the values of the named items of a read response with conv, mapped by item name.
+// Items returned without a name are mapped by position. errs[i] is set for an item, which is missing, failed on the server
+// or can't be converted.
+func itemsByName[T any](value gopcxmlda.TRead, names []string, conv func(any) (T, error)) ([]T, []error) {
+	items := value.Response.ItemList.Items
+	byName := make(map[string]gopcxmlda.TItem, len(items))
+	for _, item := range items {
+		byName[item.ItemName] = item
+	}
+	values := make([]T, len(names))
+	errs := make([]error, len(names))
+	for i, name := range names {
+		item, ok := byName[name]
+		if !ok && i < len(items) && items[i].ItemName == "" {
+			item, ok = items[i], true
+		}
+		if !ok {
+			errs[i] = fmt.Errorf("item %s not returned by the server", name)
+			continue
+		}
+		if errs[i] = itemError(item, value.Response.Errors); errs[i] != nil {
+			continue
+		}
+		v, err := conv(item.Value.Value)
+		if err != nil {
+			errs[i] = fmt.Errorf("item %s: %w", name, err)
+			continue
+		}
+		values[i] = v
+	}
+	return values, errs
+}
+
+// writeError Get the first item error of a write response
+func writeError(value gopcxmlda.TWrite) error {
+	for _, item := range value.Response.ItemList.Items {
+		if err := itemError(item, value.Response.Errors); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// toUint16 Convert an integer value of any type to uint16
+func toUint16(v any) (uint16, error) {
+	u, err := toUint64(v)
+	if err != nil {
+		return 0, err
+	}
+	if u > math.MaxUint16 {
+		return 0, fmt.Errorf("value %d overflows uint16", u)
+	}
+	return uint16(u), nil
+}
+
+// toUint64 Convert an integer value of any type, or a whole float, to uint64
+func toUint64(v any) (uint64, error) {
+	switch x := v.(type) {
+	case uint64:
+		return x, nil
+	case uint32:
+		return uint64(x), nil
+	case uint16:
+		return uint64(x), nil
+	case uint8:
+		return uint64(x), nil
+	case uint:
+		return uint64(x), nil
+	case int64, int32, int16, int8, int:
+		i, _ := toInt64(x)
+		if i < 0 {
+			return 0, fmt.Errorf("negative value %d", i)
+		}
+		return uint64(i), nil
+	case float64, float32:
+		f, _ := toFloat64(x)
+		if f < 0 || f != math.Trunc(f) || f > math.MaxUint64 {
+			return 0, fmt.Errorf("value %g is no unsigned integer", f)
+		}
+		return uint64(f), nil
+	case bool:
+		if x {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	return 0, fmt.Errorf("value %v of type %T is no integer", v, v)
+}
+
+// toInt64 Convert an integer value of any type, or a whole float, to int64
+func toInt64(v any) (int64, error) {
+	switch x := v.(type) {
+	case int64:
+		return x, nil
+	case int32:
+		return int64(x), nil
+	case int16:
+		return int64(x), nil
+	case int8:
+		return int64(x), nil
+	case int:
+		return int64(x), nil
+	case uint64, uint32, uint16, uint8, uint:
+		u, _ := toUint64(x)
+		if u > math.MaxInt64 {
+			return 0, fmt.Errorf("value %d overflows int64", u)
+		}
+		return int64(u), nil
+	case float64, float32:
+		f, _ := toFloat64(x)
+		if f != math.Trunc(f) || f < math.MinInt64 || f > math.MaxInt64 {
+			return 0, fmt.Errorf("value %g is no integer", f)
+		}
+		return int64(f), nil
+	case bool:
+		if x {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	return 0, fmt.Errorf("value %v of type %T is no integer", v, v)
+}
+
+// toFloat64 Convert a numeric value of any type to float64
+func toFloat64(v any) (float64, error) {
+	switch x := v.(type) {
+	case float64:
+		return x, nil
+	case float32:
+		return float64(x), nil
+	case int64, int32, int16, int8, int:
+		i, _ := toInt64(x)
+		return float64(i), nil
+	case uint64, uint32, uint16, uint8, uint:
+		u, _ := toUint64(x)
+		return float64(u), nil
+	}
+	return 0, fmt.Errorf("value %v of type %T is not numeric", v, v)
+}